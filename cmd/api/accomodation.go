@@ -9,10 +9,11 @@ import (
 )
 
 type CreateAccomodationPayload struct {
-	Trip_id         int64   `json:"trip_id" validate:"required"`
-	Name            string  `json:"name" validate:"required"`
-	Description     string  `json:"description" validate:"required"`
-	Price_per_night float64 `json:"price_per_night" validate:"required"`
+	Trip_id         int64         `json:"trip_id" validate:"required"`
+	Name            string        `json:"name" validate:"required"`
+	Description     string        `json:"description" validate:"required"`
+	Price_per_night MoneyPayload  `json:"price_per_night" validate:"required"`
+	Location        *PointPayload `json:"location"`
 }
 
 // CreateAccomodation godoc
@@ -46,7 +47,8 @@ func (app *application) createAccomodationHandler(w http.ResponseWriter, r *http
 		Trip_id:         payload.Trip_id,
 		Name:            payload.Name,
 		Description:     payload.Description,
-		Price_per_night: payload.Price_per_night,
+		Price_per_night: payload.Price_per_night.money(),
+		Location:        payload.Location.point(),
 	}
 
 	ctx := r.Context()
@@ -131,10 +133,11 @@ func (app *application) getAccomodationByTripIdHandler(w http.ResponseWriter, r
 }
 
 type UpdateAccomodationPayload struct {
-	ID              int64   `json:"id" validate:"required"`
-	Name            string  `json:"name" validate:"required"`
-	Description     string  `json:"description" validate:"required"`
-	Price_per_night float64 `json:"price_per_night" validate:"required"`
+	ID              int64         `json:"id" validate:"required"`
+	Name            string        `json:"name" validate:"required"`
+	Description     string        `json:"description" validate:"required"`
+	Price_per_night MoneyPayload  `json:"price_per_night" validate:"required"`
+	Location        *PointPayload `json:"location"`
 }
 
 func (app *application) updateAccomodationByID(w http.ResponseWriter, r *http.Request) {
@@ -154,7 +157,8 @@ func (app *application) updateAccomodationByID(w http.ResponseWriter, r *http.Re
 		ID:              payload.ID,
 		Name:            payload.Name,
 		Description:     payload.Description,
-		Price_per_night: payload.Price_per_night,
+		Price_per_night: payload.Price_per_night.money(),
+		Location:        payload.Location.point(),
 	}
 
 	ctx := r.Context()
@@ -169,3 +173,53 @@ func (app *application) updateAccomodationByID(w http.ResponseWriter, r *http.Re
 		return
 	}
 }
+
+// GetNearbyAccomodations godoc
+//
+// @Summary Finds accomodations nearby
+// @Description Finds the accomodations within radius_km of lat and lng, nearest first with their distance. A bbox finds those inside a map view instead. format=geojson, or accepting application/geo+json, returns a GeoJSON FeatureCollection
+// @Tags accomodations
+// @Produce json
+// @Produce application/geo+json
+// @Param lat query number false "Latitude of the center"
+// @Param lng query number false "Longitude of the center"
+// @Param radius_km query number false "Radius around the center in km, at most 500" default(50)
+// @Param bbox query string false "min_lng,min_lat,max_lng,max_lat"
+// @Param limit query int false "Most accomodations to return, at most 200" default(50)
+// @Param format query string false "geojson"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{array}		store.AccomodationDistance
+//	@Failure		400	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/accomodations/nearby [get]
+func (app *application) getNearbyAccomodationsHandler(w http.ResponseWriter, r *http.Request) {
+	search, err := parseGeoSearch(r.URL.Query())
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	accomodations, err := app.store.Accomodations.Nearby(r.Context(), *search)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if wantsGeoJSON(r) {
+		features := make([]geoJSONFeature, 0, len(accomodations))
+		for _, accomodation := range accomodations {
+			features = append(features, newGeoJSONFeature(accomodation.ID, accomodation.Location, accomodation))
+		}
+
+		if err := writeGeoJSON(w, http.StatusOK, features); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, accomodations); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -9,10 +9,11 @@ import (
 )
 
 type CreateActivityPayload struct {
-	Trip_id     int64   `json:"trip_id" validate:"required"`
-	Name        string  `json:"name" validate:"required"`
-	Description string  `json:"description" validate:"required"`
-	Price       float64 `json:"price" validate:"required"`
+	Trip_id     int64        `json:"trip_id" validate:"required"`
+	Name        string       `json:"name" validate:"required"`
+	Description string       `json:"description" validate:"required"`
+	Price       MoneyPayload `json:"price" validate:"required"`
+	Tax_exempt  bool         `json:"tax_exempt"`
 }
 
 // CreateActvity godoc
@@ -46,7 +47,8 @@ func (app *application) createActivityHandler(w http.ResponseWriter, r *http.Req
 		Trip_id:     payload.Trip_id,
 		Name:        payload.Name,
 		Description: payload.Description,
-		Price:       payload.Price,
+		Price:       payload.Price.money(),
+		Tax_exempt:  payload.Tax_exempt,
 	}
 
 	ctx := r.Context()
@@ -131,10 +133,11 @@ func (app *application) getActivityByTripIdHandler(w http.ResponseWriter, r *htt
 }
 
 type UpdateActivityPayload struct {
-	ID          int64   `json:"id" validate:"required"`
-	Name        string  `json:"name" validate:"required"`
-	Description string  `json:"description" validate:"required"`
-	Price       float64 `json:"price" validate:"required"`
+	ID          int64        `json:"id" validate:"required"`
+	Name        string       `json:"name" validate:"required"`
+	Description string       `json:"description" validate:"required"`
+	Price       MoneyPayload `json:"price" validate:"required"`
+	Tax_exempt  bool         `json:"tax_exempt"`
 }
 
 func (app *application) updateActivityByID(w http.ResponseWriter, r *http.Request) {
@@ -154,7 +157,8 @@ func (app *application) updateActivityByID(w http.ResponseWriter, r *http.Reques
 		ID:          payload.ID,
 		Name:        payload.Name,
 		Description: payload.Description,
-		Price:       payload.Price,
+		Price:       payload.Price.money(),
+		Tax_exempt:  payload.Tax_exempt,
 	}
 
 	ctx := r.Context()
@@ -7,6 +7,9 @@ import (
 	"net/http"
 	"time"
 	"transportService/docs"
+	"transportService/internal/auth"
+	"transportService/internal/mailer"
+	"transportService/internal/payment"
 	"transportService/internal/store"
 
 	"github.com/go-chi/chi/v5"
@@ -16,14 +19,98 @@ import (
 )
 
 type application struct {
-	config config
-	store  store.Storage
-	logger *zap.SugaredLogger
+	config        config
+	store         store.Storage
+	logger        *zap.SugaredLogger
+	authenticator auth.Authenticator
+	mailer        mailer.Client
+	payments      payment.Provider
 }
 
 type config struct {
-	addr string
-	db   dbConfig
+	addr        string
+	db          dbConfig
+	auth        authConfig
+	mail        mailConfig
+	booking     bookingConfig
+	payment     paymentConfig
+	idempotency idempotencyConfig
+	invoice     invoiceConfig
+	schedule    scheduleConfig
+	brandName   string
+	frontendURL string
+}
+
+type paymentConfig struct {
+	provider         string
+	webhookSecret    string
+	webhookTolerance time.Duration
+	platformFee      int
+	mock             mockPaymentConfig
+}
+
+type mockPaymentConfig struct {
+	scenario string
+	delay    time.Duration
+}
+
+type invoiceConfig struct {
+	dueDays int
+	taxRate int
+	dunning dunningConfig
+}
+
+// dunningConfig schedules reminders for overdue invoices, reminders go out
+// the given days after the due date and the booking is cancelled
+// cancelAfter days after it, 0 never cancels.
+type dunningConfig struct {
+	interval    time.Duration
+	reminders   []int
+	cancelAfter int
+}
+
+// scheduleConfig keeps the departures of trip templates generated
+// horizonDays ahead, checking every interval.
+type scheduleConfig struct {
+	interval    time.Duration
+	horizonDays int
+}
+
+type idempotencyConfig struct {
+	ttl           time.Duration
+	sweepInterval time.Duration
+}
+
+type bookingConfig struct {
+	holdWindow    time.Duration
+	sweepInterval time.Duration
+}
+
+type mailConfig struct {
+	driver    string
+	fromEmail string
+	dir       string
+	smtp      smtpConfig
+	verifyExp time.Duration
+	resetExp  time.Duration
+}
+
+type smtpConfig struct {
+	host     string
+	port     int
+	username string
+	password string
+}
+
+type authConfig struct {
+	token tokenConfig
+}
+
+type tokenConfig struct {
+	secret     string
+	exp        time.Duration
+	refreshExp time.Duration
+	iss        string
 }
 
 type dbConfig struct {
@@ -59,23 +146,33 @@ func (app *application) mount() http.Handler {
 		r.Route("/login", func(r chi.Router) {
 			r.Post("/", app.logInHandler)
 		})
-		//users
-		r.Route("/users", func(r chi.Router) {
-			r.Post("/", app.createUserHandler)
-			r.Route("/id/{id}", func(r chi.Router) {
-				r.Get("/", app.getUserByIDHandler)
-				r.Delete("/", app.deleteUserByIDHandler)
-			})
-			r.Route("/email/{email}", func(r chi.Router) {
-				r.Get("/", app.getUserByEmailHandler)
-			})
+		r.Route("/token/refresh", func(r chi.Router) {
+			r.Post("/", app.refreshTokenHandler)
+		})
+		r.Route("/email/verify", func(r chi.Router) {
+			r.Post("/", app.verifyEmailHandler)
+			r.Post("/resend", app.resendVerificationHandler)
+		})
+		r.Route("/password", func(r chi.Router) {
+			r.Post("/forgot", app.forgotPasswordHandler)
+			r.Post("/reset", app.resetPasswordHandler)
+		})
+		//webhooks are authenticated by their signature
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Post("/payments", app.paymentWebhookHandler)
 		})
 		//trips
 		r.Route("/trips", func(r chi.Router) {
-			r.Post("/", app.createTripHandler)
-			r.Get("/", app.getAllTripsHandler)
+			r.With(app.AuthTokenMiddleware, app.IdempotencyMiddleware, app.RequireRole(store.RoleOperator)).Post("/", app.createTripHandler)
+			r.Get("/", app.searchTripsHandler)
+			r.Get("/search", app.fullTextSearchTripsHandler)
+			r.Get("/nearby", app.getNearbyTripsHandler)
 			r.Route("/id/{id}", func(r chi.Router) {
 				r.Get("/", app.getTripByIdHandler)
+				r.With(app.AuthTokenMiddleware, app.RequireOwnerOrRole(store.RoleAdmin, app.tripOwner)).Get("/manifest", app.getTripManifestHandler)
+				r.Get("/stops", app.getTripStopsHandler)
+				r.With(app.AuthTokenMiddleware, app.RequireOwnerOrRole(store.RoleAdmin, app.tripOwner)).Put("/stops", app.replaceTripStopsHandler)
+				r.Get("/availability", app.getTripAvailabilityHandler)
 			})
 			r.Route("/location/{location}", func(r chi.Router) {
 				r.Get("/", app.getTripsByLocationHandler)
@@ -84,117 +181,220 @@ func (app *application) mount() http.Handler {
 				r.Get("/", app.getUpcomingTripsHandler)
 			})
 		})
-		//bookings
-		r.Route("/bookings", func(r chi.Router) {
-			r.Post("/", app.createBookingHandler)
-			r.Route("/id/{id}", func(r chi.Router) {
-				r.Get("/", app.getBookingByIdHandler)
-				r.Patch("/", app.updateBookingByIdHandler)
+
+		// everything below requires a valid bearer token, only the routes
+		// above are public: health, swagger, register, login, token refresh,
+		// email verification, password reset, provider webhooks and trip
+		// browsing
+		r.Group(func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.IdempotencyMiddleware)
+
+			r.Route("/logout", func(r chi.Router) {
+				r.Post("/", app.logOutHandler)
 			})
-			r.Route("/tripId/{id}", func(r chi.Router) {
-				r.Get("/", app.getBookingsByTripIdHandler)
+			//users
+			r.Route("/users", func(r chi.Router) {
+				r.With(app.RequireRole(store.RoleAdmin)).Post("/", app.createUserHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.With(app.RequireOwnerOrRole(store.RoleAdmin, app.userParamOwner)).Get("/", app.getUserByIDHandler)
+					r.With(app.RequireRole(store.RoleAdmin)).Delete("/", app.deleteUserByIDHandler)
+					r.With(app.RequireRole(store.RoleAdmin)).Delete("/sessions", app.revokeUserSessionsHandler)
+				})
+				r.Route("/email/{email}", func(r chi.Router) {
+					r.Use(app.RequireRole(store.RoleAdmin))
+					r.Get("/", app.getUserByEmailHandler)
+				})
 			})
-			r.Route("/userId/{id}", func(r chi.Router) {
-				r.Get("/", app.getBookingByUserIdHandler)
+			//bookings
+			r.Route("/bookings", func(r chi.Router) {
+				r.Post("/", app.createBookingHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnersOrRole(store.RoleAdmin, app.bookingOwners))
+					r.Get("/", app.getBookingByIdHandler)
+					r.Patch("/", app.updateBookingByIdHandler)
+					r.Get("/history", app.getBookingHistoryHandler)
+					r.Get("/quote", app.getBookingQuoteHandler)
+					r.Get("/confirmation.pdf", app.getBookingConfirmationPdfHandler)
+					r.Post("/cancel", app.cancelBookingHandler)
+					r.Get("/refunds", app.getBookingRefundsHandler)
+				})
+				r.Route("/tripId/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnerOrRole(store.RoleAdmin, app.tripOwner))
+					r.Get("/", app.getBookingsByTripIdHandler)
+				})
+				r.Route("/userId/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnerOrRole(store.RoleOperator, app.userParamOwner))
+					r.Get("/", app.getBookingByUserIdHandler)
+				})
 			})
-		})
-		//payments
-		r.Route("/payments", func(r chi.Router) {
-			r.Post("/", app.createPaymentHandler)
-			r.Route("/id/{id}", func(r chi.Router) {
-				r.Patch("/", app.updatePaymentByIdHandler)
+			//cancellation policies
+			r.Route("/cancellation-policies", func(r chi.Router) {
+				r.Get("/", app.getAllCancellationPoliciesHandler)
+				r.With(app.RequireRole(store.RoleOperator)).Post("/", app.createCancellationPolicyHandler)
+				r.Get("/id/{id}", app.getCancellationPolicyByIdHandler)
 			})
-			r.Route("/userId/{id}", func(r chi.Router) {
-				r.Get("/", app.getPaymentsByUserIdHandler)
+			//tax rules
+			r.Route("/tax-rules", func(r chi.Router) {
+				r.Use(app.RequireRole(store.RoleOperator))
+				r.Get("/", app.getAllTaxRulesHandler)
+				r.With(app.RequireRole(store.RoleAdmin)).Post("/", app.createTaxRuleHandler)
+				r.With(app.RequireRole(store.RoleAdmin)).Delete("/id/{id}", app.deleteTaxRuleHandler)
 			})
-		})
-		//invoices
-		r.Route("/invoices", func(r chi.Router) {
-			r.Post("/", app.createInvoiceHandler)
-			r.Route("/invoiceNumber/{invoiceNumber}", func(r chi.Router) {
-				r.Get("/", app.getInvoiceByInvoiceNumberHandler)
-				r.Patch("/", app.updateInvoiceByInvoiceNumberHandler)
+			//waitlist
+			r.Route("/waitlist", func(r chi.Router) {
+				r.Post("/", app.joinWaitlistHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnerOrRole(store.RoleOperator, app.waitlistOwner))
+					r.Delete("/", app.leaveWaitlistHandler)
+				})
+				r.Route("/tripId/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnerOrRole(store.RoleAdmin, app.tripOwner))
+					r.Get("/", app.getWaitlistByTripIdHandler)
+				})
+				r.Route("/userId/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnerOrRole(store.RoleOperator, app.userParamOwner))
+					r.Get("/", app.getWaitlistByUserIdHandler)
+				})
 			})
-		})
-		//subscriptions
-		r.Route("/subscriptions", func(r chi.Router) {
-			r.Post("/", app.createSubHandler)
-			r.Get("/", app.getAllSubsHandler)
-			r.Route("/id/{id}", func(r chi.Router) {
-				r.Delete("/", app.deleteSubByUserIdHandler)
+			//payments
+			r.Route("/payments", func(r chi.Router) {
+				r.Post("/", app.createPaymentHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnersOrRole(store.RoleAdmin, app.paymentOwners))
+					r.Get("/", app.getPaymentByIdHandler)
+					r.Post("/capture", app.capturePaymentHandler)
+				})
+				r.Route("/userId/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnerOrRole(store.RoleOperator, app.userParamOwner))
+					r.Get("/", app.getPaymentsByUserIdHandler)
+				})
 			})
-			r.Route("/email/{email}", func(r chi.Router) {
-				r.Delete("/", app.deleteSubByEmailHandler)
+			//invoices
+			r.Route("/invoices", func(r chi.Router) {
+				r.With(app.RequireRole(store.RoleOperator)).Post("/", app.createInvoiceHandler)
+				r.Route("/invoiceNumber/{invoiceNumber}", func(r chi.Router) {
+					r.With(app.RequireOwnerOrRole(store.RoleOperator, app.invoiceOwner)).Get("/", app.getInvoiceByInvoiceNumberHandler)
+					r.With(app.RequireOwnerOrRole(store.RoleOperator, app.invoiceOwner)).Get("/pdf", app.getInvoicePdfHandler)
+					r.With(app.RequireOwnerOrRole(store.RoleOperator, app.invoiceOwner)).Get("/credit-notes", app.getInvoiceCreditNotesHandler)
+					r.With(app.RequireRole(store.RoleAdmin)).Post("/credit-notes", app.createCreditNoteHandler)
+				})
+				r.Route("/userId/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnerOrRole(store.RoleOperator, app.userParamOwner))
+					r.Get("/", app.getInvoicesByUserIdHandler)
+				})
 			})
-		})
-		//photos
-		r.Route("/photos", func(r chi.Router) {
-			r.Post("/", app.createPhotoHandler)
-			r.Route("/id/{id}", func(r chi.Router) {
-				r.Get("/", app.getPhotoByIdHandler)
-				r.Delete("/", app.DeletePhotoByIdHandler)
+			//ledger
+			r.Route("/ledger", func(r chi.Router) {
+				r.With(app.RequireRole(store.RoleAdmin)).Get("/accounts", app.getLedgerAccountsHandler)
+				r.With(app.RequireRole(store.RoleAdmin)).Get("/trial-balance", app.getTrialBalanceHandler)
+				r.With(app.RequireRole(store.RoleAdmin)).Post("/payouts", app.createPayoutHandler)
+				r.Route("/operators/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnerOrRole(store.RoleAdmin, app.userParamOwner))
+					r.Get("/balance", app.getOperatorBalanceHandler)
+				})
 			})
-			r.Route("/tripId/{id}", func(r chi.Router) {
-				r.Get("/", app.getPhotosByTripIdHandler)
-				r.Delete("/", app.DeletePhotosByTripHandler)
+			//credit notes
+			r.Route("/credit-notes/creditNoteNumber/{creditNoteNumber}", func(r chi.Router) {
+				r.Use(app.RequireOwnerOrRole(store.RoleOperator, app.creditNoteOwner))
+				r.Get("/", app.getCreditNoteHandler)
+				r.Get("/pdf", app.getCreditNotePdfHandler)
 			})
-		})
-		//comments
-		r.Route("/comments", func(r chi.Router) {
-			r.Post("/", app.createCommentHandler)
-			r.Route("/id/{id}", func(r chi.Router) {
-				r.Get("/", app.getCommentByIdHandler)
-				r.Delete("/", app.deleteCommentByIdHandler)
-			})
-			r.Route("/tripId/{id}", func(r chi.Router) {
-				r.Get("/", app.getCommentsByTripIdHandler)
-				r.Delete("/", app.deleteCommentByTripIdHandler)
-			})
-		})
-		//accomodations
-		r.Route("/accomodations", func(r chi.Router) {
-			r.Post("/", app.createAccomodationHandler)
-			r.Route("/id/{id}", func(r chi.Router) {
-				r.Get("/", app.getAccomodationByIdHandler)
-				r.Patch("/", app.updateAccomodationByID)
+			//subscriptions
+			r.Route("/subscriptions", func(r chi.Router) {
+				r.Post("/", app.createSubHandler)
+				r.With(app.RequireRole(store.RoleAdmin)).Get("/", app.getAllSubsHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnerOrRole(store.RoleAdmin, app.userParamOwner))
+					r.Delete("/", app.deleteSubByUserIdHandler)
+				})
+				r.Route("/email/{email}", func(r chi.Router) {
+					r.Use(app.RequireRole(store.RoleAdmin))
+					r.Delete("/", app.deleteSubByEmailHandler)
+				})
 			})
-			r.Route("/tripId/{id}", func(r chi.Router) {
-				r.Get("/", app.getAccomodationByTripIdHandler)
+			//photos
+			r.Route("/photos", func(r chi.Router) {
+				r.With(app.RequireRole(store.RoleOperator)).Post("/", app.createPhotoHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Get("/", app.getPhotoByIdHandler)
+					r.With(app.RequireOwnerOrRole(store.RoleAdmin, app.photoOwner)).Delete("/", app.DeletePhotoByIdHandler)
+				})
+				r.Route("/tripId/{id}", func(r chi.Router) {
+					r.Get("/", app.getPhotosByTripIdHandler)
+					r.With(app.RequireOwnerOrRole(store.RoleAdmin, app.tripOwner)).Delete("/", app.DeletePhotosByTripHandler)
+				})
 			})
-		})
-		//accomodation photos
-		r.Route("/accomodationPhotos", func(r chi.Router) {
-			r.Post("/", app.createAccomodationPhotoHandler)
-			r.Route("/id/{id}", func(r chi.Router) {
-				r.Get("/", app.getAccomodationPhotoById)
-				r.Delete("/", app.deleteAccomodationPhotoById)
+			//comments
+			r.Route("/comments", func(r chi.Router) {
+				r.Post("/", app.createCommentHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Get("/", app.getCommentByIdHandler)
+					r.With(app.RequireOwnersOrRole(store.RoleAdmin, app.commentOwners)).Delete("/", app.deleteCommentByIdHandler)
+				})
+				r.Route("/tripId/{id}", func(r chi.Router) {
+					r.Get("/", app.getCommentsByTripIdHandler)
+					r.With(app.RequireOwnerOrRole(store.RoleAdmin, app.tripOwner)).Delete("/", app.deleteCommentByTripIdHandler)
+				})
 			})
-			r.Route("/accomodationId/{id}", func(r chi.Router) {
-				r.Get("/", app.getAccomodationPhotoByAccomodationId)
-				r.Delete("/", app.deleteAccomodationPhotoByAccomodationId)
+			//trip templates
+			r.Route("/trip-templates", func(r chi.Router) {
+				r.With(app.RequireRole(store.RoleOperator)).Post("/", app.createTripTemplateHandler)
+				r.With(app.RequireRole(store.RoleOperator)).Get("/", app.getTripTemplatesHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Use(app.RequireOwnerOrRole(store.RoleAdmin, app.tripTemplateOwner))
+					r.Get("/", app.getTripTemplateByIdHandler)
+					r.Patch("/", app.updateTripTemplateHandler)
+					r.Get("/departures", app.getTripTemplateDeparturesHandler)
+					r.Post("/cancel", app.cancelTripTemplateDeparturesHandler)
+				})
 			})
-		})
-		//activities
-		r.Route("/activity", func(r chi.Router) {
-			r.Post("/", app.createActivityHandler)
-			r.Route("/id/{id}", func(r chi.Router) {
-				r.Get("/", app.getActivityByIdHandler)
-				r.Patch("/", app.updateActivityByID)
+			//accomodations
+			r.Route("/accomodations", func(r chi.Router) {
+				r.With(app.RequireRole(store.RoleOperator)).Post("/", app.createAccomodationHandler)
+				r.Get("/nearby", app.getNearbyAccomodationsHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Get("/", app.getAccomodationByIdHandler)
+					r.With(app.RequireRole(store.RoleOperator)).Patch("/", app.updateAccomodationByID)
+				})
+				r.Route("/tripId/{id}", func(r chi.Router) {
+					r.Get("/", app.getAccomodationByTripIdHandler)
+				})
 			})
-			r.Route("/tripId/{id}", func(r chi.Router) {
-				r.Get("/", app.getActivityByTripIdHandler)
+			//accomodation photos
+			r.Route("/accomodationPhotos", func(r chi.Router) {
+				r.With(app.RequireRole(store.RoleOperator)).Post("/", app.createAccomodationPhotoHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Get("/", app.getAccomodationPhotoById)
+					r.With(app.RequireRole(store.RoleOperator)).Delete("/", app.deleteAccomodationPhotoById)
+				})
+				r.Route("/accomodationId/{id}", func(r chi.Router) {
+					r.Get("/", app.getAccomodationPhotoByAccomodationId)
+					r.With(app.RequireRole(store.RoleOperator)).Delete("/", app.deleteAccomodationPhotoByAccomodationId)
+				})
 			})
-		})
-		//activity photos
-		r.Route("/activityPhotos", func(r chi.Router) {
-			r.Post("/", app.createActivityHandler)
-			r.Route("/id/{id}", func(r chi.Router) {
-				r.Get("/", app.getActivityByIdHandler)
-				r.Delete("/", app.deleteActivityPhotoById)
+			//activities
+			r.Route("/activity", func(r chi.Router) {
+				r.With(app.RequireRole(store.RoleOperator)).Post("/", app.createActivityHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Get("/", app.getActivityByIdHandler)
+					r.With(app.RequireRole(store.RoleOperator)).Patch("/", app.updateActivityByID)
+				})
+				r.Route("/tripId/{id}", func(r chi.Router) {
+					r.Get("/", app.getActivityByTripIdHandler)
+				})
 			})
-			r.Route("/activityId/{id}", func(r chi.Router) {
-				r.Get("/", app.getActivityPhotoByActivityId)
-				r.Delete("/", app.deleteActivityPhotoByActivityId)
+			//activity photos
+			r.Route("/activityPhotos", func(r chi.Router) {
+				r.With(app.RequireRole(store.RoleOperator)).Post("/", app.createActivityHandler)
+				r.Route("/id/{id}", func(r chi.Router) {
+					r.Get("/", app.getActivityByIdHandler)
+					r.With(app.RequireRole(store.RoleOperator)).Delete("/", app.deleteActivityPhotoById)
+				})
+				r.Route("/activityId/{id}", func(r chi.Router) {
+					r.Get("/", app.getActivityPhotoByActivityId)
+					r.With(app.RequireRole(store.RoleOperator)).Delete("/", app.deleteActivityPhotoByActivityId)
+				})
 			})
 		})
 	})
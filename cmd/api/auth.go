@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
-	"transportService/internal/env"
+	"transportService/internal/auth"
+	"transportService/internal/mailer"
 	"transportService/internal/store"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -22,13 +26,13 @@ type RegisterUserPayload struct {
 // Register godoc
 //
 // @Summary Registers a user
-// @Description Registers a user
+// @Description Registers a user and mails them a link to verify their email address
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param payload body	 RegisterUserPayload		true	"Post payload"
 //
-//	@Success		202		{object}	store.User
+//	@Success		201		{object}	store.User
 //	@Failure		400		{object}	error
 //	@Failure		401		{object}	error
 //	@Failure		500		{object}	error
@@ -59,6 +63,7 @@ func (app *application) RegisterUserHandler(w http.ResponseWriter, r *http.Reque
 		Email:      payload.Email,
 		Password:   hashedPassword,
 		Phone:      payload.Phone,
+		Role:       store.Role{Name: store.RoleCustomer},
 	}
 
 	ctx := r.Context()
@@ -68,13 +73,13 @@ func (app *application) RegisterUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	jwtToken, err := generateJWT(user)
-	if err != nil {
-		app.internalServerError(w, r, err)
-		return
+	// the account is usable once the email is verified, a failed send is
+	// not fatal since the user can ask for the link again
+	if err := app.sendUserToken(ctx, user, store.TokenPurposeEmailVerification); err != nil {
+		app.logger.Errorw("error sending verification email", "user_id", user.ID, "error", err.Error())
 	}
 
-	if err := app.jsonResponse(w, http.StatusCreated, jwtToken); err != nil {
+	if err := app.jsonResponse(w, http.StatusCreated, user); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -94,9 +99,10 @@ type LogInPayload struct {
 // @Produce json
 // @Param payload body	 LogInPayload		true	"Post payload"
 //
-//	@Success		202		{object}	string
+//	@Success		202		{object}	TokenResponse
 //	@Failure		400		{object}	error
 //	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
 //	@Failure		500		{object}	error
 //	@Router			/login [post]
 func (app *application) logInHandler(w http.ResponseWriter, r *http.Request) {
@@ -115,54 +121,434 @@ func (app *application) logInHandler(w http.ResponseWriter, r *http.Request) {
 
 	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
 	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	fmt.Print("User Password", user.Password)
 	if err := bcrypt.CompareHashAndPassword(
 		[]byte(user.Password), []byte(payload.Password),
 	); err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	if !user.Is_verified {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	tokens, err := app.issueTokens(ctx, user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// tokens must not be cached, nor stored for idempotent replays
+	w.Header().Set("Cache-Control", "no-store")
+	if err := app.jsonResponse(w, http.StatusCreated, tokens); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type RefreshTokenPayload struct {
+	Refresh_token string `json:"refresh_token" validate:"required"`
+}
+
+// RefreshToken godoc
+//
+// @Summary Rotates a refresh token
+// @Description Exchanges a refresh token for a new access and refresh token, a refresh token can only be used once
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body	 RefreshTokenPayload		true	"Post payload"
+//
+//	@Success		201		{object}	TokenResponse
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/token/refresh [post]
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshTokenPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	refreshToken, next, err := app.newRefreshToken()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	session, err := app.store.Sessions.Rotate(ctx, auth.HashToken(payload.Refresh_token), next)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrRefreshTokenReused):
+			app.logger.Warnw("refresh token reused, session revoked", "error", err.Error())
+			app.unauthorizedErrorResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound),
+			errors.Is(err, store.ErrSessionRevoked),
+			errors.Is(err, store.ErrRefreshTokenExpired):
+			app.unauthorizedErrorResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, session.User_id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	accessToken, err := app.generateJWT(user, session.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := app.jsonResponse(w, http.StatusCreated, app.newTokenResponse(accessToken, refreshToken)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// LogOut godoc
+//
+// @Summary Handles user log out
+// @Description Revokes the session of the access token along with all of its refresh tokens
+// @Tags auth
+// @Produce json
+// @Security ApiKeyAuth
+//
+//	@Success		204		{object}	string
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/logout [post]
+func (app *application) logOutHandler(w http.ResponseWriter, r *http.Request) {
+	session := getSessionFromContext(r)
+
+	if err := app.store.Sessions.RevokeByID(r.Context(), session.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type VerifyEmailPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// VerifyEmail godoc
+//
+// @Summary Verifies an email address
+// @Description Verifies the email address of a user with the token that was mailed to them
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body	 VerifyEmailPayload		true	"Post payload"
+//
+//	@Success		200		{object}	store.User
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/email/verify [post]
+func (app *application) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyEmailPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	userID, err := app.store.Users.Verify(ctx, auth.HashToken(payload.Token))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
+}
+
+type EmailPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ResendVerification godoc
+//
+// @Summary Resends the verification email
+// @Description Mails a new verification link if the address belongs to an unverified user, the response is the same either way
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body	 EmailPayload		true	"Post payload"
+//
+//	@Success		202		{object}	string
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/email/verify/resend [post]
+func (app *application) resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	app.mailUserToken(w, r, store.TokenPurposeEmailVerification)
+}
+
+// ForgotPassword godoc
+//
+// @Summary Starts a password reset
+// @Description Mails a password reset link if the address belongs to a user, the response is the same either way
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body	 EmailPayload		true	"Post payload"
+//
+//	@Success		202		{object}	string
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/password/forgot [post]
+func (app *application) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	app.mailUserToken(w, r, store.TokenPurposePasswordReset)
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=24"`
+}
 
-	jwtToken, err := generateJWT(user)
+// ResetPassword godoc
+//
+// @Summary Resets a password
+// @Description Sets a new password with a reset token and logs the user out everywhere
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param payload body	 ResetPasswordPayload		true	"Post payload"
+//
+//	@Success		204		{object}	string
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/password/reset [post]
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResetPasswordPayload
 
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	bytes, err := bcrypt.GenerateFromPassword([]byte(payload.Password), 10)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusCreated, jwtToken); err != nil {
+	ctx := r.Context()
+
+	if _, err := app.store.Users.ResetPassword(ctx, auth.HashToken(payload.Token), string(bytes)); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
 		app.internalServerError(w, r, err)
 		return
 	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func generateJWT(user *store.User) (string, error) {
-	var (
-		key []byte
-		t   *jwt.Token
-		s   string
-	)
+// mailUserToken mails a fresh token for purpose to the user with the email in
+// the payload. Unknown addresses get the same response so the endpoint can't
+// be used to find out who has an account.
+func (app *application) mailUserToken(w http.ResponseWriter, r *http.Request, purpose string) {
+	var payload EmailPayload
 
-	key = []byte(env.GetString("JWT_SECRET", "secret"))
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		app.internalServerError(w, r, err)
+		return
+	case purpose == store.TokenPurposeEmailVerification && user.Is_verified:
+	default:
+		if err := app.sendUserToken(ctx, user, purpose); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
 
-	t = jwt.NewWithClaims(jwt.SigningMethodHS256,
-		jwt.MapClaims{
-			"id":         user.ID,
-			"first_name": user.First_name,
-			"last_name":  user.Last_name,
-			"email":      user.Email,
-			"Phone":      user.Phone,
-			"exp":        time.Now().Add(time.Hour * 24 * 30).Unix(),
-		})
+	if err := app.jsonResponse(w, http.StatusAccepted, "if the address belongs to an account an email is on its way"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
 
-	s, err := t.SignedString(key)
+// sendUserToken stores a new single-use token for the user and mails them
+// the link to use it.
+func (app *application) sendUserToken(ctx context.Context, user *store.User, purpose string) error {
+	plain, hash, err := auth.NewOpaqueToken()
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	templateFile, path, exp := mailer.VerifyEmailTemplate, "/verify-email", app.config.mail.verifyExp
+	if purpose == store.TokenPurposePasswordReset {
+		templateFile, path, exp = mailer.ResetPasswordTemplate, "/reset-password", app.config.mail.resetExp
+	}
+
+	token := &store.UserToken{
+		User_id:    user.ID,
+		Purpose:    purpose,
+		Token_hash: hash,
+		Expires_at: time.Now().Add(exp),
+	}
+
+	if err := app.store.UserTokens.Create(ctx, token); err != nil {
+		return err
+	}
+
+	msg, err := mailer.NewMessage(templateFile, user.Email, struct {
+		Name    string
+		URL     string
+		Expires string
+	}{
+		Name:    user.First_name,
+		URL:     fmt.Sprintf("%s%s?token=%s", app.config.frontendURL, path, plain),
+		Expires: humanizeDuration(exp),
+	})
+	if err != nil {
+		return err
+	}
+
+	return app.mailer.Send(msg)
+}
+
+func humanizeDuration(d time.Duration) string {
+	switch {
+	case d >= 2*time.Hour:
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	case d >= time.Hour:
+		return "1 hour"
+	default:
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	}
+}
+
+type TokenResponse struct {
+	Access_token  string `json:"access_token"`
+	Refresh_token string `json:"refresh_token"`
+	Token_type    string `json:"token_type"`
+	Expires_in    int64  `json:"expires_in"`
+}
+
+// issueTokens starts a new session for the user and returns its first
+// access and refresh token.
+func (app *application) issueTokens(ctx context.Context, user *store.User) (*TokenResponse, error) {
+	refreshToken, token, err := app.newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &store.Session{User_id: user.ID}
+
+	if err := app.store.Sessions.Create(ctx, session, token); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := app.generateJWT(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.newTokenResponse(accessToken, refreshToken), nil
+}
+
+func (app *application) newTokenResponse(accessToken, refreshToken string) *TokenResponse {
+	return &TokenResponse{
+		Access_token:  accessToken,
+		Refresh_token: refreshToken,
+		Token_type:    "Bearer",
+		Expires_in:    int64(app.config.auth.token.exp.Seconds()),
+	}
+}
+
+func (app *application) newRefreshToken() (string, *store.RefreshToken, error) {
+	plain, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := &store.RefreshToken{
+		Token_hash: hash,
+		Expires_at: time.Now().Add(app.config.auth.token.refreshExp),
+	}
+
+	return plain, token, nil
+}
+
+func (app *application) generateJWT(user *store.User, sessionID int64) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":        strconv.FormatInt(user.ID, 10),
+		"sid":        sessionID,
+		"first_name": user.First_name,
+		"last_name":  user.Last_name,
+		"email":      user.Email,
+		"Phone":      user.Phone,
+		"role":       user.Role.Name,
+		"exp":        time.Now().Add(app.config.auth.token.exp).Unix(),
+		"iat":        time.Now().Unix(),
+		"nbf":        time.Now().Unix(),
+		"iss":        app.config.auth.token.iss,
+		"aud":        app.config.auth.token.iss,
 	}
 
-	return s, nil
+	return app.authenticator.GenerateToken(claims)
 }
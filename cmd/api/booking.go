@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"transportService/internal/store"
@@ -8,16 +10,28 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
+type PassengerPayload struct {
+	Full_name     string `json:"full_name" validate:"required,max=255"`
+	Date_of_birth string `json:"date_of_birth" validate:"required,datetime=2006-01-02"`
+	Id_document   string `json:"id_document" validate:"required,max=100"`
+	Special_needs string `json:"special_needs" validate:"max=500"`
+}
+
 type CreateBookingPayload struct {
-	User_id int64  `json:"user_id" validation:"required"`
-	Trip_id int64  `json:"trip_id" validation:"required"`
-	Status  string `json:"status" validation:"required"`
+	User_id int64  `json:"user_id" validate:"required"`
+	Trip_id int64  `json:"trip_id" validate:"required"`
+	Status  string `json:"status" validate:"omitempty,oneof=pending"`
+	// From_stop_id and To_stop_id book part of a route with stops, the
+	// first and the last stop when left out
+	From_stop_id *int64             `json:"from_stop_id"`
+	To_stop_id   *int64             `json:"to_stop_id"`
+	Passengers   []PassengerPayload `json:"passengers" validate:"required,min=1,max=20,dive"`
 }
 
 // CreateBooking godoc
 //
 // @Summary Creates a booking
-// @Description Creates a booking
+// @Description Creates a booking for one or more passengers, each passenger takes a seat. On a route with stops the booking can board at from_stop_id and alight at to_stop_id, the seats are only taken on the legs in between
 // @Tags bookings
 // @Accept json
 // @Produce json
@@ -26,6 +40,8 @@ type CreateBookingPayload struct {
 //	@Success		202		{object}	store.Booking
 //	@Failure		400		{object}	error
 //	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
 //	@Failure		500		{object}	error
 //	@Router			/bookings [post]
 func (app *application) createBookingHandler(w http.ResponseWriter, r *http.Request) {
@@ -42,24 +58,64 @@ func (app *application) createBookingHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	booking := &store.Booking{
-		User_id: payload.User_id,
-		Trip_id: payload.Trip_id,
-		Status:  payload.Status,
+		User_id:      payload.User_id,
+		Trip_id:      payload.Trip_id,
+		Status:       payload.Status,
+		From_stop_id: payload.From_stop_id,
+		To_stop_id:   payload.To_stop_id,
+		Passengers:   newPassengers(payload.Passengers),
+	}
+	if booking.Status == "" {
+		booking.Status = store.BookingStatusPending
 	}
 
 	ctx := r.Context()
 
-	if err := app.store.Bookings.Create(ctx, booking); err != nil {
+	allowed, err := app.isOwnerOrRole(ctx, getUserFromContext(r), payload.User_id, store.RoleOperator)
+	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
+	if !allowed {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	if err := app.store.Bookings.Create(ctx, booking); err != nil {
+		switch {
+		case errors.Is(err, store.ErrTripFull), errors.Is(err, store.ErrTripCancelled), errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, err)
+		case errors.Is(err, store.ErrInvalidSegment):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
 	if err := app.jsonResponse(w, http.StatusCreated, booking); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 }
 
+func newPassengers(payload []PassengerPayload) []store.Passenger {
+	passengers := make([]store.Passenger, len(payload))
+	for i, p := range payload {
+		passengers[i] = store.Passenger{
+			Full_name:     p.Full_name,
+			Date_of_birth: p.Date_of_birth,
+			Id_document:   p.Id_document,
+			Special_needs: p.Special_needs,
+		}
+	}
+
+	return passengers
+}
+
 // getbookingbyid godoc
 //
 // @summary fetches a booking
@@ -150,7 +206,7 @@ func (app *application) getBookingByUserIdHandler(w http.ResponseWriter, r *http
 
 	ctx := r.Context()
 
-	bookings, err := app.store.Bookings.GetByID(ctx, userId)
+	bookings, err := app.store.Bookings.GetByUserID(ctx, userId)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -163,8 +219,9 @@ func (app *application) getBookingByUserIdHandler(w http.ResponseWriter, r *http
 }
 
 type UpdateBookingPayload struct {
-	ID     int64  `json:"id" validate:"required"`
-	Status string `json:"status" validate:"required"`
+	ID     int64  `json:"id"`
+	Status string `json:"status" validate:"required,oneof=confirmed checked_in completed cancelled"`
+	Reason string `json:"reason" validate:"max=255"`
 }
 
 // UpdateBooking godoc
@@ -181,9 +238,16 @@ type UpdateBookingPayload struct {
 //	@Failure		400		{object}	error
 //	@Failure		401		{object}	error
 //	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
 //	@Failure		500		{object}	error
 //	@Router			/bookings/id/{id} [patch]
 func (app *application) updateBookingByIdHandler(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
 	var payload UpdateBookingPayload
 
 	if err := readJSON(w, r, &payload); err != nil {
@@ -196,20 +260,245 @@ func (app *application) updateBookingByIdHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	booking := &store.Booking{
-		ID:     payload.ID,
-		Status: payload.Status,
+	if payload.ID != 0 && payload.ID != bookingId {
+		app.badRequestResponse(w, r, fmt.Errorf("booking id in the body does not match the url"))
+		return
 	}
 
 	ctx := r.Context()
+	user := getUserFromContext(r)
 
-	if err := app.store.Bookings.UpdateByID(ctx, booking); err != nil {
-		app.internalServerError(w, r, err)
+	// customers may cancel their own bookings, every other move is made by
+	// operators or by the system
+	if payload.Status != store.BookingStatusCancelled {
+		allowed, err := app.checkRolePrecedence(ctx, user, store.RoleOperator)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if !allowed {
+			app.forbiddenResponse(w, r)
+			return
+		}
+	}
+
+	change := &store.BookingStatusChange{
+		Booking_id: bookingId,
+		To_status:  payload.Status,
+		Changed_by: &user.ID,
+		Reason:     payload.Reason,
+	}
+
+	// cancelling through here refunds the same way the cancel endpoint does
+	var booking *store.Booking
+	if payload.Status == store.BookingStatusCancelled {
+		var cancellation *store.Cancellation
+		cancellation, err = app.store.Bookings.Cancel(ctx, change)
+		if err == nil {
+			booking = cancellation.Booking
+			app.issueRefunds(ctx, cancellation.Refunds)
+		}
+	} else {
+		booking, err = app.store.Bookings.Transition(ctx, change)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidTransition), errors.Is(err, store.ErrHoldExpired):
+			app.conflictResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
 
+	if booking.Status == store.BookingStatusCancelled {
+		app.promoteWaitlist(ctx, booking.Trip_id)
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, booking); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 }
+
+// GetBookingHistory godoc
+//
+// @Summary Fetches the status history of a booking
+// @Description Fetches every status transition of a booking with who made it, when and why
+// @Tags bookings
+// @Accept json
+// @Produce json
+// @Param id path int true "booking id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{array}		store.BookingStatusChange
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/bookings/id/{id}/history [get]
+func (app *application) getBookingHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	history, err := app.store.Bookings.GetHistory(ctx, bookingId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, history); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetBookingQuote godoc
+//
+// @Summary Prices a booking
+// @Description Prices a booking as it would be paid and invoiced now: the trip, its activities and accomodation for every passenger, with the tax included in the prices
+// @Tags bookings
+// @Produce json
+// @Param id path int true "booking id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	store.Quote
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/bookings/id/{id}/quote [get]
+func (app *application) getBookingQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	quote, err := app.store.Bookings.Quote(r.Context(), bookingId)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		case errors.Is(err, store.ErrCurrencyMismatch):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, quote); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type CancelBookingPayload struct {
+	Reason string `json:"reason" validate:"max=255"`
+}
+
+// CancelBooking godoc
+//
+// @Summary Cancels a booking
+// @Description Cancels a booking, frees its seats and refunds its payments as the trip's cancellation policy allows
+// @Tags bookings
+// @Accept json
+// @Produce json
+// @Param id path int true "booking id"
+// @Param payload body	 CancelBookingPayload		true	"Post payload"
+// @Security ApiKeyAuth
+//
+//	@Success		200		{object}	store.Cancellation
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/bookings/id/{id}/cancel [post]
+func (app *application) cancelBookingHandler(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload CancelBookingPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getUserFromContext(r)
+
+	cancellation, err := app.store.Bookings.Cancel(ctx, &store.BookingStatusChange{
+		Booking_id: bookingId,
+		Changed_by: &user.ID,
+		Reason:     payload.Reason,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidTransition):
+			app.conflictResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.issueRefunds(ctx, cancellation.Refunds)
+	app.promoteWaitlist(ctx, cancellation.Booking.Trip_id)
+
+	if err := app.jsonResponse(w, http.StatusOK, cancellation); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetBookingRefunds godoc
+//
+// @Summary Fetches the refunds of a booking
+// @Description Fetches the refunds issued when a booking was cancelled
+// @Tags bookings
+// @Produce json
+// @Param id path int true "booking id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{array}		store.Refund
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/bookings/id/{id}/refunds [get]
+func (app *application) getBookingRefundsHandler(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	refunds, err := app.store.Refunds.GetByBookingID(r.Context(), bookingId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, refunds); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"transportService/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CancellationPolicyRulePayload struct {
+	Min_days_before int `json:"min_days_before" validate:"min=0"`
+	Refund_percent  int `json:"refund_percent" validate:"min=0,max=100"`
+}
+
+type CreateCancellationPolicyPayload struct {
+	Name  string                          `json:"name" validate:"required,max=100"`
+	Rules []CancellationPolicyRulePayload `json:"rules" validate:"required,min=1,dive"`
+}
+
+// CreateCancellationPolicy godoc
+//
+// @Summary Creates a cancellation policy
+// @Description Creates a cancellation policy, each rule refunds a percentage of the payment when a booking is cancelled at least min_days_before days before the trip
+// @Tags cancellation policies
+// @Accept json
+// @Produce json
+// @Param payload body	 CreateCancellationPolicyPayload		true	"Post payload"
+// @Security ApiKeyAuth
+//
+//	@Success		201		{object}	store.CancellationPolicy
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/cancellation-policies [post]
+func (app *application) createCancellationPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateCancellationPolicyPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	policy := &store.CancellationPolicy{
+		Name: payload.Name,
+	}
+
+	for _, rule := range payload.Rules {
+		policy.Rules = append(policy.Rules, store.CancellationPolicyRule{
+			Min_days_before: rule.Min_days_before,
+			Refund_percent:  rule.Refund_percent,
+		})
+	}
+
+	if err := app.store.CancellationPolicies.Create(r.Context(), policy); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			app.conflictResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, policy); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetAllCancellationPolicies godoc
+//
+// @Summary Fetches all cancellation policies
+// @Description Fetches all cancellation policies with their rules
+// @Tags cancellation policies
+// @Produce json
+// @Security ApiKeyAuth
+//
+//	@Success		200	{array}		store.CancellationPolicy
+//	@Failure		500	{object}	error
+//	@Router			/cancellation-policies [get]
+func (app *application) getAllCancellationPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	policies, err := app.store.CancellationPolicies.GetAll(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, policies); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetCancellationPolicyById godoc
+//
+// @Summary Fetches a cancellation policy
+// @Description Fetches a cancellation policy with its rules by id
+// @Tags cancellation policies
+// @Produce json
+// @Param id path int true "Cancellation policy id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	store.CancellationPolicy
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/cancellation-policies/id/{id} [get]
+func (app *application) getCancellationPolicyByIdHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	policy, err := app.store.CancellationPolicies.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, policy); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -51,6 +51,17 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
 
 	ctx := r.Context()
 
+	allowed, err := app.isOwnerOrRole(ctx, getUserFromContext(r), payload.User_id, store.RoleAdmin)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if !allowed {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
 	if err := app.store.Comments.Create(ctx, comment); err != nil {
 		app.internalServerError(w, r, err)
 		return
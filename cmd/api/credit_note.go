@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"transportService/internal/pdf"
+	"transportService/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateCreditNotePayload struct {
+	Amount MoneyPayload `json:"amount" validate:"required"`
+	Reason string       `json:"reason" validate:"required,max=500"`
+}
+
+// CreateCreditNote godoc
+//
+// @Summary Credits an invoice
+// @Description Issues a credit note against the invoice, numbered CN-<year>-<number> in its own sequence. The credit is spread over the invoice lines in proportion to their totals and can't exceed what is left to credit, an unpaid invoice credited in full is void
+// @Tags invoices
+// @Accept json
+// @Produce json
+// @Param invoiceNumber path string true "invoice number"
+// @Param payload body	 CreateCreditNotePayload		true	"Post payload"
+// @Security ApiKeyAuth
+//
+//	@Success		201		{object}	store.CreditNote
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/invoices/invoiceNumber/{invoiceNumber}/credit-notes [post]
+func (app *application) createCreditNoteHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateCreditNotePayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	invoice, err := app.store.Invoices.GetByInvoiceNumber(ctx, chi.URLParam(r, "invoiceNumber"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	note := &store.CreditNote{
+		Invoice_id: invoice.ID,
+		Reason:     payload.Reason,
+		Total:      payload.Amount.money(),
+		Created_by: &user.ID,
+	}
+
+	if err := app.store.CreditNotes.Create(ctx, note); err != nil {
+		switch {
+		case errors.Is(err, store.ErrCurrencyMismatch):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, store.ErrCreditExceedsInvoice):
+			app.unprocessableEntityResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, note); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetInvoiceCreditNotes godoc
+//
+// @Summary Fetches the credit notes of an invoice
+// @Description Fetches the credit notes issued against an invoice, oldest first
+// @Tags invoices
+// @Produce json
+// @Param invoiceNumber path string true "invoice number"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{array}		store.CreditNote
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/invoices/invoiceNumber/{invoiceNumber}/credit-notes [get]
+func (app *application) getInvoiceCreditNotesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	invoice, err := app.store.Invoices.GetByInvoiceNumber(ctx, chi.URLParam(r, "invoiceNumber"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	notes, err := app.store.CreditNotes.GetByInvoiceID(ctx, invoice.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, notes); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetCreditNote godoc
+//
+// @Summary Fetches a credit note
+// @Description Fetches a credit note by number with its lines and tax breakdown
+// @Tags credit notes
+// @Produce json
+// @Param creditNoteNumber path string true "credit note number"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	store.CreditNote
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/credit-notes/creditNoteNumber/{creditNoteNumber} [get]
+func (app *application) getCreditNoteHandler(w http.ResponseWriter, r *http.Request) {
+	note, err := app.store.CreditNotes.GetByNumber(r.Context(), chi.URLParam(r, "creditNoteNumber"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, note); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetCreditNotePdf godoc
+//
+// @Summary Downloads a credit note
+// @Description Renders the credit note as a PDF
+// @Tags credit notes
+// @Produce application/pdf
+// @Param creditNoteNumber path string true "credit note number"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{file}		file
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/credit-notes/creditNoteNumber/{creditNoteNumber}/pdf [get]
+func (app *application) getCreditNotePdfHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	note, err := app.store.CreditNotes.GetByNumber(ctx, chi.URLParam(r, "creditNoteNumber"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	customer, err := app.store.Users.GetByID(ctx, note.User_id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	writePDF(w, note.Credit_note_number+".pdf", pdf.CreditNote(app.brand(), note, customer))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"transportService/internal/store"
+)
+
+// maxRadiusKm bounds nearby searches, wider areas are better served by a
+// bounding box of the map view.
+const maxRadiusKm = 500
+
+// PointPayload is a WGS 84 coordinate in degrees.
+type PointPayload struct {
+	Lat *float64 `json:"lat" validate:"required,gte=-90,lte=90"`
+	Lng *float64 `json:"lng" validate:"required,gte=-180,lte=180"`
+}
+
+func (p *PointPayload) point() *store.Point {
+	if p == nil {
+		return nil
+	}
+	return &store.Point{Lat: *p.Lat, Lng: *p.Lng}
+}
+
+// parseGeoSearch reads a nearby search from lat, lng and radius_km, or from
+// bbox as min_lng,min_lat,max_lng,max_lat like a GeoJSON bbox.
+func parseGeoSearch(query url.Values) (*store.GeoSearch, error) {
+	search := &store.GeoSearch{Radius_km: 50, Limit: 50}
+
+	lat, lng, bbox := query.Get("lat"), query.Get("lng"), query.Get("bbox")
+
+	switch {
+	case bbox != "" && (lat != "" || lng != ""):
+		return nil, errors.New("search by lat and lng or by bbox, not both")
+	case bbox != "":
+		parts := strings.Split(bbox, ",")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid bbox %q, use min_lng,min_lat,max_lng,max_lat", bbox)
+		}
+
+		var values [4]float64
+		for i, part := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bbox %q, use min_lng,min_lat,max_lng,max_lat", bbox)
+			}
+			values[i] = v
+		}
+
+		box := &store.BoundingBox{Min_lng: values[0], Min_lat: values[1], Max_lng: values[2], Max_lat: values[3]}
+		if !validLat(box.Min_lat) || !validLat(box.Max_lat) || !validLng(box.Min_lng) || !validLng(box.Max_lng) || box.Min_lat > box.Max_lat {
+			return nil, fmt.Errorf("invalid bbox %q", bbox)
+		}
+		search.Box = box
+	case lat != "" && lng != "":
+		latitude, err := strconv.ParseFloat(lat, 64)
+		if err != nil || !validLat(latitude) {
+			return nil, fmt.Errorf("invalid lat %q", lat)
+		}
+		longitude, err := strconv.ParseFloat(lng, 64)
+		if err != nil || !validLng(longitude) {
+			return nil, fmt.Errorf("invalid lng %q", lng)
+		}
+		search.Center = &store.Point{Lat: latitude, Lng: longitude}
+
+		if v := query.Get("radius_km"); v != "" {
+			radius, err := strconv.ParseFloat(v, 64)
+			if err != nil || radius <= 0 || radius > maxRadiusKm {
+				return nil, fmt.Errorf("invalid radius_km %q, use more than 0 up to %d", v, maxRadiusKm)
+			}
+			search.Radius_km = radius
+		}
+	default:
+		return nil, errors.New("lat and lng or bbox is required")
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 200 {
+			return nil, fmt.Errorf("invalid limit %q, use 1 to 200", v)
+		}
+		search.Limit = limit
+	}
+
+	return search, nil
+}
+
+func validLat(lat float64) bool {
+	return lat >= -90 && lat <= 90
+}
+
+func validLng(lng float64) bool {
+	return lng >= -180 && lng <= 180
+}
+
+// wantsGeoJSON tells whether the client asked for GeoJSON, with
+// format=geojson or by accepting application/geo+json.
+func wantsGeoJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "geojson" || strings.Contains(r.Header.Get("Accept"), "application/geo+json")
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	ID         int64           `json:"id"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties any             `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+func newGeoJSONFeature(id int64, point *store.Point, properties any) geoJSONFeature {
+	return geoJSONFeature{
+		Type: "Feature",
+		ID:   id,
+		// GeoJSON puts the longitude first
+		Geometry:   geoJSONGeometry{Type: "Point", Coordinates: [2]float64{point.Lng, point.Lat}},
+		Properties: properties,
+	}
+}
+
+// writeGeoJSON writes features as a bare FeatureCollection, map libraries
+// read it as is so it isn't wrapped in the envelope.
+func writeGeoJSON(w http.ResponseWriter, status int, features []geoJSONFeature) error {
+	if features == nil {
+		features = []geoJSONFeature{}
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(&geoJSONFeatureCollection{Type: "FeatureCollection", Features: features})
+}
@@ -2,6 +2,6 @@ package main
 
 import "net/http"
 
-func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request){
+func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Everything is okay"))
-}
\ No newline at end of file
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"transportService/internal/store"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+)
+
+var (
+	errIdempotencyKeyReused     = errors.New("idempotency key was already used for a different request")
+	errIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still being processed")
+)
+
+// idempotencyRecorder passes the response through while keeping a copy so it
+// can be stored for replays.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// IdempotencyMiddleware makes POST requests carrying an Idempotency-Key
+// header safe to retry. The first request with a key is handled and its
+// response stored, a retry with the same key and body gets that response
+// replayed and a retry with a different body is rejected. Keys are scoped to
+// the authenticated user so clients can't collide with each other, it must
+// run after AuthTokenMiddleware. Responses marked Cache-Control: no-store are
+// never stored.
+func (app *application) IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyValue := r.Header.Get(idempotencyKeyHeader)
+		if r.Method != http.MethodPost || keyValue == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scope := idempotencyScope(r)
+
+		if len(keyValue) > maxIdempotencyKeyLength {
+			app.badRequestResponse(w, r, fmt.Errorf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		maxBytes := 1_048_578 // 1mb
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := r.Context()
+		fingerprint := requestFingerprint(r, body)
+
+		key := &store.IdempotencyKey{
+			Scope:       scope,
+			Key:         keyValue,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			Fingerprint: fingerprint,
+		}
+
+		owned, err := app.store.IdempotencyKeys.Begin(ctx, key)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if !owned {
+			switch {
+			case key.Fingerprint != fingerprint:
+				app.unprocessableEntityResponse(w, r, errIdempotencyKeyReused)
+			case key.Completed_at == nil:
+				app.conflictResponse(w, r, errIdempotencyKeyInProgress)
+			default:
+				if key.Content_type != nil {
+					w.Header().Set("Content-Type", *key.Content_type)
+				}
+				w.Header().Set(idempotencyReplayedHeader, "true")
+				w.WriteHeader(*key.Response_status)
+				w.Write(key.Response_body)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// server errors and rejected credentials say nothing about the
+		// request itself, the key is freed so the client can retry. Responses
+		// that must not be kept free it too
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError || rec.status == http.StatusUnauthorized ||
+			strings.Contains(rec.Header().Get("Cache-Control"), "no-store") {
+			if err := app.store.IdempotencyKeys.Release(ctx, key.ID); err != nil {
+				app.logger.Errorw("error releasing idempotency key", "key_id", key.ID, "error", err.Error())
+			}
+			return
+		}
+
+		contentType := rec.Header().Get("Content-Type")
+		key.Response_status = &rec.status
+		key.Response_body = rec.body.Bytes()
+		key.Content_type = &contentType
+
+		if err := app.store.IdempotencyKeys.Complete(ctx, key); err != nil {
+			app.logger.Errorw("error storing idempotent response", "key_id", key.ID, "error", err.Error())
+		}
+	})
+}
+
+// idempotencyScope returns the namespace of the request's keys, the
+// authenticated user.
+func idempotencyScope(r *http.Request) string {
+	return fmt.Sprintf("user:%d", getUserFromContext(r).ID)
+}
+
+// requestFingerprint identifies the request a key was first used for.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
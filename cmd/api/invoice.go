@@ -1,33 +1,35 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"transportService/internal/store"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type CreateInvoicePayload struct {
-	Payment_id     int64  `json:"payment_id" validate:"required"`
-	Invoice_number string `json:"invoice_number" validate:"required"`
-	Due_date       string `json:"due_date" validate:"required"`
-	Status         string `json:"status" validate:"required"`
+	Payment_id int64 `json:"payment_id" validate:"required"`
 }
 
 // CreateInvoice godoc
 //
-// @Summary Creates a invoice
-// @Description Creates a invoice
+// @Summary Issues an invoice
+// @Description Issues the invoice of a payment that has none, invoices are numbered INV-<year>-<number> without gaps and priced from the booking's quote. Payments are invoiced when they are created so this is only needed for older payments
 // @Tags invoices
 // @Accept json
 // @Produce json
 // @Param payload body	 CreateInvoicePayload		true	"Post payload"
+// @Security ApiKeyAuth
 //
-//	@Success		202		{object}	store.Invoice
+//	@Success		201		{object}	store.Invoice
 //	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
 //	@Failure		500		{object}	error
-//	@Router			/invoice [post]
+//	@Router			/invoices [post]
 func (app *application) createInvoiceHandler(w http.ResponseWriter, r *http.Request) {
 	var payload CreateInvoicePayload
 
@@ -41,17 +43,20 @@ func (app *application) createInvoiceHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	invoice := &store.Invoice{
-		Payment_id:     payload.Payment_id,
-		Invoice_number: payload.Invoice_number,
-		Due_date:       payload.Due_date,
-		Status:         payload.Status,
-	}
-
 	ctx := r.Context()
 
-	if err := app.store.Invoices.Create(ctx, invoice); err != nil {
-		app.internalServerError(w, r, err)
+	invoice, err := app.store.Invoices.Issue(ctx, payload.Payment_id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, errors.New("payment is already invoiced"))
+		case errors.Is(err, store.ErrPriceChanged), errors.Is(err, store.ErrCurrencyMismatch):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
 		return
 	}
 
@@ -64,16 +69,18 @@ func (app *application) createInvoiceHandler(w http.ResponseWriter, r *http.Requ
 // getInoiceByInvoiceNumber godoc
 //
 // @summary fetches a invoice
-// @description fetches a invoice by invoice number
+// @description fetches a invoice by invoice number with its lines and tax breakdown
 // @tags invoices
 // @accept json
 // @produce json
 // @param invoiceNumber path string true "invoice number"
+// @Security ApiKeyAuth
 //
 //	@success		200	{object}	store.Invoice
+//	@failure		403	{object}	error
 //	@failure		404	{object}	error
 //	@failure		500	{object}	error
-//	@router			/invoice/invoiceNumber/{invoiceNumber} [get]
+//	@router			/invoices/invoiceNumber/{invoiceNumber} [get]
 func (app *application) getInvoiceByInvoiceNumberHandler(w http.ResponseWriter, r *http.Request) {
 	invoiceNumber := chi.URLParam(r, "invoiceNumber")
 
@@ -81,6 +88,10 @@ func (app *application) getInvoiceByInvoiceNumberHandler(w http.ResponseWriter,
 
 	invoice, err := app.store.Invoices.GetByInvoiceNumber(ctx, invoiceNumber)
 	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -91,53 +102,33 @@ func (app *application) getInvoiceByInvoiceNumberHandler(w http.ResponseWriter,
 	}
 }
 
-type UpdateInvoicePayload struct {
-	InvoiceNumber string `json:"invoice_number" validate:"required"`
-	Status        string `json:"status" validate:"required"`
-}
-
-// UpdateInvoice godoc
+// GetInvoicesByUserId godoc
 //
-// @Summary		Updates a invoice
-// @Description	Updates a invoice by invoice number
-// @Tags			invoices
-// @Accept			json
-// @Produce		json
-// @Param			invoiceNumber		path		string					true	"invoice number"
-// @Param			payload	body		UpdateInvoicePayload	true	"Post payload"
+// @Summary Fetches invoices
+// @Description Fetches the invoices of a user, newest first
+// @Tags invoices
+// @Produce json
+// @Param id path int true "user id"
+// @Security ApiKeyAuth
 //
-//	@Success		200		{object}	store.Invoice
-//	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
-//	@Failure		404		{object}	error
-//	@Failure		500		{object}	error
-//	@Router			/invoice/invoiceNumber/{invoiceNumber} [patch]
-func (app *application) updateInvoiceByInvoiceNumberHandler(w http.ResponseWriter, r *http.Request) {
-	var payload UpdateInvoicePayload
-
-	if err := readJSON(w, r, &payload); err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
-
-	if err := Validate.Struct(payload); err != nil {
+//	@Success		200	{array}		store.Invoice
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/invoices/userId/{id} [get]
+func (app *application) getInvoicesByUserIdHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	invoice := &store.Invoice{
-		Invoice_number: payload.InvoiceNumber,
-		Status:         payload.Status,
-	}
-
-	ctx := r.Context()
-
-	if err := app.store.Invoices.UpdateByInvoiceNumber(ctx, invoice); err != nil {
+	invoices, err := app.store.Invoices.GetByUserID(r.Context(), userId)
+	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, invoice); err != nil {
+	if err := app.jsonResponse(w, http.StatusOK, invoices); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -37,10 +37,24 @@ func writeJSONError(w http.ResponseWriter, status int, message string) error {
 	return writeJSON(w, status, &envelope{Error: message})
 }
 
+// envelope wraps every successful response. Paginated responses carry the
+// cursor of the next page, it is left out on the last page.
+type envelope struct {
+	Data        any     `json:"data"`
+	Next_cursor *string `json:"next_cursor,omitempty"`
+}
+
 func (app *application) jsonResponse(w http.ResponseWriter, status int, data any) error {
-	type envelope struct {
-		Data any `json:"data"`
+	return writeJSON(w, status, &envelope{Data: data})
+}
+
+// jsonPageResponse writes a page of results, nextCursor is empty on the last
+// page.
+func (app *application) jsonPageResponse(w http.ResponseWriter, status int, data any, nextCursor string) error {
+	env := &envelope{Data: data}
+	if nextCursor != "" {
+		env.Next_cursor = &nextCursor
 	}
 
-	return writeJSON(w, status, &envelope{Data: data})
+	return writeJSON(w, status, env)
 }
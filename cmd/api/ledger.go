@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"transportService/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CreatePayoutPayload struct {
+	Operator_id int64        `json:"operator_id" validate:"required"`
+	Amount      MoneyPayload `json:"amount" validate:"required"`
+	Description string       `json:"description" validate:"max=500"`
+}
+
+// GetLedgerAccounts godoc
+//
+// @Summary Fetches the ledger accounts
+// @Description Fetches every ledger account with its balance, liabilities and revenue are shown as positive balances
+// @Tags ledger
+// @Produce json
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	[]store.LedgerAccount
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/ledger/accounts [get]
+func (app *application) getLedgerAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	accounts, err := app.store.Ledger.GetAccounts(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, accounts); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetTrialBalance godoc
+//
+// @Summary Fetches the trial balance
+// @Description Sums every debit and credit posted to the ledger per currency, balanced is false when debits and credits differ or any transaction doesn't balance
+// @Tags ledger
+// @Produce json
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	store.TrialBalance
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/ledger/trial-balance [get]
+func (app *application) getTrialBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	balance, err := app.store.Ledger.GetTrialBalance(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, balance); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetOperatorBalance godoc
+//
+// @Summary Fetches what an operator is owed
+// @Description Fetches the operator's payable accounts, one per currency, with what the platform owes them after fees, refunds and payouts
+// @Tags ledger
+// @Produce json
+// @Param id path int true "Operator user id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	[]store.LedgerAccount
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/ledger/operators/{id}/balance [get]
+func (app *application) getOperatorBalanceHandler(w http.ResponseWriter, r *http.Request) {
+	operatorId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	accounts, err := app.store.Ledger.GetOperatorAccounts(r.Context(), operatorId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, accounts); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// CreatePayout godoc
+//
+// @Summary Records a payout to an operator
+// @Description Records money paid out to an operator and posts it to the ledger, the payout can't exceed what the operator is owed in that currency
+// @Tags ledger
+// @Accept json
+// @Produce json
+// @Param payload body	 CreatePayoutPayload		true	"Post payload"
+// @Security ApiKeyAuth
+//
+//	@Success		201		{object}	store.Payout
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		422		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/ledger/payouts [post]
+func (app *application) createPayoutHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreatePayoutPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Amount.Amount == 0 {
+		app.badRequestResponse(w, r, errors.New("payout amount must be positive"))
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	payout := &store.Payout{
+		Operator_id: payload.Operator_id,
+		Amount:      payload.Amount.money(),
+		Description: payload.Description,
+		Created_by:  &user.ID,
+	}
+
+	if err := app.store.Ledger.CreatePayout(r.Context(), payout); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		case errors.Is(err, store.ErrInsufficientBalance):
+			app.unprocessableEntityResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, payout); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 	_ "transportService/docs"
+	"transportService/internal/auth"
 	"transportService/internal/db"
 	"transportService/internal/env"
+	"transportService/internal/mailer"
+	"transportService/internal/payment"
 	"transportService/internal/store"
 
 	_ "github.com/lib/pq"
@@ -15,6 +20,11 @@ import (
 // @version 1.0
 // @decription API for maniging tranport booking
 // @basePath /v1
+//
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name Authorization
+// @description Bearer token returned by /login and /register
 
 func main() {
 
@@ -27,6 +37,71 @@ func main() {
 			maxIdleConns: env.GetInt("DB_MAX_IDLE_CONNS", 30),
 			maxIdleTime:  env.GetString("DB_MAX_IDLE_TIME", "15m"),
 		},
+		auth: authConfig{
+			token: tokenConfig{
+				secret:     env.GetString("JWT_SECRET", "secret"),
+				exp:        time.Minute * 15,
+				refreshExp: time.Hour * 24 * 30, // 30 days
+				iss:        "transportService",
+			},
+		},
+		mail: mailConfig{
+			driver:    env.GetString("MAIL_DRIVER", "file"),
+			fromEmail: env.GetString("MAIL_FROM", "no-reply@transportservice.local"),
+			dir:       env.GetString("MAIL_DIR", "./tmp/mail"),
+			smtp: smtpConfig{
+				host:     env.GetString("SMTP_HOST", "localhost"),
+				port:     env.GetInt("SMTP_PORT", 587),
+				username: env.GetString("SMTP_USERNAME", ""),
+				password: env.GetString("SMTP_PASSWORD", ""),
+			},
+			verifyExp: time.Hour * 24,
+			resetExp:  time.Hour,
+		},
+		booking: bookingConfig{
+			holdWindow:    env.GetDuration("BOOKING_HOLD_WINDOW", time.Minute*15),
+			sweepInterval: env.GetDuration("BOOKING_SWEEP_INTERVAL", time.Minute),
+		},
+		payment: paymentConfig{
+			provider:         env.GetString("PAYMENT_PROVIDER", "mock"),
+			webhookSecret:    env.GetString("PAYMENT_WEBHOOK_SECRET", ""),
+			webhookTolerance: time.Minute * 5,
+			platformFee:      env.GetInt("PLATFORM_FEE_PERCENT", 10),
+			mock: mockPaymentConfig{
+				scenario: env.GetString("PAYMENT_MOCK_SCENARIO", payment.ScenarioSuccess),
+				delay:    env.GetDuration("PAYMENT_MOCK_DELAY", time.Second*5),
+			},
+		},
+		idempotency: idempotencyConfig{
+			ttl:           env.GetDuration("IDEMPOTENCY_KEY_TTL", time.Hour*24),
+			sweepInterval: time.Hour,
+		},
+		invoice: invoiceConfig{
+			dueDays: env.GetInt("INVOICE_DUE_DAYS", 14),
+			taxRate: env.GetInt("INVOICE_TAX_RATE_BPS", 0),
+			dunning: dunningConfig{
+				interval:    env.GetDuration("DUNNING_INTERVAL", time.Hour),
+				reminders:   env.GetIntList("DUNNING_REMINDER_DAYS", []int{1, 7, 14}),
+				cancelAfter: env.GetInt("DUNNING_CANCEL_AFTER_DAYS", 0),
+			},
+		},
+		schedule: scheduleConfig{
+			interval:    env.GetDuration("TRIP_SCHEDULE_INTERVAL", time.Hour),
+			horizonDays: env.GetInt("TRIP_SCHEDULE_HORIZON_DAYS", 90),
+		},
+		brandName:   env.GetString("BRAND_NAME", "Transport Service"),
+		frontendURL: env.GetString("FRONTEND_URL", "http://localhost:5173"),
+	}
+
+	// background jobs tick on these, a ticker can't run on zero or less
+	for name, interval := range map[string]time.Duration{
+		"BOOKING_SWEEP_INTERVAL": cfg.booking.sweepInterval,
+		"DUNNING_INTERVAL":       cfg.invoice.dunning.interval,
+		"TRIP_SCHEDULE_INTERVAL": cfg.schedule.interval,
+	} {
+		if interval <= 0 {
+			log.Fatalf("%s must be a positive duration like 1m, got %s", name, interval)
+		}
 	}
 
 	// logger
@@ -45,14 +120,76 @@ func main() {
 	defer db.Close()
 	logger.Info("database connection pool established")
 
-	store := store.NewStorage(db)
+	store := store.NewStorage(db, store.Config{
+		BookingHoldDuration:     cfg.booking.holdWindow,
+		PlatformFeePercent:      cfg.payment.platformFee,
+		InvoiceDueDays:          cfg.invoice.dueDays,
+		DefaultTaxRate:          cfg.invoice.taxRate,
+		TripScheduleHorizonDays: cfg.schedule.horizonDays,
+	})
+
+	jwtAuthenticator := auth.NewJWTAuthenticator(
+		cfg.auth.token.secret,
+		cfg.auth.token.iss,
+		cfg.auth.token.iss,
+	)
+
+	var mail mailer.Client
+	switch cfg.mail.driver {
+	case "smtp":
+		mail = mailer.NewSMTPMailer(
+			cfg.mail.smtp.host,
+			cfg.mail.smtp.port,
+			cfg.mail.smtp.username,
+			cfg.mail.smtp.password,
+			cfg.mail.fromEmail,
+		)
+	case "memory":
+		mail = mailer.NewInMemoryMailer(cfg.mail.fromEmail)
+	default:
+		mail, err = mailer.NewFileMailer(cfg.mail.dir, cfg.mail.fromEmail)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	logger.Infow("mailer configured", "driver", cfg.mail.driver)
+
+	// a known secret would let anyone forge payment webhooks, only the mock
+	// provider gets a local default
+	if cfg.payment.webhookSecret == "" {
+		if cfg.payment.provider != "mock" {
+			log.Fatalf("PAYMENT_WEBHOOK_SECRET is required for payment provider %q", cfg.payment.provider)
+		}
+		cfg.payment.webhookSecret = "whsec_local"
+	}
+
+	var payments payment.Provider
+	switch cfg.payment.provider {
+	case "mock":
+		payments = payment.NewMockProvider(cfg.payment.mock.scenario, cfg.payment.mock.delay)
+	default:
+		log.Fatalf("unknown payment provider %q", cfg.payment.provider)
+	}
+	logger.Infow("payment provider configured", "provider", cfg.payment.provider)
 
 	app := &application{
-		config: cfg,
-		store:  store,
-		logger: logger,
+		config:        cfg,
+		store:         store,
+		logger:        logger,
+		authenticator: jwtAuthenticator,
+		mailer:        mail,
+		payments:      payments,
 	}
 
+	// background jobs stop with the process
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go app.runEvery(ctx, cfg.booking.sweepInterval, app.expireBookingHolds)
+	go app.runEvery(ctx, cfg.idempotency.sweepInterval, app.deleteExpiredIdempotencyKeys)
+	go app.runEvery(ctx, cfg.invoice.dunning.interval, app.runDunning)
+	go app.runEvery(ctx, cfg.schedule.interval, app.generateTripDepartures)
+
 	mux := app.mount()
 
 	logger.Fatal(app.run(mux))
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"transportService/internal/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type userKey string
+
+const (
+	userCtx    userKey = "user"
+	sessionCtx userKey = "session"
+)
+
+// AuthTokenMiddleware validates the bearer token on the request and loads
+// the user it was issued to into the request context.
+func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is missing"))
+			return
+		}
+
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			app.unauthorizedErrorResponse(w, r, fmt.Errorf("authorization header is malformed"))
+			return
+		}
+
+		jwtToken, err := app.authenticator.ValidateToken(parts[1])
+		if err != nil {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+
+		sub, err := jwtToken.Claims.GetSubject()
+		if err != nil {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+
+		userID, err := strconv.ParseInt(sub, 10, 64)
+		if err != nil {
+			app.unauthorizedErrorResponse(w, r, err)
+			return
+		}
+
+		claims, _ := jwtToken.Claims.(jwt.MapClaims)
+		sid, ok := claims["sid"].(float64)
+		if !ok {
+			app.unauthorizedErrorResponse(w, r, fmt.Errorf("token has no session"))
+			return
+		}
+
+		ctx := r.Context()
+
+		// access tokens are only as good as the session they were minted for,
+		// so revoking the session logs the user out straight away
+		session, err := app.store.Sessions.GetByID(ctx, int64(sid))
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				app.unauthorizedErrorResponse(w, r, err)
+				return
+			}
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if session.Revoked_at != nil || session.User_id != userID {
+			app.unauthorizedErrorResponse(w, r, store.ErrSessionRevoked)
+			return
+		}
+
+		user, err := app.store.Users.GetByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				app.unauthorizedErrorResponse(w, r, err)
+				return
+			}
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		ctx = context.WithValue(ctx, userCtx, user)
+		ctx = context.WithValue(ctx, sessionCtx, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getUserFromContext(r *http.Request) *store.User {
+	user, _ := r.Context().Value(userCtx).(*store.User)
+	return user
+}
+
+func getSessionFromContext(r *http.Request) *store.Session {
+	session, _ := r.Context().Value(sessionCtx).(*store.Session)
+	return session
+}
+
+// ownerFunc resolves the id of the user that owns the resource addressed by
+// the request.
+type ownerFunc func(r *http.Request) (int64, error)
+
+// RequireRole only lets the request through when the authenticated user has
+// the given role or one that outranks it.
+func (app *application) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getUserFromContext(r)
+
+			allowed, err := app.checkRolePrecedence(r.Context(), user, role)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+
+			if !allowed {
+				app.forbiddenResponse(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ownersFunc resolves the ids of the users that own the resource addressed
+// by the request, like a booking's customer and its trip's operator.
+type ownersFunc func(r *http.Request) ([]int64, error)
+
+// RequireOwnerOrRole lets the request through when the authenticated user
+// owns the addressed resource, or has the given role or one that outranks it.
+func (app *application) RequireOwnerOrRole(role string, owner ownerFunc) func(http.Handler) http.Handler {
+	return app.RequireOwnersOrRole(role, func(r *http.Request) ([]int64, error) {
+		ownerID, err := owner(r)
+		if err != nil {
+			return nil, err
+		}
+		return []int64{ownerID}, nil
+	})
+}
+
+// RequireOwnersOrRole lets the request through when the authenticated user
+// is one of the owners of the addressed resource, or has the given role or
+// one that outranks it.
+func (app *application) RequireOwnersOrRole(role string, owners ownersFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ownerIDs, err := owners(r)
+			if err != nil {
+				switch {
+				case errors.Is(err, store.ErrNotFound):
+					app.notFoundResponse(w, r, err)
+				case errors.Is(err, strconv.ErrSyntax), errors.Is(err, strconv.ErrRange):
+					app.badRequestResponse(w, r, err)
+				default:
+					app.internalServerError(w, r, err)
+				}
+				return
+			}
+
+			user := getUserFromContext(r)
+
+			if slices.Contains(ownerIDs, user.ID) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed, err := app.checkRolePrecedence(r.Context(), user, role)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+
+			if !allowed {
+				app.forbiddenResponse(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (app *application) isOwnerOrRole(ctx context.Context, user *store.User, ownerID int64, role string) (bool, error) {
+	if user.ID == ownerID {
+		return true, nil
+	}
+
+	return app.checkRolePrecedence(ctx, user, role)
+}
+
+func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, roleName string) (bool, error) {
+	role, err := app.store.Roles.GetByName(ctx, roleName)
+	if err != nil {
+		return false, err
+	}
+
+	return user.Role.Level >= role.Level, nil
+}
+
+// userParamOwner treats the {id} URL parameter as the owning user's id.
+func (app *application) userParamOwner(r *http.Request) (int64, error) {
+	return strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+}
+
+func (app *application) invoiceOwner(r *http.Request) (int64, error) {
+	invoice, err := app.store.Invoices.GetByInvoiceNumber(r.Context(), chi.URLParam(r, "invoiceNumber"))
+	if err != nil {
+		return 0, err
+	}
+
+	return invoice.User_id, nil
+}
+
+func (app *application) creditNoteOwner(r *http.Request) (int64, error) {
+	note, err := app.store.CreditNotes.GetByNumber(r.Context(), chi.URLParam(r, "creditNoteNumber"))
+	if err != nil {
+		return 0, err
+	}
+
+	return note.User_id, nil
+}
+
+// bookingOwners are the booking's customer and its trip's operator.
+func (app *application) bookingOwners(r *http.Request) ([]int64, error) {
+	bookingID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	booking, err := app.store.Bookings.GetByID(r.Context(), bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.withTripOperator(r.Context(), booking.Trip_id, booking.User_id)
+}
+
+// paymentOwners are the paying customer and the operator of the booked
+// trip.
+func (app *application) paymentOwners(r *http.Request) ([]int64, error) {
+	paymentID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := r.Context()
+
+	payment, err := app.store.Payments.GetByID(ctx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	booking, err := app.store.Bookings.GetByID(ctx, payment.Booking_id)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.withTripOperator(ctx, booking.Trip_id, payment.User_id)
+}
+
+// commentOwners are the comment's author and the operator of the trip it
+// is on.
+func (app *application) commentOwners(r *http.Request) ([]int64, error) {
+	commentID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	comment, err := app.store.Comments.GetByID(r.Context(), commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.withTripOperator(r.Context(), comment.Trip_id, comment.User_id)
+}
+
+func (app *application) photoOwner(r *http.Request) (int64, error) {
+	photoID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	photo, err := app.store.Photos.GetByID(r.Context(), photoID)
+	if err != nil {
+		return 0, err
+	}
+
+	return app.tripOperator(r.Context(), photo.Trip_id)
+}
+
+func (app *application) waitlistOwner(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	entry, err := app.store.Waitlist.GetByID(r.Context(), id)
+	if err != nil {
+		return 0, err
+	}
+
+	return entry.User_id, nil
+}
+
+func (app *application) tripTemplateOwner(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	template, err := app.store.TripTemplates.GetByID(r.Context(), id)
+	if err != nil {
+		return 0, err
+	}
+
+	return template.Operator_id, nil
+}
+
+func (app *application) tripOwner(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return app.tripOperator(r.Context(), id)
+}
+
+func (app *application) tripOperator(ctx context.Context, tripID int64) (int64, error) {
+	trip, err := app.store.Trips.GetByID(ctx, tripID)
+	if err != nil {
+		return 0, err
+	}
+
+	// trips without an operator are left to admins
+	if trip.Operator_id == nil {
+		return 0, nil
+	}
+
+	return *trip.Operator_id, nil
+}
+
+// withTripOperator adds the operator of the trip to the owners.
+func (app *application) withTripOperator(ctx context.Context, tripID int64, owners ...int64) ([]int64, error) {
+	operatorID, err := app.tripOperator(ctx, tripID)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(owners, operatorID), nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import "transportService/internal/store"
+
+// MoneyPayload is an amount in the currency's minor unit, 1250 EUR is 12.50.
+type MoneyPayload struct {
+	Amount   int64  `json:"amount" validate:"gte=0"`
+	Currency string `json:"currency" validate:"required,iso4217"`
+}
+
+func (m MoneyPayload) money() store.Money {
+	return store.NewMoney(m.Amount, m.Currency)
+}
@@ -1,33 +1,40 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"transportService/internal/payment"
 	"transportService/internal/store"
 
 	"github.com/go-chi/chi/v5"
 )
 
+var errPaymentNotPending = errors.New("payment is not pending")
+
 type CreatePaymentPayload struct {
-	Booking_id     int64   `json:"booking_id" validate:"required"`
-	User_id        int64   `json:"user_id" validate:"required"`
-	Amount         float64 `json:"amount" validate:"required"`
-	Status         string  `json:"status" validate:"required"`
-	Transaction_id string  `json:"transation_id" validate:"required"`
+	Booking_id     int64  `json:"booking_id" validate:"required"`
+	Payment_method string `json:"payment_method" validate:"max=100"`
+	Currency       string `json:"currency" validate:"omitempty,iso4217"`
 }
 
 // CreatePayment godoc
 //
-// @Summary Creates a payment
-// @Description Creates a payment
+// @Summary Starts the payment of a booking
+// @Description Creates a payment intent with the payment provider for a pending booking and issues its invoice, the amount is the booking's quote: the trip, its activities and accomodation for every passenger. A currency in the payload must be the trip's currency
 // @Tags payments
 // @Accept json
 // @Produce json
 // @Param payload body	 CreatePaymentPayload		true	"Post payload"
+// @Security ApiKeyAuth
 //
-//	@Success		202		{object}	store.Payment
+//	@Success		201		{object}	store.Payment
 //	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
 //	@Failure		500		{object}	error
 //	@Router			/payments [post]
 func (app *application) createPaymentHandler(w http.ResponseWriter, r *http.Request) {
@@ -43,22 +50,208 @@ func (app *application) createPaymentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	payment := &store.Payment{
-		Booking_id:     payload.Booking_id,
-		User_id:        payload.User_id,
-		Amount:         payload.Amount,
-		Status:         payload.Status,
-		Transaction_id: payload.Transaction_id,
+	ctx := r.Context()
+
+	booking, err := app.store.Bookings.GetByID(ctx, payload.Booking_id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	allowed, err := app.isOwnerOrRole(ctx, getUserFromContext(r), booking.User_id, store.RoleOperator)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if !allowed {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	if booking.Status != store.BookingStatusPending {
+		app.conflictResponse(w, r, fmt.Errorf("booking is %s, only pending bookings can be paid", booking.Status))
+		return
+	}
+
+	existing, err := app.store.Payments.GetByBookingID(ctx, booking.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	for _, p := range existing {
+		if p.Status == store.PaymentStatusPending || p.Status == store.PaymentStatusComplete {
+			app.conflictResponse(w, r, fmt.Errorf("booking already has payment %d", p.ID))
+			return
+		}
+	}
+
+	quote, err := app.store.Bookings.Quote(ctx, booking.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrCurrencyMismatch) {
+			app.conflictResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if payload.Currency != "" && payload.Currency != quote.Total.Currency {
+		app.badRequestResponse(w, r, fmt.Errorf("%w: trip is priced in %s", store.ErrCurrencyMismatch, quote.Total.Currency))
+		return
+	}
+
+	amount := quote.Total
+
+	intent, err := app.payments.CreateIntent(ctx, payment.IntentParams{
+		Amount:         amount.Amount,
+		Currency:       amount.Currency,
+		Reference:      fmt.Sprintf("booking-%d", booking.ID),
+		Payment_method: payload.Payment_method,
+	})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	p := &store.Payment{
+		Booking_id:     booking.ID,
+		User_id:        booking.User_id,
+		Amount:         amount,
+		Status:         store.PaymentStatusPending,
+		Transaction_id: intent.ID,
+	}
+
+	if err := app.store.Payments.Create(ctx, p); err != nil {
+		switch {
+		case errors.Is(err, store.ErrCurrencyMismatch):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, store.ErrPriceChanged):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, p); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// CapturePayment godoc
+//
+// @Summary Captures a payment
+// @Description Charges a pending payment through the payment provider, a successful charge confirms the booking and a charge for a booking that can no longer be confirmed is refunded
+// @Tags payments
+// @Produce json
+// @Param id path int true "Payment id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	store.Payment
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/payments/id/{id}/capture [post]
+func (app *application) capturePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	paymentId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	p, err := app.store.Payments.GetByID(ctx, paymentId)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if p.Status != store.PaymentStatusPending {
+		app.conflictResponse(w, r, errPaymentNotPending)
+		return
+	}
+
+	intent, err := app.payments.Capture(ctx, p.Transaction_id)
+	if err != nil {
+		if errors.Is(err, payment.ErrInvalidState) {
+			app.conflictResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.applyIntent(ctx, p, intent); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, p); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetPaymentById godoc
+//
+// @Summary Fetches a payment
+// @Description Fetches a payment by id, a payment still pending is refreshed from the payment provider first
+// @Tags payments
+// @Produce json
+// @Param id path int true "Payment id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	store.Payment
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/payments/id/{id} [get]
+func (app *application) getPaymentByIdHandler(w http.ResponseWriter, r *http.Request) {
+	paymentId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	ctx := r.Context()
 
-	if err := app.store.Payments.Create(ctx, payment); err != nil {
+	p, err := app.store.Payments.GetByID(ctx, paymentId)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusCreated, payment); err != nil {
+	if p.Status == store.PaymentStatusPending {
+		intent, err := app.payments.GetIntent(ctx, p.Transaction_id)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if err := app.applyIntent(ctx, p, intent); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, p); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -98,54 +291,69 @@ func (app *application) getPaymentsByUserIdHandler(w http.ResponseWriter, r *htt
 	}
 }
 
-type UpdatePaymentPayload struct {
-	ID     int64  `json:"id" validate:"required"`
-	Status string `json:"status" validate:"required"`
-}
-
-// UpdatePayment godoc
-//
-// @Summary		Updates a payment
-// @Description	Updates a payment by ID
-// @Tags			payments
-// @Accept			json
-// @Produce		json
-// @Param			id		path		int					true	"Payment ID"
-// @Param			payload	body		UpdatePaymentPayload	true	"Post payload"
-//
-//	@Success		200		{object}	store.Payment
-//	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
-//	@Failure		404		{object}	error
-//	@Failure		500		{object}	error
-//	@Router			/payments/id/{id} [patch]
-func (app *application) updatePaymentByIdHandler(w http.ResponseWriter, r *http.Request) {
-	var payload UpdatePaymentPayload
+// applyIntent settles a pending payment once the provider reports a final
+// status for its intent, and returns any refund the settlement queued to the
+// provider straight away.
+func (app *application) applyIntent(ctx context.Context, p *store.Payment, intent *payment.Intent) error {
+	status := paymentStatusFromIntent(intent.Status)
+	if status == store.PaymentStatusPending {
+		return nil
+	}
 
-	if err := readJSON(w, r, &payload); err != nil {
-		app.badRequestResponse(w, r, err)
-		return
+	settlement, err := app.store.Payments.Settle(ctx, p.Transaction_id, status)
+	if err != nil {
+		return err
 	}
 
-	if err := Validate.Struct(payload); err != nil {
-		app.badRequestResponse(w, r, err)
-		return
+	*p = *settlement.Payment
+
+	if settlement.Refund != nil {
+		app.issueRefund(ctx, p, settlement.Refund)
 	}
 
-	payment := &store.Payment{
-		ID:     payload.ID,
-		Status: payload.Status,
+	return nil
+}
+
+func paymentStatusFromIntent(status string) string {
+	switch status {
+	case payment.IntentStatusSucceeded:
+		return store.PaymentStatusComplete
+	case payment.IntentStatusFailed:
+		return store.PaymentStatusFailed
+	default:
+		return store.PaymentStatusPending
 	}
+}
 
-	ctx := r.Context()
+// issueRefunds sends refunds recorded for a cancellation to the payment
+// provider, failures are recorded on the refund and logged.
+func (app *application) issueRefunds(ctx context.Context, refunds []store.Refund) {
+	for i := range refunds {
+		p, err := app.store.Payments.GetByID(ctx, refunds[i].Payment_id)
+		if err != nil {
+			app.logger.Errorw("error loading payment for refund", "refund_id", refunds[i].ID, "error", err.Error())
+			continue
+		}
 
-	if err := app.store.Payments.UpdateByID(ctx, payment); err != nil {
-		app.internalServerError(w, r, err)
-		return
+		app.issueRefund(ctx, p, &refunds[i])
+	}
+}
+
+func (app *application) issueRefund(ctx context.Context, p *store.Payment, refund *store.Refund) {
+	result, err := app.payments.Refund(ctx, p.Transaction_id, refund.Amount.Amount)
+	switch {
+	case err != nil:
+		app.logger.Errorw("payment provider refund failed", "refund_id", refund.ID, "payment_id", p.ID, "error", err.Error())
+		refund.Status = store.RefundStatusFailed
+	case result.Status == payment.RefundStatusSucceeded:
+		refund.Status = store.RefundStatusComplete
+		refund.Transaction_id = &result.ID
+	default:
+		refund.Status = store.RefundStatusFailed
+		refund.Transaction_id = &result.ID
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, payment); err != nil {
-		app.internalServerError(w, r, err)
-		return
+	if err := app.store.Refunds.UpdateByID(ctx, refund); err != nil {
+		app.logger.Errorw("error recording refund outcome", "refund_id", refund.ID, "error", err.Error())
 	}
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"transportService/internal/pdf"
+	"transportService/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var errBookingNotConfirmed = errors.New("booking is not confirmed")
+
+// GetInvoicePdf godoc
+//
+// @Summary Downloads an invoice
+// @Description Renders the invoice as a PDF with its lines, tax breakdown, totals and payment status
+// @Tags invoices
+// @Produce application/pdf
+// @Param invoiceNumber path string true "invoice number"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{file}		file
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/invoices/invoiceNumber/{invoiceNumber}/pdf [get]
+func (app *application) getInvoicePdfHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	invoice, err := app.store.Invoices.GetByInvoiceNumber(ctx, chi.URLParam(r, "invoiceNumber"))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	customer, err := app.store.Users.GetByID(ctx, invoice.User_id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	writePDF(w, invoice.Invoice_number+".pdf", pdf.Invoice(app.brand(), invoice, customer))
+}
+
+// GetBookingConfirmationPdf godoc
+//
+// @Summary Downloads a booking confirmation
+// @Description Renders the confirmation of a confirmed booking as a PDF with the trip, its passengers and what was paid
+// @Tags bookings
+// @Produce application/pdf
+// @Param id path int true "booking id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{file}		file
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		409	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/bookings/id/{id}/confirmation.pdf [get]
+func (app *application) getBookingConfirmationPdfHandler(w http.ResponseWriter, r *http.Request) {
+	bookingId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	booking, err := app.store.Bookings.GetByID(ctx, bookingId)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	switch booking.Status {
+	case store.BookingStatusConfirmed, store.BookingStatusCheckedIn, store.BookingStatusCompleted:
+	default:
+		app.conflictResponse(w, r, fmt.Errorf("%w, it is %s", errBookingNotConfirmed, booking.Status))
+		return
+	}
+
+	trip, err := app.store.Trips.GetByID(ctx, booking.Trip_id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	invoices, err := app.store.Invoices.GetByBookingID(ctx, booking.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	filename := fmt.Sprintf("booking-%d-confirmation.pdf", booking.ID)
+
+	writePDF(w, filename, pdf.Confirmation(app.brand(), booking, trip, invoices))
+}
+
+func (app *application) brand() pdf.Brand {
+	return pdf.Brand{
+		Name:    app.config.brandName,
+		Website: app.config.frontendURL,
+		Color:   pdf.RGB(21, 101, 192),
+	}
+}
+
+func writePDF(w http.ResponseWriter, filename string, data []byte) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -47,6 +47,17 @@ func (app *application) createSubHandler(w http.ResponseWriter, r *http.Request)
 
 	ctx := r.Context()
 
+	allowed, err := app.isOwnerOrRole(ctx, getUserFromContext(r), payload.UserID, store.RoleAdmin)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if !allowed {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
 	if err := app.store.Subscriptions.Create(ctx, sub); err != nil {
 		app.internalServerError(w, r, err)
 		return
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"transportService/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateTaxRulePayload struct {
+	Name      string  `json:"name" validate:"required,max=100"`
+	Location  *string `json:"location" validate:"omitempty,max=255"`
+	Item_type *string `json:"item_type" validate:"omitempty,oneof=trip activity accomodation"`
+	Rate      int     `json:"rate" validate:"min=0,max=10000"`
+}
+
+// CreateTaxRule godoc
+//
+// @Summary Creates a tax rule
+// @Description Creates the tax rate, in basis points (2000 is 20%), for trips in a location, for an item type or for both. Invoices use the most specific rule for every line, a location beats an item type
+// @Tags tax rules
+// @Accept json
+// @Produce json
+// @Param payload body	 CreateTaxRulePayload		true	"Post payload"
+// @Security ApiKeyAuth
+//
+//	@Success		201		{object}	store.TaxRule
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/tax-rules [post]
+func (app *application) createTaxRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateTaxRulePayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rule := &store.TaxRule{
+		Name:      payload.Name,
+		Item_type: payload.Item_type,
+		Rate:      payload.Rate,
+	}
+
+	if payload.Location != nil {
+		if location := strings.TrimSpace(*payload.Location); location != "" {
+			rule.Location = &location
+		}
+	}
+
+	if err := app.store.TaxRules.Create(r.Context(), rule); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			app.conflictResponse(w, r, errors.New("a tax rule for this location and item type already exists"))
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, rule); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetAllTaxRules godoc
+//
+// @Summary Fetches all tax rules
+// @Description Fetches all tax rules, rules without a location come first
+// @Tags tax rules
+// @Produce json
+// @Security ApiKeyAuth
+//
+//	@Success		200	{array}		store.TaxRule
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/tax-rules [get]
+func (app *application) getAllTaxRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.store.TaxRules.GetAll(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, rules); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// DeleteTaxRule godoc
+//
+// @Summary Deletes a tax rule
+// @Description Deletes a tax rule, invoices already issued keep the tax they were issued with
+// @Tags tax rules
+// @Param id path int true "Tax rule id"
+// @Security ApiKeyAuth
+//
+//	@Success		204
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/tax-rules/id/{id} [delete]
+func (app *application) deleteTaxRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.TaxRules.DeleteByID(r.Context(), id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
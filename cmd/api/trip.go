@@ -1,22 +1,31 @@
 package main
 
 import (
+	"encoding/csv"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 	"transportService/internal/store"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type CreateTripPayload struct {
-	Name            string  `json:"name" validate:"required,max=100"`
-	Decription      string  `json:"description" validate:"required,max=255"`
-	Location        string  `json:"location" validate:"required,max=100"`
-	Start_date      string  `json:"start_date" validate:"required"`
-	End_date        string  `json:"end_date" validate:"required"`
-	Price           float64 `json:"price" validate:"required"`
-	Seats           int     `json:"seats" validate:"required"`
-	Available_seats int     `json:"available_seats" validate:"required"`
+	Name                   string        `json:"name" validate:"required,max=100"`
+	Decription             string        `json:"description" validate:"required,max=255"`
+	Location               string        `json:"location" validate:"required,max=100"`
+	Start_date             string        `json:"start_date" validate:"required"`
+	End_date               string        `json:"end_date" validate:"required"`
+	Price                  MoneyPayload  `json:"price" validate:"required"`
+	Seats                  int           `json:"seats" validate:"required"`
+	Available_seats        int           `json:"available_seats" validate:"required"`
+	Cancellation_policy_id *int64        `json:"cancellation_policy_id"`
+	Departure              *PointPayload `json:"departure"`
+	Destination            *PointPayload `json:"destination"`
 }
 
 // CreateTrip godoc
@@ -46,20 +55,30 @@ func (app *application) createTripHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	user := getUserFromContext(r)
+
 	trip := &store.Trip{
-		Name:            payload.Name,
-		Decription:      payload.Decription,
-		Location:        payload.Location,
-		Start_date:      payload.Start_date,
-		End_date:        payload.End_date,
-		Price:           payload.Price,
-		Seats:           payload.Seats,
-		Available_seats: payload.Available_seats,
+		Name:                   payload.Name,
+		Decription:             payload.Decription,
+		Location:               payload.Location,
+		Start_date:             payload.Start_date,
+		End_date:               payload.End_date,
+		Price:                  payload.Price.money(),
+		Seats:                  payload.Seats,
+		Available_seats:        payload.Available_seats,
+		Cancellation_policy_id: payload.Cancellation_policy_id,
+		Operator_id:            &user.ID,
+		Departure:              payload.Departure.point(),
+		Destination:            payload.Destination.point(),
 	}
 
 	ctx := r.Context()
 
 	if err := app.store.Trips.Create(ctx, trip); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, fmt.Errorf("cancellation policy not found"))
+			return
+		}
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -70,34 +89,247 @@ func (app *application) createTripHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// GetAllTrips godoc
+// SearchTrips godoc
 //
-// @Summary Fetches all trips
-// @Description Fetches all trips
+// @Summary Searches trips
+// @Description Searches trips a page at a time. Pass the next_cursor of a response as cursor to get the following page, it is left out on the last page
 // @Tags trips
-// @Accept json
 // @Produce json
+// @Param location query string false "Start of the location, ignoring case"
+// @Param from query string false "Earliest start date, YYYY-MM-DD"
+// @Param to query string false "Latest start date, YYYY-MM-DD"
+// @Param min_price query int false "Lowest price in minor units"
+// @Param max_price query int false "Highest price in minor units"
+// @Param currency query string false "Currency of the price"
+// @Param min_seats query int false "Fewest available seats"
+// @Param sort query string false "date, price or rating, prefixed with - for descending order" default(date)
+// @Param limit query int false "Trips per page, at most 100" default(20)
+// @Param cursor query string false "Cursor of the page to fetch"
 //
-//	@Success		200	{object}	store.Trip
-//	@Failure		404	{object}	error
+//	@Success		200	{array}		store.TripResult
+//	@Failure		400	{object}	error
 //	@Failure		500	{object}	error
 //	@Router			/trips [get]
-func (app *application) getAllTripsHandler(w http.ResponseWriter, r *http.Request) {
+func (app *application) searchTripsHandler(w http.ResponseWriter, r *http.Request) {
+	search, err := parseTripSearch(r.URL.Query())
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
 
-	ctx := r.Context()
+	trips, next, err := app.store.Trips.Search(r.Context(), *search)
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidCursor) {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var nextCursor string
+	if next != nil {
+		nextCursor = next.Encode()
+	}
+
+	if err := app.jsonPageResponse(w, http.StatusOK, trips, nextCursor); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// FullTextSearchTrips godoc
+//
+// @Summary Searches trips by text
+// @Description Searches the name, location, description and activity names of trips, best match first. The query takes web search syntax: quoted phrases, or, and - to exclude a word. The filters, sorting and paging of /trips apply too
+// @Tags trips
+// @Produce json
+// @Param q query string true "Text to search for"
+// @Param location query string false "Start of the location, ignoring case"
+// @Param from query string false "Earliest start date, YYYY-MM-DD"
+// @Param to query string false "Latest start date, YYYY-MM-DD"
+// @Param min_price query int false "Lowest price in minor units"
+// @Param max_price query int false "Highest price in minor units"
+// @Param currency query string false "Currency of the price"
+// @Param min_seats query int false "Fewest available seats"
+// @Param sort query string false "relevance, date, price or rating, prefixed with - for descending order" default(relevance)
+// @Param limit query int false "Trips per page, at most 100" default(20)
+// @Param cursor query string false "Cursor of the page to fetch"
+//
+//	@Success		200	{array}		store.TripResult
+//	@Failure		400	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/trips/search [get]
+func (app *application) fullTextSearchTripsHandler(w http.ResponseWriter, r *http.Request) {
+	search, err := parseTripSearch(r.URL.Query())
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if search.Query == "" {
+		app.badRequestResponse(w, r, errors.New("q is required"))
+		return
+	}
+
+	trips, next, err := app.store.Trips.Search(r.Context(), *search)
+	if err != nil {
+		if errors.Is(err, store.ErrInvalidCursor) {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var nextCursor string
+	if next != nil {
+		nextCursor = next.Encode()
+	}
+
+	if err := app.jsonPageResponse(w, http.StatusOK, trips, nextCursor); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetNearbyTrips godoc
+//
+// @Summary Finds trips nearby
+// @Description Finds the upcoming trips departing, or arriving with point=destination, within radius_km of lat and lng, nearest first with their distance. A bbox finds those inside a map view instead. format=geojson, or accepting application/geo+json, returns a GeoJSON FeatureCollection of the searched points
+// @Tags trips
+// @Produce json
+// @Produce application/geo+json
+// @Param point query string false "departure or destination" default(departure)
+// @Param lat query number false "Latitude of the center"
+// @Param lng query number false "Longitude of the center"
+// @Param radius_km query number false "Radius around the center in km, at most 500" default(50)
+// @Param bbox query string false "min_lng,min_lat,max_lng,max_lat"
+// @Param limit query int false "Most trips to return, at most 200" default(50)
+// @Param format query string false "geojson"
+//
+//	@Success		200	{array}		store.TripDistance
+//	@Failure		400	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/trips/nearby [get]
+func (app *application) getNearbyTripsHandler(w http.ResponseWriter, r *http.Request) {
+	search, err := parseGeoSearch(r.URL.Query())
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	point := r.URL.Query().Get("point")
+	switch point {
+	case "":
+		point = store.TripPointDeparture
+	case store.TripPointDeparture, store.TripPointDestination:
+	default:
+		app.badRequestResponse(w, r, fmt.Errorf("invalid point %q, use departure or destination", point))
+		return
+	}
 
-	trips, err := app.store.Trips.GetAll(ctx)
+	trips, err := app.store.Trips.Nearby(r.Context(), point, *search)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
+	if wantsGeoJSON(r) {
+		features := make([]geoJSONFeature, 0, len(trips))
+		for _, trip := range trips {
+			at := trip.Departure
+			if point == store.TripPointDestination {
+				at = trip.Destination
+			}
+			features = append(features, newGeoJSONFeature(trip.ID, at, trip))
+		}
+
+		if err := writeGeoJSON(w, http.StatusOK, features); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, trips); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 }
 
+func parseTripSearch(query url.Values) (*store.TripSearch, error) {
+	search := &store.TripSearch{
+		Query:    strings.TrimSpace(query.Get("q")),
+		Location: strings.TrimSpace(query.Get("location")),
+		From:     query.Get("from"),
+		To:       query.Get("to"),
+		Currency: query.Get("currency"),
+		Sort:     query.Get("sort"),
+		Limit:    20,
+	}
+
+	for _, date := range []string{search.From, search.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return nil, fmt.Errorf("invalid date %q, use YYYY-MM-DD", date)
+		}
+	}
+
+	if search.Currency != "" {
+		if err := Validate.Var(search.Currency, "iso4217"); err != nil {
+			return nil, fmt.Errorf("invalid currency %q", search.Currency)
+		}
+	}
+
+	switch strings.TrimPrefix(search.Sort, "-") {
+	case "", store.TripSortDate, store.TripSortPrice, store.TripSortRating:
+	case store.TripSortRelevance:
+		if search.Query == "" {
+			return nil, errors.New("sorting by relevance needs a query")
+		}
+	default:
+		return nil, fmt.Errorf("invalid sort %q, use date, price or rating", search.Sort)
+	}
+
+	for name, target := range map[string]**int64{"min_price": &search.Min_price, "max_price": &search.Max_price} {
+		if v := query.Get(name); v != "" {
+			price, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || price < 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*target = &price
+		}
+	}
+
+	if v := query.Get("min_seats"); v != "" {
+		seats, err := strconv.Atoi(v)
+		if err != nil || seats < 0 {
+			return nil, fmt.Errorf("invalid min_seats %q", v)
+		}
+		search.Min_seats = seats
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 100 {
+			return nil, fmt.Errorf("invalid limit %q, use 1 to 100", v)
+		}
+		search.Limit = limit
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := store.DecodeTripCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		search.After = cursor
+	}
+
+	return search, nil
+}
+
 // GetTripById godoc
 //
 // @Summary Fetches a trip by id
@@ -188,3 +420,83 @@ func (app *application) getUpcomingTripsHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 }
+
+// GetTripManifest godoc
+//
+// @Summary Fetches the passenger manifest of a trip
+// @Description Fetches every passenger on the trip's active bookings as JSON, or as CSV with ?format=csv or an Accept: text/csv header. Only the trip's operator and admins can read it
+// @Tags trips
+// @Produce json
+// @Produce text/csv
+// @Param id path int true "Trip id"
+// @Param format query string false "json or csv"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{array}		store.ManifestEntry
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/trips/id/{id}/manifest [get]
+func (app *application) getTripManifestHandler(w http.ResponseWriter, r *http.Request) {
+	tripId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if _, err := app.store.Trips.GetByID(ctx, tripId); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	manifest, err := app.store.Passengers.GetManifestByTripID(ctx, tripId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if r.URL.Query().Get("format") != "csv" && !strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		if err := app.jsonResponse(w, http.StatusOK, manifest); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="trip-%d-manifest.csv"`, tripId))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"booking_id", "booking_status", "booked_by", "full_name", "date_of_birth", "id_document", "special_needs", "boards_at", "alights_at"})
+	for _, entry := range manifest {
+		cw.Write([]string{
+			strconv.FormatInt(entry.Booking_id, 10),
+			entry.Booking_status,
+			entry.Booked_by,
+			entry.Full_name,
+			entry.Date_of_birth,
+			entry.Id_document,
+			entry.Special_needs,
+			stringOrEmpty(entry.Boards_at),
+			stringOrEmpty(entry.Alights_at),
+		})
+	}
+	cw.Flush()
+
+	if err := cw.Error(); err != nil {
+		app.logger.Errorw("error writing manifest csv", "trip_id", tripId, "error", err.Error())
+	}
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
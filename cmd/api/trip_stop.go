@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"transportService/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// stopTimeLayout is the local time at a stop, without a zone.
+const stopTimeLayout = "2006-01-02T15:04"
+
+type TripStopPayload struct {
+	Name       string        `json:"name" validate:"required,max=100"`
+	Location   *PointPayload `json:"location"`
+	Arrives_at string        `json:"arrives_at" validate:"omitempty,datetime=2006-01-02T15:04"`
+	Departs_at string        `json:"departs_at" validate:"omitempty,datetime=2006-01-02T15:04"`
+}
+
+// ReplaceTripStopsPayload is the whole route in travel order, no stops
+// makes the trip a single leg again.
+type ReplaceTripStopsPayload struct {
+	Stops []TripStopPayload `json:"stops" validate:"max=50,dive"`
+}
+
+// stops checks the route has a start and an end and that its times don't
+// go back, and returns it as trip stops.
+func (p *ReplaceTripStopsPayload) stops() ([]store.TripStop, error) {
+	if len(p.Stops) == 1 {
+		return nil, errors.New("a route needs at least two stops")
+	}
+
+	stops := make([]store.TripStop, 0, len(p.Stops))
+
+	var last time.Time
+
+	for i, payload := range p.Stops {
+		for _, value := range []string{payload.Arrives_at, payload.Departs_at} {
+			if value == "" {
+				continue
+			}
+
+			// validated as stopTimeLayout already
+			t, _ := time.Parse(stopTimeLayout, value)
+			if t.Before(last) {
+				return nil, fmt.Errorf("stop %d (%s) is scheduled before the stop ahead of it", i+1, payload.Name)
+			}
+			last = t
+		}
+
+		stops = append(stops, store.TripStop{
+			Name:       payload.Name,
+			Location:   payload.Location.point(),
+			Arrives_at: optionalString(payload.Arrives_at),
+			Departs_at: optionalString(payload.Departs_at),
+		})
+	}
+
+	return stops, nil
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// GetTripStops godoc
+//
+// @Summary Fetches the stops of a trip
+// @Description Fetches the stops of a trip's route in travel order with the seats left on the leg to the next stop. A trip without stops has an empty list
+// @Tags trips
+// @Produce json
+// @Param id path int true "Trip id"
+//
+//	@Success		200	{array}		store.TripStop
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/trips/id/{id}/stops [get]
+func (app *application) getTripStopsHandler(w http.ResponseWriter, r *http.Request) {
+	tripId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if _, err := app.store.Trips.GetByID(ctx, tripId); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	stops, err := app.store.TripStops.GetByTripID(ctx, tripId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if stops == nil {
+		stops = []store.TripStop{}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, stops); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// ReplaceTripStops godoc
+//
+// @Summary Sets the stops of a trip
+// @Description Replaces the trip's route with the stops in travel order, at least two or none to make the trip a single leg again. Times are local times like 2026-05-01T08:30 and can't go back along the route. Every leg starts with the seats the trip has left, so the stops can't be changed once a booking boards or alights at one
+// @Tags trips
+// @Accept json
+// @Produce json
+// @Param id path int true "Trip id"
+// @Param payload body	 ReplaceTripStopsPayload	 true	 "Put payload"
+// @Security ApiKeyAuth
+//
+//	@Success		200		{array}		store.TripStop
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/trips/id/{id}/stops [put]
+func (app *application) replaceTripStopsHandler(w http.ResponseWriter, r *http.Request) {
+	tripId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload ReplaceTripStopsPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	stops, err := payload.stops()
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := app.store.TripStops.Replace(ctx, tripId, stops); err != nil {
+		switch {
+		case errors.Is(err, store.ErrSegmentBooked):
+			app.conflictResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, stops); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+type TripAvailability struct {
+	Trip_id         int64  `json:"trip_id"`
+	From_stop_id    *int64 `json:"from_stop_id"`
+	To_stop_id      *int64 `json:"to_stop_id"`
+	Available_seats int    `json:"available_seats"`
+}
+
+// GetTripAvailability godoc
+//
+// @Summary Fetches the seats left between two stops
+// @Description Fetches the seats that can be booked from from_stop_id to to_stop_id, the first and the last stop when left out. A seat freed at a stop can be sold from there on, so a segment can have more seats left than the whole route
+// @Tags trips
+// @Produce json
+// @Param id path int true "Trip id"
+// @Param from_stop_id query int false "Boarding stop id"
+// @Param to_stop_id query int false "Alighting stop id"
+//
+//	@Success		200	{object}	TripAvailability
+//	@Failure		400	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/trips/id/{id}/availability [get]
+func (app *application) getTripAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	tripId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	availability := &TripAvailability{Trip_id: tripId}
+
+	query := r.URL.Query()
+
+	for param, id := range map[string]**int64{"from_stop_id": &availability.From_stop_id, "to_stop_id": &availability.To_stop_id} {
+		v := query.Get(param)
+		if v == "" {
+			continue
+		}
+
+		stopId, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("invalid %s %q", param, v))
+			return
+		}
+		*id = &stopId
+	}
+
+	availability.Available_seats, err = app.store.TripStops.Availability(r.Context(), tripId, availability.From_stop_id, availability.To_stop_id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidSegment):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, availability); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
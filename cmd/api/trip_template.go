@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"transportService/internal/rrule"
+	"transportService/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateTripTemplatePayload struct {
+	Name                   string        `json:"name" validate:"required,max=100"`
+	Description            string        `json:"description" validate:"required,max=255"`
+	Location               string        `json:"location" validate:"required,max=100"`
+	Duration_days          int           `json:"duration_days" validate:"min=0,max=365"`
+	Price                  MoneyPayload  `json:"price" validate:"required"`
+	Seats                  int           `json:"seats" validate:"required,min=1"`
+	Cancellation_policy_id *int64        `json:"cancellation_policy_id"`
+	Departure              *PointPayload `json:"departure"`
+	Destination            *PointPayload `json:"destination"`
+	Rrule                  string        `json:"rrule" validate:"required,max=255"`
+	First_date             string        `json:"first_date" validate:"required,datetime=2006-01-02"`
+	Exception_dates        []string      `json:"exception_dates" validate:"max=366,dive,datetime=2006-01-02"`
+}
+
+func (p *CreateTripTemplatePayload) template() *store.TripTemplate {
+	exceptions := p.Exception_dates
+	if exceptions == nil {
+		exceptions = []string{}
+	}
+
+	return &store.TripTemplate{
+		Name:                   p.Name,
+		Description:            p.Description,
+		Location:               p.Location,
+		Duration_days:          p.Duration_days,
+		Price:                  p.Price.money(),
+		Seats:                  p.Seats,
+		Cancellation_policy_id: p.Cancellation_policy_id,
+		Departure:              p.Departure.point(),
+		Destination:            p.Destination.point(),
+		Rrule:                  p.Rrule,
+		First_date:             p.First_date,
+		Exception_dates:        exceptions,
+	}
+}
+
+// UpdateTripTemplatePayload replaces the whole template, active false stops
+// it from generating departures but keeps those it already has.
+type UpdateTripTemplatePayload struct {
+	CreateTripTemplatePayload
+	Active *bool `json:"active" validate:"required"`
+}
+
+type CancelDeparturesPayload struct {
+	From   string `json:"from" validate:"omitempty,datetime=2006-01-02"`
+	To     string `json:"to" validate:"omitempty,datetime=2006-01-02"`
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+// CreateTripTemplate godoc
+//
+// @Summary Creates a trip template
+// @Description Creates a trip that departs on a schedule and generates its departures for the coming days straight away. The schedule is an RRULE like FREQ=WEEKLY;BYDAY=SA;UNTIL=20261231 starting on first_date, DAILY, WEEKLY and MONTHLY rules with INTERVAL, BYDAY, BYMONTHDAY, COUNT and UNTIL are supported. No departure is generated on the exception dates
+// @Tags trip templates
+// @Accept json
+// @Produce json
+// @Param payload body	 CreateTripTemplatePayload	 true	 "Post payload"
+// @Security ApiKeyAuth
+//
+//	@Success		201		{object}	store.TripTemplate
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/trip-templates [post]
+func (app *application) createTripTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateTripTemplatePayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	template := payload.template()
+	template.Operator_id = getUserFromContext(r).ID
+
+	ctx := r.Context()
+
+	if err := app.store.TripTemplates.Create(ctx, template); err != nil {
+		switch {
+		case errors.Is(err, rrule.ErrInvalidRule):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("cancellation policy not found"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, template); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetTripTemplates godoc
+//
+// @Summary Fetches the operator's trip templates
+// @Description Fetches the trip templates of the authenticated operator
+// @Tags trip templates
+// @Produce json
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	[]store.TripTemplate
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/trip-templates [get]
+func (app *application) getTripTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	templates, err := app.store.TripTemplates.GetByOperatorID(r.Context(), getUserFromContext(r).ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, templates); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetTripTemplateById godoc
+//
+// @Summary Fetches a trip template
+// @Description Fetches a trip template by id
+// @Tags trip templates
+// @Produce json
+// @Param id path int true "Trip template id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	store.TripTemplate
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/trip-templates/id/{id} [get]
+func (app *application) getTripTemplateByIdHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	template, err := app.store.TripTemplates.GetByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, template); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetTripTemplateDepartures godoc
+//
+// @Summary Fetches the departures of a trip template
+// @Description Fetches the trips generated from a template in departure order, cancelled ones included
+// @Tags trip templates
+// @Produce json
+// @Param id path int true "Trip template id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{object}	[]store.Trip
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/trip-templates/id/{id}/departures [get]
+func (app *application) getTripTemplateDeparturesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	trips, err := app.store.TripTemplates.GetDepartures(r.Context(), id)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, trips); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// UpdateTripTemplate godoc
+//
+// @Summary Edits a trip template and its future departures
+// @Description Replaces the template and carries the change over to every departure after today that isn't cancelled. Departures the new schedule no longer has are cancelled with their bookings, which are refunded in full, and departures it adds are generated. Seats can't drop below what a departure has booked
+// @Tags trip templates
+// @Accept json
+// @Produce json
+// @Param id path int true "Trip template id"
+// @Param payload body	 UpdateTripTemplatePayload	 true	 "Patch payload"
+// @Security ApiKeyAuth
+//
+//	@Success		200		{object}	store.TripTemplateUpdate
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/trip-templates/id/{id} [patch]
+func (app *application) updateTripTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload UpdateTripTemplatePayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	template := payload.template()
+	template.ID = id
+	template.Active = *payload.Active
+
+	ctx := r.Context()
+
+	update, err := app.store.TripTemplates.Update(ctx, template, &getUserFromContext(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, rrule.ErrInvalidRule):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, store.ErrSeatsBooked):
+			app.conflictResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.issueRefunds(ctx, update.Cancelled.Refunds)
+
+	if err := app.jsonResponse(w, http.StatusOK, update); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// CancelTripTemplateDepartures godoc
+//
+// @Summary Cancels future departures of a trip template
+// @Description Cancels the departures after today from from up to to. Without to every future departure is cancelled and the template stops generating new ones. Bookings on cancelled departures are cancelled and refunded in full whatever the cancellation policy, unpaid invoices are credited
+// @Tags trip templates
+// @Accept json
+// @Produce json
+// @Param id path int true "Trip template id"
+// @Param payload body	 CancelDeparturesPayload	 true	 "Post payload"
+// @Security ApiKeyAuth
+//
+//	@Success		200		{object}	store.DepartureCancellation
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/trip-templates/id/{id}/cancel [post]
+func (app *application) cancelTripTemplateDeparturesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload CancelDeparturesPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// both are YYYY-MM-DD, so they compare as strings
+	if payload.From != "" && payload.To != "" && payload.To < payload.From {
+		app.badRequestResponse(w, r, errors.New("to is before from"))
+		return
+	}
+
+	ctx := r.Context()
+
+	cancellation, err := app.store.TripTemplates.CancelDepartures(ctx, id, payload.From, payload.To, payload.Reason, &getUserFromContext(r).ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.issueRefunds(ctx, cancellation.Refunds)
+
+	if err := app.jsonResponse(w, http.StatusOK, cancellation); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
@@ -16,6 +16,7 @@ type CreateUserPayload struct {
 	Email      string `json:"email" validate:"required,max=255"`
 	Password   string `json:"password" validate:"required,min=8,max=24"`
 	Phone      string `json:"phone" validate:"required,max=255"`
+	Role       string `json:"role" validate:"omitempty,oneof=customer operator admin"`
 }
 
 // CreateUser godoc
@@ -58,6 +59,7 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 		Email:      payload.Email,
 		Password:   hashedPassword,
 		Phone:      payload.Phone,
+		Role:       store.Role{Name: payload.Role},
 	}
 
 	ctx := r.Context()
@@ -171,3 +173,37 @@ func (app *application) deleteUserByIDHandler(w http.ResponseWriter, r *http.Req
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// RevokeUserSessions godoc
+//
+// @Summary Revokes all sessions of a user
+// @Description Revokes every session of a user, logging them out on all devices
+// @Tags users
+// @Produce json
+// @Param id	path		int	true	"User ID"
+// @Security ApiKeyAuth
+//
+//	@Success		204	{object} string
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/users/id/{id}/sessions [delete]
+func (app *application) revokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	revoked, err := app.store.Sessions.RevokeByUserID(ctx, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.logger.Infow("sessions revoked", "user_id", userID, "count", revoked)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"transportService/internal/mailer"
+	"transportService/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type JoinWaitlistPayload struct {
+	User_id    int64              `json:"user_id" validate:"required"`
+	Trip_id    int64              `json:"trip_id" validate:"required"`
+	Passengers []PassengerPayload `json:"passengers" validate:"required,min=1,max=20,dive"`
+}
+
+// JoinWaitlist godoc
+//
+// @Summary Joins the waitlist of a trip
+// @Description Queues a party for a trip that doesn't have enough seats, seats that free up are offered in order as a time-limited booking
+// @Tags waitlist
+// @Accept json
+// @Produce json
+// @Param payload body	 JoinWaitlistPayload		true	"Post payload"
+// @Security ApiKeyAuth
+//
+//	@Success		201		{object}	store.WaitlistEntry
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/waitlist [post]
+func (app *application) joinWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	var payload JoinWaitlistPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	allowed, err := app.isOwnerOrRole(ctx, getUserFromContext(r), payload.User_id, store.RoleOperator)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if !allowed {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	entry := &store.WaitlistEntry{
+		User_id:    payload.User_id,
+		Trip_id:    payload.Trip_id,
+		Passengers: newPassengers(payload.Passengers),
+	}
+
+	if err := app.store.Waitlist.Join(ctx, entry); err != nil {
+		switch {
+		case errors.Is(err, store.ErrSeatsAvailable), errors.Is(err, store.ErrTripCancelled), errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, entry); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetWaitlistByTripId godoc
+//
+// @Summary Fetches the waitlist of a trip
+// @Description Fetches the waitlist of a trip, oldest entry first
+// @Tags waitlist
+// @Produce json
+// @Param id path int true "Trip id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{array}		store.WaitlistEntry
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/waitlist/tripId/{id} [get]
+func (app *application) getWaitlistByTripIdHandler(w http.ResponseWriter, r *http.Request) {
+	tripId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	entries, err := app.store.Waitlist.GetByTripID(r.Context(), tripId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, entries); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// GetWaitlistByUserId godoc
+//
+// @Summary Fetches the waitlist entries of a user
+// @Description Fetches the waitlist entries of a user, newest first
+// @Tags waitlist
+// @Produce json
+// @Param id path int true "User id"
+// @Security ApiKeyAuth
+//
+//	@Success		200	{array}		store.WaitlistEntry
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/waitlist/userId/{id} [get]
+func (app *application) getWaitlistByUserIdHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	entries, err := app.store.Waitlist.GetByUserID(r.Context(), userId)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, entries); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
+
+// LeaveWaitlist godoc
+//
+// @Summary Leaves a waitlist
+// @Description Takes a waiting entry off the waitlist
+// @Tags waitlist
+// @Produce json
+// @Param id path int true "Waitlist entry id"
+// @Security ApiKeyAuth
+//
+//	@Success		204	{object}	string
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/waitlist/id/{id} [delete]
+func (app *application) leaveWaitlistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Waitlist.Leave(r.Context(), id); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// promoteWaitlist offers seats that freed up on the trip to its waitlist and
+// mails everyone who got an offer. Failures are logged, the seats stay free
+// and the next cancellation or sweep tries again.
+func (app *application) promoteWaitlist(ctx context.Context, tripID int64) {
+	offered, err := app.store.Waitlist.Promote(ctx, tripID)
+	if err != nil {
+		app.logger.Errorw("error promoting waitlist", "trip_id", tripID, "error", err.Error())
+		return
+	}
+
+	if len(offered) == 0 {
+		return
+	}
+
+	trip, err := app.store.Trips.GetByID(ctx, tripID)
+	if err != nil {
+		app.logger.Errorw("error loading trip for waitlist offers", "trip_id", tripID, "error", err.Error())
+		return
+	}
+
+	for _, entry := range offered {
+		app.logger.Infow("waitlist entry offered a booking", "entry_id", entry.ID, "booking_id", *entry.Booking_id)
+
+		if err := app.notifyWaitlistOffer(ctx, trip, &entry); err != nil {
+			app.logger.Errorw("error sending waitlist offer", "entry_id", entry.ID, "error", err.Error())
+		}
+	}
+}
+
+func (app *application) notifyWaitlistOffer(ctx context.Context, trip *store.Trip, entry *store.WaitlistEntry) error {
+	user, err := app.store.Users.GetByID(ctx, entry.User_id)
+	if err != nil {
+		return err
+	}
+
+	booking, err := app.store.Bookings.GetByID(ctx, *entry.Booking_id)
+	if err != nil {
+		return err
+	}
+
+	// the offer lasts as long as the booking's hold
+	expiresAt := ""
+	if booking.Expires_at != nil {
+		expiresAt = *booking.Expires_at
+		if t, err := time.Parse(time.RFC3339, expiresAt); err == nil {
+			expiresAt = t.Format("2006-01-02 15:04 MST")
+		}
+	}
+
+	msg, err := mailer.NewMessage(mailer.WaitlistOfferTemplate, user.Email, struct {
+		Name      string
+		Trip      string
+		Seats     int
+		BookingID int64
+		ExpiresAt string
+	}{
+		Name:      user.First_name,
+		Trip:      trip.Name,
+		Seats:     entry.Quantity,
+		BookingID: *entry.Booking_id,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return app.mailer.Send(msg)
+}
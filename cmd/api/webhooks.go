@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"transportService/internal/payment"
+	"transportService/internal/store"
+)
+
+type webhookResponse struct {
+	Status string `json:"status"`
+}
+
+// PaymentWebhook godoc
+//
+// @Summary Receives payment provider events
+// @Description Verifies the HMAC signature in the X-Payment-Signature header, stores the raw event and applies it once, replayed events are acknowledged without being applied again
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param payload body	 payment.Event		true	"Provider event"
+//
+//	@Success		200		{object}	webhookResponse
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/webhooks/payments [post]
+func (app *application) paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	maxBytes := 1_048_578 // 1mb
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = payment.VerifySignature(
+		app.config.payment.webhookSecret,
+		body,
+		r.Header.Get(payment.SignatureHeader),
+		app.config.payment.webhookTolerance,
+	)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	var event payment.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if event.ID == "" || event.Type == "" {
+		app.badRequestResponse(w, r, fmt.Errorf("event id and type are required"))
+		return
+	}
+
+	ctx := r.Context()
+
+	record := &store.PaymentEvent{
+		Event_id: event.ID,
+		Type:     event.Type,
+		Payload:  body,
+	}
+
+	if err := app.store.PaymentEvents.Record(ctx, record); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if record.Processed_at != nil {
+		app.webhookResponse(w, r, "duplicate")
+		return
+	}
+
+	status := "processed"
+
+	switch event.Type {
+	case payment.EventIntentSucceeded, payment.EventIntentFailed:
+		if event.Data.Intent == nil {
+			app.badRequestResponse(w, r, fmt.Errorf("event has no intent"))
+			return
+		}
+
+		paymentStatus := store.PaymentStatusFailed
+		if event.Type == payment.EventIntentSucceeded {
+			paymentStatus = store.PaymentStatusComplete
+		}
+
+		var settlement *store.PaymentSettlement
+		settlement, err = app.store.PaymentEvents.SettlePayment(ctx, event.ID, event.Data.Intent.ID, paymentStatus)
+		if err == nil && settlement.Refund != nil {
+			app.issueRefund(ctx, settlement.Payment, settlement.Refund)
+		}
+	case payment.EventRefundSucceeded, payment.EventRefundFailed:
+		if event.Data.Refund == nil {
+			app.badRequestResponse(w, r, fmt.Errorf("event has no refund"))
+			return
+		}
+
+		refundStatus := store.RefundStatusFailed
+		if event.Type == payment.EventRefundSucceeded {
+			refundStatus = store.RefundStatusComplete
+		}
+
+		err = app.store.PaymentEvents.SettleRefund(ctx, event.ID, event.Data.Refund.ID, refundStatus)
+	default:
+		status = "ignored"
+		err = app.store.PaymentEvents.Skip(ctx, event.ID, "unhandled event type")
+	}
+
+	if err != nil {
+		if errors.Is(err, store.ErrDuplicateEvent) {
+			app.webhookResponse(w, r, "duplicate")
+			return
+		}
+
+		// the event stays unprocessed so the provider's retry picks it up
+		if markErr := app.store.PaymentEvents.MarkFailed(ctx, event.ID, err.Error()); markErr != nil {
+			app.logger.Errorw("error recording payment event failure", "event_id", event.ID, "error", markErr.Error())
+		}
+
+		if errors.Is(err, store.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.webhookResponse(w, r, status)
+}
+
+func (app *application) webhookResponse(w http.ResponseWriter, r *http.Request, status string) {
+	if err := app.jsonResponse(w, http.StatusOK, &webhookResponse{Status: status}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
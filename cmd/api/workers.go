@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"transportService/internal/mailer"
+	"transportService/internal/store"
+)
+
+// runEvery calls job every interval until ctx is cancelled, interval must
+// be positive.
+func (app *application) runEvery(ctx context.Context, interval time.Duration, job func(context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			job(ctx)
+		}
+	}
+}
+
+// expireBookingHolds cancels pending bookings whose hold ran out and offers
+// the freed seats to the waitlist.
+func (app *application) expireBookingHolds(ctx context.Context) {
+	expired, err := app.store.Bookings.ExpireHolds(ctx)
+	if err != nil {
+		app.logger.Errorw("error expiring booking holds", "error", err.Error())
+		return
+	}
+
+	trips := make(map[int64]bool)
+	for _, booking := range expired {
+		app.logger.Infow("booking hold expired", "booking_id", booking.ID, "trip_id", booking.Trip_id)
+		trips[booking.Trip_id] = true
+	}
+
+	for tripID := range trips {
+		app.promoteWaitlist(ctx, tripID)
+	}
+}
+
+// deleteExpiredIdempotencyKeys forgets idempotency keys once clients are no
+// longer expected to retry with them.
+func (app *application) deleteExpiredIdempotencyKeys(ctx context.Context) {
+	deleted, err := app.store.IdempotencyKeys.DeleteExpired(ctx, app.config.idempotency.ttl)
+	if err != nil {
+		app.logger.Errorw("error deleting expired idempotency keys", "error", err.Error())
+		return
+	}
+
+	if deleted > 0 {
+		app.logger.Infow("expired idempotency keys deleted", "count", deleted)
+	}
+}
+
+// runDunning marks unpaid invoices past their due date as overdue, sends the
+// reminders that are due and cancels bookings whose invoice stayed overdue
+// past the grace period, crediting the invoice in full.
+func (app *application) runDunning(ctx context.Context) {
+	marked, err := app.store.Invoices.MarkOverdue(ctx)
+	if err != nil {
+		app.logger.Errorw("error marking overdue invoices", "error", err.Error())
+		return
+	}
+
+	if marked > 0 {
+		app.logger.Infow("invoices marked overdue", "count", marked)
+	}
+
+	dunning := app.config.invoice.dunning
+
+	reminders, err := app.store.Invoices.RecordReminders(ctx, dunning.reminders)
+	if err != nil {
+		app.logger.Errorw("error recording invoice reminders", "error", err.Error())
+	}
+
+	for i := range reminders {
+		if err := app.sendInvoiceReminder(ctx, &reminders[i]); err != nil {
+			app.logger.Errorw("error sending invoice reminder", "invoice_id", reminders[i].Invoice_id, "stage", reminders[i].Stage, "error", err.Error())
+
+			if err := app.store.Invoices.DeleteReminder(ctx, reminders[i].ID); err != nil {
+				app.logger.Errorw("error releasing invoice reminder", "reminder_id", reminders[i].ID, "error", err.Error())
+			}
+		}
+	}
+
+	if dunning.cancelAfter > 0 {
+		app.cancelOverdueBookings(ctx, dunning.cancelAfter)
+	}
+}
+
+// generateTripDepartures keeps the departures of every active trip template
+// generated to the scheduling horizon.
+func (app *application) generateTripDepartures(ctx context.Context) {
+	templates, err := app.store.TripTemplates.GetActive(ctx)
+	if err != nil {
+		app.logger.Errorw("error loading trip templates", "error", err.Error())
+		return
+	}
+
+	for _, template := range templates {
+		generated, err := app.store.TripTemplates.Generate(ctx, template.ID)
+		if err != nil {
+			app.logger.Errorw("error generating trip departures", "template_id", template.ID, "error", err.Error())
+			continue
+		}
+
+		if generated > 0 {
+			app.logger.Infow("trip departures generated", "template_id", template.ID, "count", generated)
+		}
+	}
+}
+
+func (app *application) cancelOverdueBookings(ctx context.Context, days int) {
+	invoices, err := app.store.Invoices.GetOverdueSince(ctx, days)
+	if err != nil {
+		app.logger.Errorw("error loading long overdue invoices", "error", err.Error())
+		return
+	}
+
+	for _, invoice := range invoices {
+		reason := fmt.Sprintf("invoice %s unpaid %d days after it was due", invoice.Invoice_number, days)
+
+		cancellation, err := app.store.Bookings.CancelForOverdueInvoice(ctx, invoice.ID, reason)
+		if err != nil {
+			app.logger.Errorw("error cancelling booking of overdue invoice", "invoice_id", invoice.ID, "booking_id", invoice.Booking_id, "error", err.Error())
+			continue
+		}
+
+		app.logger.Infow("booking cancelled for overdue invoice", "invoice_id", invoice.ID, "booking_id", invoice.Booking_id)
+
+		app.issueRefunds(ctx, cancellation.Refunds)
+		app.promoteWaitlist(ctx, cancellation.Booking.Trip_id)
+	}
+}
+
+func (app *application) sendInvoiceReminder(ctx context.Context, reminder *store.InvoiceReminder) error {
+	user, err := app.store.Users.GetByID(ctx, reminder.User_id)
+	if err != nil {
+		return err
+	}
+
+	dunning := app.config.invoice.dunning
+
+	var cancelOn string
+	if dunning.cancelAfter > 0 {
+		if due, err := time.Parse(time.RFC3339, reminder.Due_date); err == nil {
+			cancelOn = due.AddDate(0, 0, dunning.cancelAfter).Format("2006-01-02")
+		}
+	}
+
+	msg, err := mailer.NewMessage(mailer.InvoiceReminderTemplate, user.Email, struct {
+		Name          string
+		InvoiceNumber string
+		BookingID     int64
+		Total         string
+		DueDate       string
+		DaysOverdue   int
+		Stage         int
+		Final         bool
+		CancelOn      string
+	}{
+		Name:          user.First_name,
+		InvoiceNumber: reminder.Invoice_number,
+		BookingID:     reminder.Booking_id,
+		Total:         reminder.Total.String(),
+		DueDate:       reminder.Due_date[:min(len(reminder.Due_date), 10)],
+		DaysOverdue:   reminder.Days_overdue,
+		Stage:         reminder.Stage,
+		Final:         reminder.Stage == len(dunning.reminders) && cancelOn != "",
+		CancelOn:      cancelOn,
+	})
+	if err != nil {
+		return err
+	}
+
+	return app.mailer.Send(msg)
+}
@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS "user" DROP COLUMN IF EXISTS role_id;
+
+DROP TABLE IF EXISTS role;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS role (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    level INT NOT NULL DEFAULT 0,
+    description TEXT
+);
+
+INSERT INTO role (name, level, description)
+VALUES
+    ('customer', 1, 'A customer can book trips and manage their own bookings, payments and comments'),
+    ('operator', 2, 'An operator can manage trips, accomodations and activities'),
+    ('admin', 3, 'An admin can manage users and everything an operator can')
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE IF EXISTS "user" ADD COLUMN IF NOT EXISTS role_id INT REFERENCES role(id);
+
+UPDATE "user" SET role_id = (SELECT id FROM role WHERE name = 'customer') WHERE role_id IS NULL;
+
+ALTER TABLE IF EXISTS "user" ALTER COLUMN role_id SET NOT NULL;
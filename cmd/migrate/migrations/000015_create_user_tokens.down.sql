@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_token;
+
+ALTER TABLE IF EXISTS "user" DROP COLUMN IF EXISTS is_verified;
@@ -0,0 +1,16 @@
+-- users that signed up before verification existed keep access
+ALTER TABLE IF EXISTS "user" ADD COLUMN IF NOT EXISTS is_verified BOOLEAN NOT NULL DEFAULT TRUE;
+
+ALTER TABLE IF EXISTS "user" ALTER COLUMN is_verified SET DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS user_token (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    purpose VARCHAR(30) NOT NULL CHECK (purpose IN ('email_verification', 'password_reset')),
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_token_user_id ON user_token(user_id, purpose);
@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS trip DROP CONSTRAINT IF EXISTS trip_available_seats_check;
@@ -0,0 +1,11 @@
+-- available seats were kept by hand until now, they are recounted from the
+-- bookings holding a seat so the check can be added, overbooked trips have
+-- none left
+UPDATE trip
+SET available_seats = GREATEST(trip.seats - (
+    SELECT COUNT(*) FROM booking
+    WHERE booking.trip_id = trip.id AND booking.status IS DISTINCT FROM 'cancelled'
+), 0);
+
+ALTER TABLE IF EXISTS trip
+    ADD CONSTRAINT trip_available_seats_check CHECK (available_seats >= 0 AND available_seats <= seats);
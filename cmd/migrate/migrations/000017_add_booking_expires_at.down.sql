@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_booking_pending_expires_at;
+
+ALTER TABLE IF EXISTS booking DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS booking ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_booking_pending_expires_at ON booking(expires_at) WHERE status = 'pending';
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS booking_status_history;
+
+UPDATE booking SET status = 'confirmed' WHERE status IN ('checked_in', 'completed');
+
+ALTER TABLE IF EXISTS booking DROP CONSTRAINT IF EXISTS booking_status_check;
+
+ALTER TABLE IF EXISTS booking
+    ADD CONSTRAINT booking_status_check CHECK (status IN ('pending', 'confirmed', 'cancelled'));
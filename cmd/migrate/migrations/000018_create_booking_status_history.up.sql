@@ -0,0 +1,16 @@
+ALTER TABLE IF EXISTS booking DROP CONSTRAINT IF EXISTS booking_status_check;
+
+ALTER TABLE IF EXISTS booking
+    ADD CONSTRAINT booking_status_check CHECK (status IN ('pending', 'confirmed', 'checked_in', 'completed', 'cancelled'));
+
+CREATE TABLE IF NOT EXISTS booking_status_history (
+    id SERIAL PRIMARY KEY,
+    booking_id INT NOT NULL REFERENCES booking(id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    changed_by INT REFERENCES "user"(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_booking_status_history_booking_id ON booking_status_history(booking_id);
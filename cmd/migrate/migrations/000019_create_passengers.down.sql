@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS passenger;
+
+ALTER TABLE IF EXISTS booking DROP COLUMN IF EXISTS quantity;
+
+ALTER TABLE IF EXISTS booking ADD CONSTRAINT booking_user_id_trip_id_key UNIQUE (user_id, trip_id);
@@ -0,0 +1,16 @@
+-- a user can now hold several bookings on the same trip
+ALTER TABLE IF EXISTS booking DROP CONSTRAINT IF EXISTS booking_user_id_trip_id_key;
+
+ALTER TABLE IF EXISTS booking ADD COLUMN IF NOT EXISTS quantity INT NOT NULL DEFAULT 1 CHECK (quantity > 0);
+
+CREATE TABLE IF NOT EXISTS passenger (
+    id SERIAL PRIMARY KEY,
+    booking_id INT NOT NULL REFERENCES booking(id) ON DELETE CASCADE,
+    full_name VARCHAR(255) NOT NULL,
+    date_of_birth DATE NOT NULL,
+    id_document VARCHAR(100) NOT NULL,
+    special_needs TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_passenger_booking_id ON passenger(booking_id);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS waitlist_entry (
+    id SERIAL PRIMARY KEY,
+    trip_id INT NOT NULL REFERENCES trip(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    passengers JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'waiting' CHECK (status IN ('waiting', 'offered', 'cancelled')),
+    booking_id INT REFERENCES booking(id) ON DELETE SET NULL,
+    offered_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- a user waits at most once per trip
+CREATE UNIQUE INDEX IF NOT EXISTS idx_waitlist_entry_waiting_user ON waitlist_entry(trip_id, user_id) WHERE status = 'waiting';
+
+CREATE INDEX IF NOT EXISTS idx_waitlist_entry_queue ON waitlist_entry(trip_id, created_at) WHERE status = 'waiting';
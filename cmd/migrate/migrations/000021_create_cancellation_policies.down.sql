@@ -0,0 +1,21 @@
+UPDATE invoice SET status = 'paid' WHERE status IN ('partially_refunded', 'refunded');
+
+ALTER TABLE IF EXISTS invoice DROP CONSTRAINT IF EXISTS invoice_status_check;
+
+ALTER TABLE IF EXISTS invoice
+    ADD CONSTRAINT invoice_status_check CHECK (status IN ('paid', 'unpaid', 'overdue'));
+
+UPDATE payment SET status = 'complete' WHERE status IN ('partially_refunded', 'refunded');
+
+ALTER TABLE IF EXISTS payment DROP CONSTRAINT IF EXISTS payment_status_check;
+
+ALTER TABLE IF EXISTS payment
+    ADD CONSTRAINT payment_status_check CHECK (status IN ('pending', 'complete', 'failed'));
+
+DROP TABLE IF EXISTS refund;
+
+ALTER TABLE IF EXISTS trip DROP COLUMN IF EXISTS cancellation_policy_id;
+
+DROP TABLE IF EXISTS cancellation_policy_rule;
+
+DROP TABLE IF EXISTS cancellation_policy;
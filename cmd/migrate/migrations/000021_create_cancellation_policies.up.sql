@@ -0,0 +1,51 @@
+CREATE TABLE IF NOT EXISTS cancellation_policy (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- a cancellation made at least min_days_before days ahead of the trip is
+-- refunded refund_percent of what was paid, the rule with the highest
+-- min_days_before that applies wins
+CREATE TABLE IF NOT EXISTS cancellation_policy_rule (
+    id SERIAL PRIMARY KEY,
+    policy_id INT NOT NULL REFERENCES cancellation_policy(id) ON DELETE CASCADE,
+    min_days_before INT NOT NULL CHECK (min_days_before >= 0),
+    refund_percent INT NOT NULL CHECK (refund_percent BETWEEN 0 AND 100),
+    UNIQUE (policy_id, min_days_before)
+);
+
+INSERT INTO cancellation_policy (name) VALUES ('standard') ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO cancellation_policy_rule (policy_id, min_days_before, refund_percent)
+SELECT id, rule.min_days_before, rule.refund_percent
+FROM cancellation_policy, (VALUES (15, 100), (7, 50), (0, 0)) AS rule(min_days_before, refund_percent)
+WHERE name = 'standard'
+ON CONFLICT (policy_id, min_days_before) DO NOTHING;
+
+ALTER TABLE IF EXISTS trip
+    ADD COLUMN IF NOT EXISTS cancellation_policy_id INT REFERENCES cancellation_policy(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS refund (
+    id SERIAL PRIMARY KEY,
+    payment_id INT NOT NULL REFERENCES payment(id) ON DELETE CASCADE,
+    booking_id INT NOT NULL REFERENCES booking(id) ON DELETE CASCADE,
+    amount FLOAT NOT NULL CHECK (amount >= 0),
+    refund_percent INT NOT NULL CHECK (refund_percent BETWEEN 0 AND 100),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'complete', 'failed')),
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refund_payment_id ON refund(payment_id);
+CREATE INDEX IF NOT EXISTS idx_refund_booking_id ON refund(booking_id);
+
+ALTER TABLE IF EXISTS payment DROP CONSTRAINT IF EXISTS payment_status_check;
+
+ALTER TABLE IF EXISTS payment
+    ADD CONSTRAINT payment_status_check CHECK (status IN ('pending', 'complete', 'failed', 'partially_refunded', 'refunded'));
+
+ALTER TABLE IF EXISTS invoice DROP CONSTRAINT IF EXISTS invoice_status_check;
+
+ALTER TABLE IF EXISTS invoice
+    ADD CONSTRAINT invoice_status_check CHECK (status IN ('paid', 'unpaid', 'overdue', 'partially_refunded', 'refunded'));
@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS refund DROP COLUMN IF EXISTS transaction_id;
@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS refund ADD COLUMN IF NOT EXISTS transaction_id VARCHAR(255) UNIQUE;
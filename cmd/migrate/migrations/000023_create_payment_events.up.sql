@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS payment_event (
+    id SERIAL PRIMARY KEY,
+    event_id VARCHAR(255) UNIQUE NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    received_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_event_unprocessed ON payment_event(received_at) WHERE processed_at IS NULL;
@@ -0,0 +1,18 @@
+-- scope is the authenticated user ("user:<id>"), keys only have to be unique
+-- per client
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    id SERIAL PRIMARY KEY,
+    scope VARCHAR(100) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    fingerprint CHAR(64) NOT NULL,
+    response_status INT,
+    response_body BYTEA,
+    content_type VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP,
+    UNIQUE (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_key_created_at ON idempotency_key(created_at);
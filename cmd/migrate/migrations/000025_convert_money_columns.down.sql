@@ -0,0 +1,19 @@
+ALTER TABLE IF EXISTS refund
+    DROP COLUMN IF EXISTS currency,
+    ALTER COLUMN amount TYPE FLOAT USING amount / 100.0;
+
+ALTER TABLE IF EXISTS payment
+    DROP COLUMN IF EXISTS currency,
+    ALTER COLUMN amount TYPE FLOAT USING amount / 100.0;
+
+ALTER TABLE IF EXISTS accomodation
+    DROP COLUMN IF EXISTS currency,
+    ALTER COLUMN price_per_night TYPE FLOAT USING price_per_night / 100.0;
+
+ALTER TABLE IF EXISTS activity
+    DROP COLUMN IF EXISTS currency,
+    ALTER COLUMN price TYPE FLOAT USING price / 100.0;
+
+ALTER TABLE IF EXISTS trip
+    DROP COLUMN IF EXISTS currency,
+    ALTER COLUMN price TYPE FLOAT USING price / 100.0;
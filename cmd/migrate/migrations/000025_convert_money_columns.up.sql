@@ -0,0 +1,27 @@
+-- amounts move from FLOAT to integer minor units with an explicit currency,
+-- existing rows were all priced in euros
+
+ALTER TABLE IF EXISTS trip
+    ALTER COLUMN price TYPE BIGINT USING ROUND(price * 100)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'EUR' CHECK (currency ~ '^[A-Z]{3}$');
+ALTER TABLE IF EXISTS trip ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE IF EXISTS activity
+    ALTER COLUMN price TYPE BIGINT USING ROUND(price * 100)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'EUR' CHECK (currency ~ '^[A-Z]{3}$');
+ALTER TABLE IF EXISTS activity ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE IF EXISTS accomodation
+    ALTER COLUMN price_per_night TYPE BIGINT USING ROUND(price_per_night * 100)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'EUR' CHECK (currency ~ '^[A-Z]{3}$');
+ALTER TABLE IF EXISTS accomodation ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE IF EXISTS payment
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount * 100)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'EUR' CHECK (currency ~ '^[A-Z]{3}$');
+ALTER TABLE IF EXISTS payment ALTER COLUMN currency DROP DEFAULT;
+
+ALTER TABLE IF EXISTS refund
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount * 100)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'EUR' CHECK (currency ~ '^[A-Z]{3}$');
+ALTER TABLE IF EXISTS refund ALTER COLUMN currency DROP DEFAULT;
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS payout;
+
+DROP TABLE IF EXISTS ledger_entry;
+
+DROP TABLE IF EXISTS ledger_transaction;
+
+DROP TABLE IF EXISTS ledger_account;
+
+DROP FUNCTION IF EXISTS ledger_check_balanced();
+
+DROP FUNCTION IF EXISTS ledger_append_only();
+
+ALTER TABLE IF EXISTS trip DROP COLUMN IF EXISTS operator_id;
@@ -0,0 +1,75 @@
+-- the operator running the trip, payments for it are owed to them
+ALTER TABLE IF EXISTS trip
+    ADD COLUMN IF NOT EXISTS operator_id INT REFERENCES "user"(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS ledger_account (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(100) UNIQUE NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('asset', 'liability', 'revenue', 'expense')),
+    currency CHAR(3) NOT NULL,
+    operator_id INT REFERENCES "user"(id) ON DELETE RESTRICT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- a transaction is posted once per business event, the unique reference
+-- makes reposting the same capture or refund a no-op
+CREATE TABLE IF NOT EXISTS ledger_transaction (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(30) NOT NULL CHECK (kind IN ('payment_capture', 'fee', 'refund', 'payout')),
+    reference_id BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (kind, reference_id)
+);
+
+-- amounts are signed minor units, debits positive and credits negative
+CREATE TABLE IF NOT EXISTS ledger_entry (
+    id SERIAL PRIMARY KEY,
+    transaction_id INT NOT NULL REFERENCES ledger_transaction(id) ON DELETE RESTRICT,
+    account_id INT NOT NULL REFERENCES ledger_account(id) ON DELETE RESTRICT,
+    amount BIGINT NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entry_transaction_id ON ledger_entry(transaction_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entry_account_id ON ledger_entry(account_id);
+
+CREATE OR REPLACE FUNCTION ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger rows are append-only, post a correcting transaction instead';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_transaction_append_only
+    BEFORE UPDATE OR DELETE ON ledger_transaction
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+CREATE TRIGGER ledger_entry_append_only
+    BEFORE UPDATE OR DELETE ON ledger_entry
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+-- checked at commit so all entries of a transaction are in
+CREATE OR REPLACE FUNCTION ledger_check_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM ledger_entry WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger transaction % does not balance', NEW.transaction_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_entry_balanced
+    AFTER INSERT ON ledger_entry
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_check_balanced();
+
+CREATE TABLE IF NOT EXISTS payout (
+    id SERIAL PRIMARY KEY,
+    operator_id INT NOT NULL REFERENCES "user"(id) ON DELETE RESTRICT,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency CHAR(3) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    created_by INT REFERENCES "user"(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
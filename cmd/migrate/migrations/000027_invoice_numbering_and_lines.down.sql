@@ -0,0 +1,21 @@
+DROP TABLE IF EXISTS invoice_sequence;
+
+DROP TABLE IF EXISTS invoice_line;
+
+DROP INDEX IF EXISTS idx_invoice_user_id;
+DROP INDEX IF EXISTS idx_invoice_payment_id;
+
+UPDATE invoice SET status = 'unpaid' WHERE status = 'void';
+
+ALTER TABLE IF EXISTS invoice DROP CONSTRAINT IF EXISTS invoice_status_check;
+
+ALTER TABLE IF EXISTS invoice
+    ADD CONSTRAINT invoice_status_check CHECK (status IN ('paid', 'unpaid', 'overdue', 'partially_refunded', 'refunded'));
+
+ALTER TABLE IF EXISTS invoice
+    DROP COLUMN IF EXISTS total,
+    DROP COLUMN IF EXISTS tax,
+    DROP COLUMN IF EXISTS subtotal,
+    DROP COLUMN IF EXISTS currency,
+    DROP COLUMN IF EXISTS user_id,
+    DROP COLUMN IF EXISTS booking_id;
@@ -0,0 +1,66 @@
+-- invoices carry their own totals so they stay as issued when prices change
+ALTER TABLE IF EXISTS invoice
+    ADD COLUMN IF NOT EXISTS booking_id INT REFERENCES booking(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS user_id INT REFERENCES "user"(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS currency CHAR(3),
+    ADD COLUMN IF NOT EXISTS subtotal BIGINT,
+    ADD COLUMN IF NOT EXISTS tax BIGINT,
+    ADD COLUMN IF NOT EXISTS total BIGINT;
+
+UPDATE invoice i
+SET booking_id = p.booking_id,
+    user_id = p.user_id,
+    currency = p.currency,
+    subtotal = p.amount,
+    tax = 0,
+    total = p.amount
+FROM payment p
+WHERE p.id = i.payment_id AND i.booking_id IS NULL;
+
+ALTER TABLE IF EXISTS invoice
+    ALTER COLUMN booking_id SET NOT NULL,
+    ALTER COLUMN user_id SET NOT NULL,
+    ALTER COLUMN currency SET NOT NULL,
+    ALTER COLUMN subtotal SET NOT NULL,
+    ALTER COLUMN tax SET NOT NULL,
+    ALTER COLUMN total SET NOT NULL;
+
+-- invoices of failed payments are voided, never deleted, so numbers stay gap-free
+ALTER TABLE IF EXISTS invoice DROP CONSTRAINT IF EXISTS invoice_status_check;
+
+ALTER TABLE IF EXISTS invoice
+    ADD CONSTRAINT invoice_status_check CHECK (status IN ('paid', 'unpaid', 'overdue', 'partially_refunded', 'refunded', 'void'));
+
+CREATE INDEX IF NOT EXISTS idx_invoice_payment_id ON invoice(payment_id);
+CREATE INDEX IF NOT EXISTS idx_invoice_user_id ON invoice(user_id);
+
+CREATE TABLE IF NOT EXISTS invoice_line (
+    id SERIAL PRIMARY KEY,
+    invoice_id INT NOT NULL REFERENCES invoice(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('trip', 'activity', 'accomodation')),
+    reference_id INT NOT NULL,
+    description TEXT NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    unit_price BIGINT NOT NULL,
+    tax_rate INT NOT NULL CHECK (tax_rate >= 0),
+    net BIGINT NOT NULL,
+    tax BIGINT NOT NULL,
+    total BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_line_invoice_id ON invoice_line(invoice_id);
+
+-- one counter per year, taken under a row lock in the issuing transaction so
+-- a rolled back invoice gives its number back
+CREATE TABLE IF NOT EXISTS invoice_sequence (
+    year INT PRIMARY KEY,
+    last_number INT NOT NULL
+);
+
+INSERT INTO invoice_sequence (year, last_number)
+SELECT substring(invoice_number FROM 5 FOR 4)::INT, MAX(substring(invoice_number FROM 10)::INT)
+FROM invoice
+WHERE invoice_number ~ '^INV-[0-9]{4}-[0-9]{6}$'
+GROUP BY 1
+ON CONFLICT (year) DO NOTHING;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_invoice_status_due_date;
+
+DROP TABLE IF EXISTS invoice_reminder;
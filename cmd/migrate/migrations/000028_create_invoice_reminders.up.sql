@@ -0,0 +1,12 @@
+-- one row per reminder stage sent for an overdue invoice, the unique stage
+-- keeps concurrent runs from sending the same reminder twice
+CREATE TABLE IF NOT EXISTS invoice_reminder (
+    id SERIAL PRIMARY KEY,
+    invoice_id INT NOT NULL REFERENCES invoice(id) ON DELETE CASCADE,
+    stage INT NOT NULL CHECK (stage > 0),
+    days_overdue INT NOT NULL,
+    sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (invoice_id, stage)
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoice_status_due_date ON invoice(status, due_date);
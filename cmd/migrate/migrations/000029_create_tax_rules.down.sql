@@ -0,0 +1,5 @@
+ALTER TABLE IF EXISTS invoice_line DROP COLUMN IF EXISTS tax_name;
+
+ALTER TABLE IF EXISTS activity DROP COLUMN IF EXISTS tax_exempt;
+
+DROP TABLE IF EXISTS tax_rule;
@@ -0,0 +1,18 @@
+-- a rule sets the tax rate for trips in a location, for a kind of item or for
+-- both, the most specific rule wins and a NULL column matches anything
+CREATE TABLE IF NOT EXISTS tax_rule (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    location VARCHAR(255),
+    item_type VARCHAR(20) CHECK (item_type IN ('trip', 'activity', 'accomodation')),
+    rate INT NOT NULL CHECK (rate >= 0 AND rate <= 10000),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tax_rule_scope ON tax_rule (LOWER(COALESCE(location, '')), COALESCE(item_type, ''));
+
+ALTER TABLE IF EXISTS activity
+    ADD COLUMN IF NOT EXISTS tax_exempt BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE IF EXISTS invoice_line
+    ADD COLUMN IF NOT EXISTS tax_name VARCHAR(100) NOT NULL DEFAULT 'Tax';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS credit_note_sequence;
+
+DROP TABLE IF EXISTS credit_note_line;
+
+DROP TABLE IF EXISTS credit_note;
@@ -0,0 +1,37 @@
+-- credit notes reduce what an invoice bills without touching the issued
+-- invoice, a refund gets at most one
+CREATE TABLE IF NOT EXISTS credit_note (
+    id SERIAL PRIMARY KEY,
+    credit_note_number VARCHAR(50) UNIQUE NOT NULL,
+    invoice_id INT NOT NULL REFERENCES invoice(id) ON DELETE RESTRICT,
+    refund_id INT UNIQUE REFERENCES refund(id) ON DELETE RESTRICT,
+    reason TEXT NOT NULL,
+    subtotal BIGINT NOT NULL,
+    tax BIGINT NOT NULL,
+    total BIGINT NOT NULL CHECK (total > 0),
+    currency CHAR(3) NOT NULL,
+    created_by INT REFERENCES "user"(id) ON DELETE SET NULL,
+    issued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_note_invoice_id ON credit_note(invoice_id);
+
+CREATE TABLE IF NOT EXISTS credit_note_line (
+    id SERIAL PRIMARY KEY,
+    credit_note_id INT NOT NULL REFERENCES credit_note(id) ON DELETE CASCADE,
+    invoice_line_id INT REFERENCES invoice_line(id) ON DELETE SET NULL,
+    description TEXT NOT NULL,
+    tax_name VARCHAR(100) NOT NULL,
+    tax_rate INT NOT NULL,
+    net BIGINT NOT NULL,
+    tax BIGINT NOT NULL,
+    total BIGINT NOT NULL,
+    currency CHAR(3) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_credit_note_line_credit_note_id ON credit_note_line(credit_note_id);
+
+CREATE TABLE IF NOT EXISTS credit_note_sequence (
+    year INT PRIMARY KEY,
+    last_number INT NOT NULL
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_comment_trip_id;
+DROP INDEX IF EXISTS idx_trip_price_id;
+DROP INDEX IF EXISTS idx_trip_start_date_id;
+DROP INDEX IF EXISTS idx_trip_location_lower;
@@ -0,0 +1,8 @@
+-- location prefix search compares lowercased values
+CREATE INDEX IF NOT EXISTS idx_trip_location_lower ON trip (LOWER(location) text_pattern_ops);
+
+-- keyset pagination walks these in order
+CREATE INDEX IF NOT EXISTS idx_trip_start_date_id ON trip (start_date, id);
+CREATE INDEX IF NOT EXISTS idx_trip_price_id ON trip (price, id);
+
+CREATE INDEX IF NOT EXISTS idx_comment_trip_id ON comment (trip_id);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_trip_search_vector;
+
+ALTER TABLE IF EXISTS trip DROP COLUMN IF EXISTS search_vector;
+
+DROP TRIGGER IF EXISTS activity_refresh_trip_names ON activity;
+
+DROP FUNCTION IF EXISTS trip_refresh_activity_names();
+
+ALTER TABLE IF EXISTS trip DROP COLUMN IF EXISTS activity_names;
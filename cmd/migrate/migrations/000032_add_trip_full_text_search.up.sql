@@ -0,0 +1,45 @@
+-- a generated column can only read its own row, so the names of a trip's
+-- activities are kept on the trip by a trigger
+ALTER TABLE trip ADD COLUMN IF NOT EXISTS activity_names TEXT NOT NULL DEFAULT '';
+
+UPDATE trip t
+SET activity_names = a.names
+FROM (
+    SELECT trip_id, string_agg(name, ' ' ORDER BY id) AS names
+    FROM activity
+    GROUP BY trip_id
+) a
+WHERE a.trip_id = t.id;
+
+CREATE OR REPLACE FUNCTION trip_refresh_activity_names() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE trip
+        SET activity_names = COALESCE((SELECT string_agg(name, ' ' ORDER BY id) FROM activity WHERE trip_id = OLD.trip_id), '')
+        WHERE id = OLD.trip_id;
+    END IF;
+
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE trip
+        SET activity_names = COALESCE((SELECT string_agg(name, ' ' ORDER BY id) FROM activity WHERE trip_id = NEW.trip_id), '')
+        WHERE id = NEW.trip_id;
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER activity_refresh_trip_names
+    AFTER INSERT OR UPDATE OF name, trip_id OR DELETE ON activity
+    FOR EACH ROW EXECUTE FUNCTION trip_refresh_activity_names();
+
+-- matches in the name and location weigh the most, then the description
+-- and last the activities
+ALTER TABLE trip ADD COLUMN IF NOT EXISTS search_vector TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(location, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(description, '')), 'B') ||
+    setweight(to_tsvector('english', activity_names), 'C')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_trip_search_vector ON trip USING GIN (search_vector);
@@ -0,0 +1,16 @@
+DROP INDEX IF EXISTS idx_accomodation_point;
+DROP INDEX IF EXISTS idx_trip_destination_point;
+DROP INDEX IF EXISTS idx_trip_departure_point;
+
+ALTER TABLE IF EXISTS accomodation
+    DROP CONSTRAINT IF EXISTS accomodation_point,
+    DROP COLUMN IF EXISTS lng,
+    DROP COLUMN IF EXISTS lat;
+
+ALTER TABLE IF EXISTS trip
+    DROP CONSTRAINT IF EXISTS trip_destination_point,
+    DROP CONSTRAINT IF EXISTS trip_departure_point,
+    DROP COLUMN IF EXISTS destination_lng,
+    DROP COLUMN IF EXISTS destination_lat,
+    DROP COLUMN IF EXISTS departure_lng,
+    DROP COLUMN IF EXISTS departure_lat;
@@ -0,0 +1,31 @@
+-- coordinates are WGS 84 degrees, a point is either complete or missing
+ALTER TABLE trip
+    ADD COLUMN IF NOT EXISTS departure_lat DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS departure_lng DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS destination_lat DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS destination_lng DOUBLE PRECISION,
+    ADD CONSTRAINT trip_departure_point CHECK (
+        (departure_lat IS NULL) = (departure_lng IS NULL)
+        AND departure_lat BETWEEN -90 AND 90
+        AND departure_lng BETWEEN -180 AND 180
+    ),
+    ADD CONSTRAINT trip_destination_point CHECK (
+        (destination_lat IS NULL) = (destination_lng IS NULL)
+        AND destination_lat BETWEEN -90 AND 90
+        AND destination_lng BETWEEN -180 AND 180
+    );
+
+ALTER TABLE accomodation
+    ADD COLUMN IF NOT EXISTS lat DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS lng DOUBLE PRECISION,
+    ADD CONSTRAINT accomodation_point CHECK (
+        (lat IS NULL) = (lng IS NULL)
+        AND lat BETWEEN -90 AND 90
+        AND lng BETWEEN -180 AND 180
+    );
+
+-- nearby searches narrow to a bounding box on these before measuring the
+-- distance
+CREATE INDEX IF NOT EXISTS idx_trip_departure_point ON trip (departure_lat, departure_lng);
+CREATE INDEX IF NOT EXISTS idx_trip_destination_point ON trip (destination_lat, destination_lng);
+CREATE INDEX IF NOT EXISTS idx_accomodation_point ON accomodation (lat, lng);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_trip_template_start_date;
+
+ALTER TABLE IF EXISTS trip
+    DROP COLUMN IF EXISTS cancelled_at,
+    DROP COLUMN IF EXISTS template_id;
+
+DROP TABLE IF EXISTS trip_template;
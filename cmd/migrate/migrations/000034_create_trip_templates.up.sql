@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS trip_template (
+    id SERIAL PRIMARY KEY,
+    operator_id INT NOT NULL REFERENCES "user"(id) ON DELETE RESTRICT,
+    name VARCHAR(100) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    location VARCHAR(100) NOT NULL,
+    duration_days INT NOT NULL DEFAULT 0 CHECK (duration_days >= 0),
+    price BIGINT NOT NULL CHECK (price >= 0),
+    currency CHAR(3) NOT NULL,
+    seats INT NOT NULL CHECK (seats > 0),
+    cancellation_policy_id INT REFERENCES cancellation_policy(id) ON DELETE SET NULL,
+    departure_lat DOUBLE PRECISION,
+    departure_lng DOUBLE PRECISION,
+    destination_lat DOUBLE PRECISION,
+    destination_lng DOUBLE PRECISION,
+    -- RRULE-style recurrence, first_date is its DTSTART
+    rrule TEXT NOT NULL,
+    first_date DATE NOT NULL,
+    exception_dates DATE[] NOT NULL DEFAULT '{}',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    generated_until DATE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_trip_template_operator_id ON trip_template (operator_id);
+
+ALTER TABLE trip
+    ADD COLUMN IF NOT EXISTS template_id INT REFERENCES trip_template(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP;
+
+-- a template departs at most once a day, so generating twice is harmless
+CREATE UNIQUE INDEX IF NOT EXISTS idx_trip_template_start_date ON trip (template_id, start_date);
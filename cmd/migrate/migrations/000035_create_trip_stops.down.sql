@@ -0,0 +1,5 @@
+ALTER TABLE IF EXISTS booking
+    DROP COLUMN IF EXISTS to_stop_id,
+    DROP COLUMN IF EXISTS from_stop_id;
+
+DROP TABLE IF EXISTS trip_stop;
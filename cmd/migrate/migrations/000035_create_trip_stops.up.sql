@@ -0,0 +1,27 @@
+-- the stops of a route in travel order, times are the local time at the stop
+CREATE TABLE IF NOT EXISTS trip_stop (
+    id SERIAL PRIMARY KEY,
+    trip_id INT NOT NULL REFERENCES trip(id) ON DELETE CASCADE,
+    position INT NOT NULL CHECK (position >= 0),
+    name VARCHAR(100) NOT NULL,
+    lat DOUBLE PRECISION,
+    lng DOUBLE PRECISION,
+    arrives_at TIMESTAMP,
+    departs_at TIMESTAMP,
+    -- seats left on the leg from this stop to the next one, unused on the
+    -- last stop which starts no leg
+    available_seats INT NOT NULL CHECK (available_seats >= 0),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (trip_id, position),
+    CONSTRAINT trip_stop_point CHECK (
+        (lat IS NULL) = (lng IS NULL)
+        AND lat BETWEEN -90 AND 90
+        AND lng BETWEEN -180 AND 180
+    ),
+    CONSTRAINT trip_stop_times CHECK (arrives_at IS NULL OR departs_at IS NULL OR arrives_at <= departs_at)
+);
+
+-- a booking without stops rides the whole route
+ALTER TABLE booking
+    ADD COLUMN IF NOT EXISTS from_stop_id INT REFERENCES trip_stop(id) ON DELETE RESTRICT,
+    ADD COLUMN IF NOT EXISTS to_stop_id INT REFERENCES trip_stop(id) ON DELETE RESTRICT;
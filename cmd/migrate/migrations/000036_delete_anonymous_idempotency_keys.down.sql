@@ -0,0 +1,2 @@
+-- the deleted keys are not restored, they would only replay stale responses
+SELECT 1;
@@ -0,0 +1,3 @@
+-- keys are only honored for authenticated users now, anonymous ones could
+-- hold the tokens of login and refresh responses
+DELETE FROM idempotency_key WHERE scope = 'anonymous';
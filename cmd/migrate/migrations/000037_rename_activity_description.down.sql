@@ -0,0 +1,10 @@
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'activity' AND column_name = 'description'
+    ) THEN
+        ALTER TABLE activity RENAME COLUMN description TO decription;
+    END IF;
+END
+$$;
@@ -0,0 +1,12 @@
+-- the queries have always used "description", databases that already
+-- renamed the column are left as they are
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'activity' AND column_name = 'decription'
+    ) THEN
+        ALTER TABLE activity RENAME COLUMN decription TO description;
+    END IF;
+END
+$$;
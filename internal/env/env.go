@@ -4,6 +4,8 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -38,4 +40,36 @@ func GetInt(key string, fallback int) int{
 	}
 
 	return valAsInt
-}
\ No newline at end of file
+}
+func GetDuration(key string, fallback time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	valAsDuration, err := time.ParseDuration(val)
+	if err != nil {
+		return fallback
+	}
+
+	return valAsDuration
+}
+
+// GetIntList reads a comma separated list of integers, e.g. "1,7,14".
+func GetIntList(key string, fallback []int) []int {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	var list []int
+	for _, part := range strings.Split(val, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return fallback
+		}
+		list = append(list, n)
+	}
+
+	return list
+}
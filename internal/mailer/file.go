@@ -0,0 +1,34 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileMailer writes every message as an .eml file into dir instead of
+// sending it, handy when running the service locally.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{dir, from}, nil
+}
+
+func (m *FileMailer) Send(msg *Message) error {
+	if msg.From == "" {
+		msg.From = m.from
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+
+	return os.WriteFile(filepath.Join(m.dir, name), encode(msg), 0o644)
+}
@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"text/template"
+)
+
+const (
+	VerifyEmailTemplate     = "verify_email.tmpl"
+	ResetPasswordTemplate   = "reset_password.tmpl"
+	WaitlistOfferTemplate   = "waitlist_offer.tmpl"
+	InvoiceReminderTemplate = "invoice_reminder.tmpl"
+)
+
+//go:embed templates
+var FS embed.FS
+
+type Message struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Client delivers messages, swap the implementation to change how mail
+// leaves the service.
+type Client interface {
+	Send(msg *Message) error
+}
+
+// NewMessage renders the "subject" and "body" blocks of templateFile with
+// data into a message addressed to to.
+func NewMessage(templateFile, to string, data any) (*Message, error) {
+	tmpl, err := template.ParseFS(FS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(subject, "subject", data); err != nil {
+		return nil, err
+	}
+
+	body := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(body, "body", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		To:      to,
+		Subject: subject.String(),
+		Body:    body.String(),
+	}, nil
+}
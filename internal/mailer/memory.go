@@ -0,0 +1,35 @@
+package mailer
+
+import "sync"
+
+// InMemoryMailer keeps sent messages in memory so they can be inspected.
+type InMemoryMailer struct {
+	mu       sync.Mutex
+	from     string
+	messages []Message
+}
+
+func NewInMemoryMailer(from string) *InMemoryMailer {
+	return &InMemoryMailer{from: from}
+}
+
+func (m *InMemoryMailer) Send(msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if msg.From == "" {
+		msg.From = m.from
+	}
+
+	m.messages = append(m.messages, *msg)
+
+	return nil
+}
+
+// Messages returns a copy of every message sent so far.
+func (m *InMemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,179 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// ScenarioSuccess captures every charge straight away.
+	ScenarioSuccess = "success"
+	// ScenarioDecline declines every charge.
+	ScenarioDecline = "decline"
+	// ScenarioDelay accepts charges but only settles them once the
+	// configured delay has passed.
+	ScenarioDelay = "delay"
+)
+
+// MockProvider is an in-process Provider for local development and tests.
+// The scenario is picked per charge from the intent's payment method when it
+// names one, and falls back to the provider's default otherwise.
+type MockProvider struct {
+	mu       sync.Mutex
+	scenario string
+	delay    time.Duration
+	intents  map[string]*mockIntent
+}
+
+type mockIntent struct {
+	Intent
+	scenario  string
+	settlesAt time.Time
+}
+
+func NewMockProvider(scenario string, delay time.Duration) *MockProvider {
+	if !isScenario(scenario) {
+		scenario = ScenarioSuccess
+	}
+
+	return &MockProvider{
+		scenario: scenario,
+		delay:    delay,
+		intents:  make(map[string]*mockIntent),
+	}
+}
+
+func (m *MockProvider) CreateIntent(ctx context.Context, params IntentParams) (*Intent, error) {
+	if params.Amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive, got %d", params.Amount)
+	}
+
+	id, err := mockID("pi")
+	if err != nil {
+		return nil, err
+	}
+
+	scenario := m.scenario
+	if isScenario(params.Payment_method) {
+		scenario = params.Payment_method
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent := &mockIntent{
+		Intent: Intent{
+			ID:        id,
+			Amount:    params.Amount,
+			Currency:  params.Currency,
+			Reference: params.Reference,
+			Status:    IntentStatusRequiresCapture,
+		},
+		scenario: scenario,
+	}
+	m.intents[id] = intent
+
+	result := intent.Intent
+	return &result, nil
+}
+
+func (m *MockProvider) Capture(ctx context.Context, intentID string) (*Intent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent, ok := m.intents[intentID]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+
+	if intent.Status != IntentStatusRequiresCapture {
+		return nil, ErrInvalidState
+	}
+
+	switch intent.scenario {
+	case ScenarioDecline:
+		intent.Status = IntentStatusFailed
+		intent.Failure_reason = "card declined"
+	case ScenarioDelay:
+		intent.Status = IntentStatusProcessing
+		intent.settlesAt = time.Now().Add(m.delay)
+	default:
+		intent.Status = IntentStatusSucceeded
+	}
+
+	result := intent.Intent
+	return &result, nil
+}
+
+func (m *MockProvider) Refund(ctx context.Context, intentID string, amount int64) (*Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent, ok := m.intents[intentID]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+
+	m.settle(intent)
+
+	if intent.Status != IntentStatusSucceeded {
+		return nil, ErrInvalidState
+	}
+
+	if amount <= 0 || amount > intent.Amount-intent.Refunded {
+		return nil, ErrRefundTooLarge
+	}
+
+	id, err := mockID("re")
+	if err != nil {
+		return nil, err
+	}
+
+	intent.Refunded += amount
+
+	return &Refund{
+		ID:        id,
+		Intent_id: intent.ID,
+		Amount:    amount,
+		Status:    RefundStatusSucceeded,
+	}, nil
+}
+
+func (m *MockProvider) GetIntent(ctx context.Context, intentID string) (*Intent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	intent, ok := m.intents[intentID]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+
+	m.settle(intent)
+
+	result := intent.Intent
+	return &result, nil
+}
+
+// settle completes a delayed charge once its delay has passed.
+func (m *MockProvider) settle(intent *mockIntent) {
+	if intent.Status == IntentStatusProcessing && !time.Now().Before(intent.settlesAt) {
+		intent.Status = IntentStatusSucceeded
+	}
+}
+
+func isScenario(s string) bool {
+	return s == ScenarioSuccess || s == ScenarioDecline || s == ScenarioDelay
+}
+
+func mockID(prefix string) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return prefix + "_mock_" + hex.EncodeToString(b), nil
+}
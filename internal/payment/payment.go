@@ -0,0 +1,64 @@
+package payment
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	// IntentStatusRequiresCapture is an intent that was created but not
+	// charged yet.
+	IntentStatusRequiresCapture = "requires_capture"
+	// IntentStatusProcessing is a charge the provider has accepted but not
+	// settled, its final status has to be fetched later.
+	IntentStatusProcessing = "processing"
+	IntentStatusSucceeded  = "succeeded"
+	IntentStatusFailed     = "failed"
+
+	RefundStatusSucceeded = "succeeded"
+	RefundStatusFailed    = "failed"
+)
+
+var (
+	ErrIntentNotFound = errors.New("payment intent not found")
+	ErrInvalidState   = errors.New("payment intent is not in a state that allows this")
+	ErrRefundTooLarge = errors.New("refund exceeds the captured amount")
+)
+
+// IntentParams describes a charge. Amounts throughout the package are in the
+// currency's minor unit, Payment_method is handed to the provider as is.
+type IntentParams struct {
+	Amount         int64
+	Currency       string
+	Reference      string
+	Payment_method string
+}
+
+// Intent is the provider's view of a charge.
+type Intent struct {
+	ID             string `json:"id"`
+	Amount         int64  `json:"amount"`
+	Currency       string `json:"currency"`
+	Reference      string `json:"reference"`
+	Status         string `json:"status"`
+	Refunded       int64  `json:"refunded"`
+	Failure_reason string `json:"failure_reason,omitempty"`
+}
+
+type Refund struct {
+	ID             string `json:"id"`
+	Intent_id      string `json:"intent_id"`
+	Amount         int64  `json:"amount"`
+	Status         string `json:"status"`
+	Failure_reason string `json:"failure_reason,omitempty"`
+}
+
+// Provider moves money, the server drives it so clients never report the
+// outcome of a payment themselves. A declined charge is not an error, it is
+// reported through the intent's status.
+type Provider interface {
+	CreateIntent(ctx context.Context, params IntentParams) (*Intent, error)
+	Capture(ctx context.Context, intentID string) (*Intent, error)
+	Refund(ctx context.Context, intentID string, amount int64) (*Refund, error)
+	GetIntent(ctx context.Context, intentID string) (*Intent, error)
+}
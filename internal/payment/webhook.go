@@ -0,0 +1,101 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the webhook signature, formatted as
+	// "t=<unix seconds>,v1=<hex hmac-sha256 of "<t>.<body>">".
+	SignatureHeader = "X-Payment-Signature"
+
+	EventIntentSucceeded = "payment_intent.succeeded"
+	EventIntentFailed    = "payment_intent.failed"
+	EventRefundSucceeded = "refund.succeeded"
+	EventRefundFailed    = "refund.failed"
+)
+
+var (
+	ErrInvalidSignature = errors.New("webhook signature is invalid")
+	ErrSignatureExpired = errors.New("webhook signature is too old")
+)
+
+// Event is a notification the provider sends about an intent or a refund.
+type Event struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Created int64     `json:"created"`
+	Data    EventData `json:"data"`
+}
+
+type EventData struct {
+	Intent *Intent `json:"intent,omitempty"`
+	Refund *Refund `json:"refund,omitempty"`
+}
+
+// Sign returns the signature header value for payload signed at t.
+func Sign(secret string, payload []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+
+	return fmt.Sprintf("t=%s,v1=%s", ts, computeSignature(secret, ts, payload))
+}
+
+// VerifySignature checks header against payload and rejects signatures
+// older than tolerance so captured requests can't be replayed later.
+func VerifySignature(secret string, payload []byte, header string, tolerance time.Duration) error {
+	var ts string
+	var signatures []string
+
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	if ts == "" || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+
+	expected := []byte(computeSignature(secret, ts, payload))
+
+	for _, signature := range signatures {
+		if hmac.Equal(expected, []byte(signature)) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+func computeSignature(secret, ts string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,52 @@
+package pdf
+
+import (
+	"strconv"
+	"transportService/internal/store"
+)
+
+// Confirmation renders the confirmation of a booking with its passengers
+// and what was paid for it.
+func Confirmation(brand Brand, booking *store.Booking, trip *store.Trip, invoices []store.Invoice) []byte {
+	l := newLayout(brand, "BOOKING CONFIRMATION", "Booking "+itoa(booking.ID))
+
+	l.badge(booking.Status, l.y)
+	l.field("Booking", "#"+itoa(booking.ID))
+	l.field("Booked on", date(booking.Created_at))
+	l.field("Passengers", strconv.Itoa(booking.Quantity))
+
+	l.heading("Trip")
+	l.field("Trip", trip.Name)
+	l.field("Location", trip.Location)
+	l.field("Departs", date(trip.Start_date))
+	l.field("Returns", date(trip.End_date))
+
+	l.heading("Passengers")
+	for i, passenger := range booking.Passengers {
+		l.ensure(16)
+		l.page.Text(margin, l.y, Helvetica, 10, gray, strconv.Itoa(i+1)+".")
+		l.page.Text(margin+20, l.y, HelveticaBold, 10, Black, Truncate(HelveticaBold, 10, 200, passenger.Full_name))
+		l.page.Text(margin+230, l.y, Helvetica, 10, Black, "born "+date(passenger.Date_of_birth))
+		if passenger.Special_needs != "" {
+			l.page.Text(margin+330, l.y, Helvetica, 10, gray, Truncate(Helvetica, 10, contentRight-margin-330, passenger.Special_needs))
+		}
+		l.y += 16
+	}
+
+	for i := range invoices {
+		invoice := &invoices[i]
+		if invoice.Status == store.InvoiceStatusVoid {
+			continue
+		}
+
+		l.heading("Payment")
+		l.badge(invoice.Status, l.y)
+		l.field("Invoice", invoice.Invoice_number)
+		l.field("Issued", date(invoice.Issue_at))
+		l.y += 8
+		l.lineTable(invoice.Lines)
+		l.totals(invoice)
+	}
+
+	return l.finish()
+}
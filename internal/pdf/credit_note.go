@@ -0,0 +1,49 @@
+package pdf
+
+import (
+	"strings"
+	"transportService/internal/store"
+)
+
+// CreditNote renders a credit note issued to customer.
+func CreditNote(brand Brand, note *store.CreditNote, customer *store.User) []byte {
+	l := newLayout(brand, "CREDIT NOTE", "Credit note "+note.Credit_note_number)
+
+	l.field("Credit note", note.Credit_note_number)
+	l.field("Issued", date(note.Issued_at))
+	l.field("Credits invoice", note.Invoice_number)
+	l.field("Reason", Truncate(Helvetica, 10, contentRight-margin-110, note.Reason))
+
+	if customer != nil {
+		l.heading("Credited to")
+		name := strings.TrimSpace(customer.First_name + " " + customer.Last_name)
+		if name != "" {
+			l.field("Name", name)
+		}
+		l.field("Email", customer.Email)
+	}
+
+	l.heading("Credited items")
+
+	// credited parts go in the invoice table as a single unit
+	lines := make([]store.InvoiceLine, len(note.Lines))
+	for i, line := range note.Lines {
+		lines[i] = store.InvoiceLine{
+			Description: line.Description,
+			Quantity:    1,
+			Unit_price:  line.Total,
+			Tax_name:    line.Tax_name,
+			Tax_rate:    line.Tax_rate,
+			Total:       line.Total,
+		}
+	}
+	l.lineTable(lines)
+
+	l.totals(&store.Invoice{
+		Subtotal:      note.Subtotal,
+		Tax_breakdown: note.Tax_breakdown,
+		Total:         note.Total,
+	})
+
+	return l.finish()
+}
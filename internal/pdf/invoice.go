@@ -0,0 +1,42 @@
+package pdf
+
+import (
+	"strings"
+	"transportService/internal/store"
+)
+
+// Invoice renders the invoice billed to customer.
+func Invoice(brand Brand, invoice *store.Invoice, customer *store.User) []byte {
+	l := newLayout(brand, "INVOICE", "Invoice "+invoice.Invoice_number)
+
+	l.badge(invoice.Status, l.y)
+	l.field("Invoice number", invoice.Invoice_number)
+	l.field("Issued", date(invoice.Issue_at))
+	l.field("Due", date(invoice.Due_date))
+	l.field("Booking", "#"+itoa(invoice.Booking_id))
+
+	if customer != nil {
+		l.heading("Billed to")
+		name := strings.TrimSpace(customer.First_name + " " + customer.Last_name)
+		if name != "" {
+			l.field("Name", name)
+		}
+		l.field("Email", customer.Email)
+	}
+
+	l.heading("Items")
+	l.lineTable(invoice.Lines)
+	l.totals(invoice)
+
+	l.ensure(40)
+	if !invoice.Credited.IsZero() {
+		l.total("Credited", "-"+invoice.Credited.String(), Helvetica)
+	}
+	l.total("Outstanding", invoice.Outstanding.String(), HelveticaBold)
+
+	l.ensure(30)
+	l.y += 14
+	l.page.Text(margin, l.y, Helvetica, 9, gray, "All prices include tax.")
+
+	return l.finish()
+}
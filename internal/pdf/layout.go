@@ -0,0 +1,206 @@
+package pdf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"transportService/internal/store"
+)
+
+const (
+	margin       = 40.0
+	contentRight = PageWidth - margin
+	footerTop    = PageHeight - 50
+)
+
+var (
+	gray      = RGB(110, 110, 110)
+	lightGray = RGB(238, 238, 238)
+	green     = RGB(46, 125, 50)
+	amber     = RGB(230, 145, 0)
+	red       = RGB(198, 40, 40)
+)
+
+// Brand is what every document is headed with.
+type Brand struct {
+	Name    string
+	Website string
+	Color   Color
+}
+
+// layout writes top to bottom, starting a new page when the next block
+// doesn't fit above the footer.
+type layout struct {
+	doc   *Document
+	brand Brand
+	title string
+	page  *Page
+	y     float64
+}
+
+func newLayout(brand Brand, title, docTitle string) *layout {
+	l := &layout{doc: New(docTitle), brand: brand, title: title}
+	l.newPage()
+
+	return l
+}
+
+func (l *layout) newPage() {
+	l.page = l.doc.AddPage()
+
+	l.page.Rect(0, 0, PageWidth, 70, l.brand.Color)
+	l.page.Text(margin, 44, HelveticaBold, 20, White, l.brand.Name)
+	l.page.TextRight(contentRight, 44, HelveticaBold, 14, White, l.title)
+
+	l.y = 100
+}
+
+func (l *layout) ensure(height float64) {
+	if l.y+height > footerTop {
+		l.newPage()
+	}
+}
+
+// field writes a label and its value on one row.
+func (l *layout) field(label, value string) {
+	l.ensure(16)
+	l.page.Text(margin, l.y, Helvetica, 10, gray, label)
+	l.page.Text(margin+110, l.y, Helvetica, 10, Black, value)
+	l.y += 16
+}
+
+func (l *layout) heading(s string) {
+	l.ensure(40)
+	l.y += 12
+	l.page.Text(margin, l.y, HelveticaBold, 12, Black, s)
+	l.y += 8
+	l.page.Line(margin, l.y, contentRight, l.y, 0.5, gray)
+	l.y += 16
+}
+
+// badge draws a status pill at the top right of the content.
+func (l *layout) badge(status string, y float64) {
+	label := strings.ToUpper(strings.ReplaceAll(status, "_", " "))
+	width := TextWidth(HelveticaBold, 10, label) + 16
+
+	l.page.Rect(contentRight-width, y-13, width, 18, statusColor(status))
+	l.page.Text(contentRight-width+8, y, HelveticaBold, 10, White, label)
+}
+
+type column struct {
+	title string
+	right float64
+}
+
+// invoiceColumns are the amount columns of the line table, the
+// description fills the space to their left.
+var invoiceColumns = []column{
+	{"Qty", 330},
+	{"Unit price", 405},
+	{"Tax", 455},
+	{"Total", contentRight},
+}
+
+func (l *layout) lineTable(lines []store.InvoiceLine) {
+	l.tableHeader()
+
+	for _, line := range lines {
+		if l.y+18 > footerTop {
+			l.newPage()
+			l.tableHeader()
+		}
+
+		description := Truncate(Helvetica, 10, 270-margin, line.Description)
+		values := []string{
+			strconv.Itoa(line.Quantity),
+			line.Unit_price.String(),
+			TaxRate(line.Tax_rate),
+			line.Total.String(),
+		}
+
+		l.page.Text(margin+4, l.y, Helvetica, 10, Black, description)
+		for i, c := range invoiceColumns {
+			l.page.TextRight(c.right-4, l.y, Helvetica, 10, Black, values[i])
+		}
+
+		l.y += 6
+		l.page.Line(margin, l.y, contentRight, l.y, 0.25, lightGray)
+		l.y += 12
+	}
+}
+
+func (l *layout) tableHeader() {
+	l.page.Rect(margin, l.y-13, contentRight-margin, 20, lightGray)
+	l.page.Text(margin+4, l.y, HelveticaBold, 10, Black, "Description")
+	for _, c := range invoiceColumns {
+		l.page.TextRight(c.right-4, l.y, HelveticaBold, 10, Black, c.title)
+	}
+	l.y += 20
+}
+
+// totals writes the invoice totals right aligned under the line table.
+func (l *layout) totals(invoice *store.Invoice) {
+	l.ensure(30 + float64(len(invoice.Tax_breakdown))*16)
+	l.y += 6
+
+	l.total("Subtotal excl. tax", invoice.Subtotal.String(), Helvetica)
+	for _, tax := range invoice.Tax_breakdown {
+		l.total(fmt.Sprintf("%s %s on %s", tax.Tax_name, TaxRate(tax.Tax_rate), tax.Net), tax.Tax.String(), Helvetica)
+	}
+
+	l.page.Line(300, l.y-10, contentRight, l.y-10, 0.5, gray)
+	l.y += 2
+	l.total("Total", invoice.Total.String(), HelveticaBold)
+}
+
+func (l *layout) total(label, value string, font Font) {
+	l.page.TextRight(455-4, l.y, font, 10, Black, label)
+	l.page.TextRight(contentRight-4, l.y, font, 10, Black, value)
+	l.y += 16
+}
+
+// finish adds the footer with page numbers to every page.
+func (l *layout) finish() []byte {
+	pages := l.doc.Pages()
+
+	for i, page := range pages {
+		page.Line(margin, footerTop+10, contentRight, footerTop+10, 0.5, lightGray)
+		page.Text(margin, footerTop+26, Helvetica, 8, gray, l.brand.Name+"  "+l.brand.Website)
+		page.TextRight(contentRight, footerTop+26, Helvetica, 8, gray, fmt.Sprintf("Page %d of %d", i+1, len(pages)))
+	}
+
+	return l.doc.Bytes()
+}
+
+// TaxRate formats a rate in basis points as a percentage, e.g. 550 is
+// "5.5%".
+func TaxRate(rate int) string {
+	return strconv.FormatFloat(float64(rate)/100, 'f', -1, 64) + "%"
+}
+
+// date keeps the day of a timestamp the database returned.
+func date(s string) string {
+	if len(s) >= 10 {
+		return s[:10]
+	}
+
+	return s
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func statusColor(status string) Color {
+	switch status {
+	case store.InvoiceStatusPaid, store.PaymentStatusComplete, store.BookingStatusConfirmed,
+		store.BookingStatusCheckedIn, store.BookingStatusCompleted:
+		return green
+	case store.InvoiceStatusUnpaid, store.PaymentStatusPending, store.InvoiceStatusPartiallyRefunded:
+		return amber
+	case store.InvoiceStatusOverdue, store.PaymentStatusFailed:
+		return red
+	default:
+		return gray
+	}
+}
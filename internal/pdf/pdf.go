@@ -0,0 +1,215 @@
+// Package pdf writes simple PDF documents: text in the standard Helvetica
+// fonts, lines and filled rectangles on A4 pages. It needs no font files or
+// external services, which is all invoices and confirmations call for.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A4 page size in points.
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+type Font int
+
+const (
+	Helvetica Font = iota
+	HelveticaBold
+)
+
+type Color struct {
+	R, G, B float64
+}
+
+// RGB builds a color from 0-255 components.
+func RGB(r, g, b uint8) Color {
+	return Color{R: float64(r) / 255, G: float64(g) / 255, B: float64(b) / 255}
+}
+
+var (
+	Black = RGB(0, 0, 0)
+	White = RGB(255, 255, 255)
+)
+
+type Document struct {
+	title string
+	pages []*Page
+}
+
+// Page is drawn with the origin in the top left corner and y growing down,
+// the PDF's bottom-left origin is handled when the page is written.
+type Page struct {
+	content bytes.Buffer
+}
+
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+func (d *Document) AddPage() *Page {
+	page := &Page{}
+	d.pages = append(d.pages, page)
+
+	return page
+}
+
+func (d *Document) Pages() []*Page {
+	return d.pages
+}
+
+// Text draws s with its baseline starting at x, y.
+func (p *Page) Text(x, y float64, font Font, size float64, color Color, s string) {
+	fmt.Fprintf(
+		&p.content,
+		"BT /F%d %s Tf %s rg %s %s Td (%s) Tj ET\n",
+		font+1, num(size), rgb(color), num(x), num(PageHeight-y), encode(s),
+	)
+}
+
+// TextRight draws s so that it ends at x.
+func (p *Page) TextRight(x, y float64, font Font, size float64, color Color, s string) {
+	p.Text(x-TextWidth(font, size, s), y, font, size, color, s)
+}
+
+func (p *Page) Line(x1, y1, x2, y2, width float64, color Color) {
+	fmt.Fprintf(
+		&p.content,
+		"%s RG %s w %s %s m %s %s l S\n",
+		rgb(color), num(width), num(x1), num(PageHeight-y1), num(x2), num(PageHeight-y2),
+	)
+}
+
+// Rect fills the rectangle whose top left corner is x, y.
+func (p *Page) Rect(x, y, w, h float64, fill Color) {
+	fmt.Fprintf(&p.content, "%s rg %s %s %s %s re f\n", rgb(fill), num(x), num(PageHeight-y-h), num(w), num(h))
+}
+
+// TextWidth returns the width of s in points.
+func TextWidth(font Font, size float64, s string) float64 {
+	widths := helveticaWidths
+	if font == HelveticaBold {
+		widths = helveticaBoldWidths
+	}
+
+	var units int
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			units += widths[r-32]
+		} else {
+			units += 556
+		}
+	}
+
+	return float64(units) * size / 1000
+}
+
+// Truncate shortens s with an ellipsis until it fits in width.
+func Truncate(font Font, size, width float64, s string) string {
+	if TextWidth(font, size, s) <= width {
+		return s
+	}
+
+	runes := []rune(s)
+	for len(runes) > 0 && TextWidth(font, size, string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+
+	return strings.TrimSpace(string(runes)) + "..."
+}
+
+// Bytes writes out the document.
+func (d *Document) Bytes() []byte {
+	var (
+		buf     bytes.Buffer
+		offsets []int
+	)
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// objects 1-5 are fixed, every page then takes a page and a content
+	// object
+	const firstPage = 6
+
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+i*2)
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title (%s) /Producer (transportService) >>", encode(d.title)))
+
+	for i, page := range d.pages {
+		object(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			num(PageWidth), num(PageHeight), firstPage+i*2+1,
+		))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.content.Len(), page.content.String()))
+	}
+
+	xref := buf.Len()
+
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes()
+}
+
+// encode turns s into the body of a PDF string in WinAnsiEncoding, runes it
+// can't represent become '?'.
+func encode(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		var c byte
+		switch {
+		case r == '€':
+			c = 0x80
+		case r < 0x100 && (r >= 0x20 && r < 0x7f || r >= 0xa0):
+			c = byte(r)
+		default:
+			c = '?'
+		}
+
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c >= 0x80:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// num formats v with at most four decimals, plenty for points and colors.
+func num(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 4, 64)
+	s = strings.TrimRight(s, "0")
+
+	return strings.TrimSuffix(s, ".")
+}
+
+func rgb(c Color) string {
+	return fmt.Sprintf("%s %s %s", num(c.R), num(c.G), num(c.B))
+}
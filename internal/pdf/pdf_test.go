@@ -0,0 +1,86 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBytesXref(t *testing.T) {
+	doc := New("Invoice (copy) \\ Ünïcödé €")
+	for i := 0; i < 2; i++ {
+		page := doc.AddPage()
+		page.Text(40, 60, HelveticaBold, 18, Black, "Booking (#42) – Zürich → Genève")
+		page.Rect(40, 80, 200, 20, RGB(230, 230, 230))
+		page.Line(40, 110, 240, 110, 0.5, Black)
+	}
+
+	out := doc.Bytes()
+
+	startxref := bytes.LastIndex(out, []byte("startxref\n"))
+	if startxref < 0 {
+		t.Fatal("no startxref")
+	}
+
+	rest := string(out[startxref+len("startxref\n"):])
+	xref, err := strconv.Atoi(rest[:strings.IndexByte(rest, '\n')])
+	if err != nil {
+		t.Fatalf("startxref offset: %v", err)
+	}
+
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+
+	lines := strings.Split(string(out[xref:]), "\n")
+
+	var first, count int
+	if _, err := fmt.Sscanf(lines[1], "%d %d", &first, &count); err != nil {
+		t.Fatalf("xref subsection %q: %v", lines[1], err)
+	}
+
+	// objects 1-5 and a page and a content object per page
+	if want := 5 + 2*2 + 1; count != want {
+		t.Fatalf("xref has %d entries, want %d", count, want)
+	}
+
+	for n := 1; n < count; n++ {
+		entry := lines[2+n]
+
+		offset, err := strconv.Atoi(entry[:10])
+		if err != nil {
+			t.Fatalf("xref entry %q: %v", entry, err)
+		}
+
+		if want := fmt.Sprintf("%d 0 obj\n", n); !bytes.HasPrefix(out[offset:], []byte(want)) {
+			t.Errorf("xref offset %d of object %d points at %q", offset, n, out[offset:min(offset+len(want), len(out))])
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"(paren)", `\(paren\)`},
+		{`back\slash`, `back\\slash`},
+		{"a)b(c", `a\)b\(c`},
+		{"12,50 €", `12,50 \200`},
+		{"Zürich", `Z\374rich`},
+		{"Straße", `Stra\337e`},
+		{"Ωmega", "?mega"},
+		{"東京", "??"},
+		{"→", "?"},
+		{"tab\there", "tab?here"},
+	}
+
+	for _, tt := range tests {
+		if got := encode(tt.in); got != tt.want {
+			t.Errorf("encode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package rrule expands the part of iCalendar (RFC 5545) recurrence rules
+// trip schedules need: daily, weekly and monthly rules with INTERVAL, BYDAY,
+// BYMONTHDAY, COUNT and UNTIL. Occurrences are whole days, times of day are
+// ignored.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+)
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Weekday is a BYDAY entry. N picks the Nth such weekday of the month, or
+// the Nth last when negative, and is 0 for every such weekday.
+type Weekday struct {
+	Day time.Weekday
+	N   int
+}
+
+// Rule is a parsed recurrence rule, Until is the zero time for rules that
+// don't end on a date.
+type Rule struct {
+	Freq       string
+	Interval   int
+	ByDay      []Weekday
+	ByMonthDay []int
+	Count      int
+	Until      time.Time
+}
+
+// Parse reads a rule like "FREQ=WEEKLY;BYDAY=SA;UNTIL=20251231", with or
+// without the "RRULE:" prefix.
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidRule)
+	}
+
+	rule := &Rule{Interval: 1}
+
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("%w: %q is not KEY=VALUE", ErrInvalidRule, part)
+		}
+
+		var err error
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(value)
+			if rule.Freq != Daily && rule.Freq != Weekly && rule.Freq != Monthly {
+				return nil, fmt.Errorf("%w: FREQ must be DAILY, WEEKLY or MONTHLY", ErrInvalidRule)
+			}
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(value)
+			if err != nil || rule.Interval < 1 {
+				return nil, fmt.Errorf("%w: INTERVAL must be a positive number", ErrInvalidRule)
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(value)
+			if err != nil || rule.Count < 1 {
+				return nil, fmt.Errorf("%w: COUNT must be a positive number", ErrInvalidRule)
+			}
+		case "UNTIL":
+			// a date, or a date-time of which only the day counts
+			day, _, _ := strings.Cut(value, "T")
+			rule.Until, err = time.Parse("20060102", day)
+			if err != nil {
+				return nil, fmt.Errorf("%w: UNTIL must be a date like 20251231", ErrInvalidRule)
+			}
+		case "BYDAY":
+			for _, v := range strings.Split(strings.ToUpper(value), ",") {
+				day, err := parseWeekday(v)
+				if err != nil {
+					return nil, err
+				}
+				rule.ByDay = append(rule.ByDay, day)
+			}
+		case "BYMONTHDAY":
+			for _, v := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(v)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("%w: BYMONTHDAY %q must be 1 to 31 or -1 to -31", ErrInvalidRule, v)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "WKST":
+			// weeks always start on monday
+			if strings.ToUpper(value) != "MO" {
+				return nil, fmt.Errorf("%w: only WKST=MO is supported", ErrInvalidRule)
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s is not supported", ErrInvalidRule, key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	}
+
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, fmt.Errorf("%w: COUNT and UNTIL can't be combined", ErrInvalidRule)
+	}
+
+	if rule.Freq != Monthly {
+		for _, day := range rule.ByDay {
+			if day.N != 0 {
+				return nil, fmt.Errorf("%w: numbered BYDAY needs FREQ=MONTHLY", ErrInvalidRule)
+			}
+		}
+	}
+
+	if rule.Freq == Weekly && len(rule.ByMonthDay) > 0 {
+		return nil, fmt.Errorf("%w: BYMONTHDAY can't be used with FREQ=WEEKLY", ErrInvalidRule)
+	}
+
+	return rule, nil
+}
+
+func parseWeekday(s string) (Weekday, error) {
+	if len(s) < 2 {
+		return Weekday{}, fmt.Errorf("%w: BYDAY %q", ErrInvalidRule, s)
+	}
+
+	day, ok := weekdays[s[len(s)-2:]]
+	if !ok {
+		return Weekday{}, fmt.Errorf("%w: BYDAY %q must end in MO, TU, WE, TH, FR, SA or SU", ErrInvalidRule, s)
+	}
+
+	weekday := Weekday{Day: day}
+
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return Weekday{}, fmt.Errorf("%w: BYDAY %q must be numbered 1 to 5 or -1 to -5", ErrInvalidRule, s)
+		}
+		weekday.N = n
+	}
+
+	return weekday, nil
+}
+
+// Between returns the days from and to, both included, on which the rule
+// starting on start recurs, in order. start is the first day of the
+// schedule, it only recurs on it when it matches the rule.
+func (r *Rule) Between(start, from, to time.Time) []time.Time {
+	start, from, to = day(start), day(from), day(to)
+
+	if !r.Until.IsZero() && r.Until.Before(to) {
+		to = day(r.Until)
+	}
+
+	var (
+		days  []time.Time
+		count int
+	)
+
+	// COUNT counts from the start, so every day from there is walked
+	for d := start; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if !r.matches(start, d) {
+			continue
+		}
+
+		count++
+		if r.Count > 0 && count > r.Count {
+			break
+		}
+
+		if !d.Before(from) {
+			days = append(days, d)
+		}
+	}
+
+	return days
+}
+
+func (r *Rule) matches(start, d time.Time) bool {
+	switch r.Freq {
+	case Daily:
+		if daysBetween(start, d)%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByDay) > 0 && !r.onWeekday(d) {
+			return false
+		}
+		return len(r.ByMonthDay) == 0 || r.onMonthDay(d)
+	case Weekly:
+		if daysBetween(monday(start), monday(d))/7%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByDay) == 0 {
+			return d.Weekday() == start.Weekday()
+		}
+		return r.onWeekday(d)
+	case Monthly:
+		months := (d.Year()-start.Year())*12 + int(d.Month()-start.Month())
+		if months%r.Interval != 0 {
+			return false
+		}
+		switch {
+		case len(r.ByMonthDay) > 0 && len(r.ByDay) > 0:
+			return r.onMonthDay(d) && r.onWeekday(d)
+		case len(r.ByMonthDay) > 0:
+			return r.onMonthDay(d)
+		case len(r.ByDay) > 0:
+			return r.onWeekday(d)
+		default:
+			return d.Day() == start.Day()
+		}
+	}
+
+	return false
+}
+
+// onWeekday reports whether d is one of the BYDAY days, numbered ones
+// counted within d's month.
+func (r *Rule) onWeekday(d time.Time) bool {
+	nth := (d.Day()-1)/7 + 1
+	nthLast := -((daysInMonth(d)-d.Day())/7 + 1)
+
+	return slices.ContainsFunc(r.ByDay, func(w Weekday) bool {
+		return w.Day == d.Weekday() && (w.N == 0 || w.N == nth || w.N == nthLast)
+	})
+}
+
+func (r *Rule) onMonthDay(d time.Time) bool {
+	last := daysInMonth(d)
+
+	return slices.ContainsFunc(r.ByMonthDay, func(n int) bool {
+		if n < 0 {
+			n = last + n + 1
+		}
+		return n == d.Day()
+	})
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func daysBetween(a, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
+
+// monday returns the monday of d's week.
+func monday(d time.Time) time.Time {
+	return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+}
+
+func daysInMonth(d time.Time) int {
+	return time.Date(d.Year(), d.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
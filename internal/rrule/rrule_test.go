@@ -0,0 +1,209 @@
+package rrule
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  string
+		start string
+		from  string
+		to    string
+		want  []string
+	}{
+		{
+			name:  "weekly interval 2 on monday and friday",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR",
+			start: "2026-01-05",
+			from:  "2026-01-01",
+			to:    "2026-02-06",
+			want:  []string{"2026-01-05", "2026-01-09", "2026-01-19", "2026-01-23", "2026-02-02", "2026-02-06"},
+		},
+		{
+			name:  "weekly interval 2 on the start's weekday",
+			rule:  "FREQ=WEEKLY;INTERVAL=2",
+			start: "2026-01-07",
+			from:  "2026-01-07",
+			to:    "2026-02-10",
+			want:  []string{"2026-01-07", "2026-01-21", "2026-02-04"},
+		},
+		{
+			name:  "weekly interval 2 starting on a sunday counts weeks from monday",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,SU",
+			start: "2026-01-11",
+			from:  "2026-01-01",
+			to:    "2026-01-31",
+			want:  []string{"2026-01-11", "2026-01-19", "2026-01-25"},
+		},
+		{
+			name:  "start that doesn't match the rule",
+			rule:  "FREQ=WEEKLY;BYDAY=MO",
+			start: "2026-01-01",
+			from:  "2026-01-01",
+			to:    "2026-01-14",
+			want:  []string{"2026-01-05", "2026-01-12"},
+		},
+		{
+			name:  "last friday of the month",
+			rule:  "FREQ=MONTHLY;BYDAY=-1FR",
+			start: "2026-01-01",
+			from:  "2026-01-01",
+			to:    "2026-04-30",
+			want:  []string{"2026-01-30", "2026-02-27", "2026-03-27", "2026-04-24"},
+		},
+		{
+			name:  "first monday every other month",
+			rule:  "FREQ=MONTHLY;INTERVAL=2;BYDAY=1MO",
+			start: "2026-01-01",
+			from:  "2026-01-01",
+			to:    "2026-05-31",
+			want:  []string{"2026-01-05", "2026-03-02", "2026-05-04"},
+		},
+		{
+			name:  "31st skips short months",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=31",
+			start: "2026-01-01",
+			from:  "2026-01-01",
+			to:    "2026-06-30",
+			want:  []string{"2026-01-31", "2026-03-31", "2026-05-31"},
+		},
+		{
+			name:  "monthly on the start's day skips short months",
+			rule:  "FREQ=MONTHLY",
+			start: "2026-01-31",
+			from:  "2026-01-01",
+			to:    "2026-06-30",
+			want:  []string{"2026-01-31", "2026-03-31", "2026-05-31"},
+		},
+		{
+			name:  "last day of the month",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-1",
+			start: "2028-01-01",
+			from:  "2028-01-01",
+			to:    "2028-04-30",
+			want:  []string{"2028-01-31", "2028-02-29", "2028-03-31", "2028-04-30"},
+		},
+		{
+			name:  "daily count counted from the start",
+			rule:  "FREQ=DAILY;COUNT=5",
+			start: "2026-01-01",
+			from:  "2026-01-03",
+			to:    "2026-01-31",
+			want:  []string{"2026-01-03", "2026-01-04", "2026-01-05"},
+		},
+		{
+			name:  "weekly count counted from the start",
+			rule:  "FREQ=WEEKLY;BYDAY=TU,TH;COUNT=3",
+			start: "2026-01-01",
+			from:  "2026-01-05",
+			to:    "2026-01-31",
+			want:  []string{"2026-01-06", "2026-01-08"},
+		},
+		{
+			name:  "count used up before from",
+			rule:  "FREQ=DAILY;COUNT=2",
+			start: "2026-01-01",
+			from:  "2026-01-03",
+			to:    "2026-01-31",
+			want:  nil,
+		},
+		{
+			name:  "until is included",
+			rule:  "FREQ=DAILY;INTERVAL=3;UNTIL=20260110",
+			start: "2026-01-01",
+			from:  "2026-01-01",
+			to:    "2026-01-31",
+			want:  []string{"2026-01-01", "2026-01-04", "2026-01-07", "2026-01-10"},
+		},
+		{
+			name:  "until as a date-time",
+			rule:  "FREQ=DAILY;UNTIL=20260103T235959Z",
+			start: "2026-01-01",
+			from:  "2026-01-01",
+			to:    "2026-01-31",
+			want:  []string{"2026-01-01", "2026-01-02", "2026-01-03"},
+		},
+		{
+			name:  "daily on weekdays",
+			rule:  "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR",
+			start: "2026-01-01",
+			from:  "2026-01-01",
+			to:    "2026-01-06",
+			want:  []string{"2026-01-01", "2026-01-02", "2026-01-05", "2026-01-06"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.rule, err)
+			}
+
+			var got []string
+			for _, d := range rule.Between(date(tt.start), date(tt.from), date(tt.to)) {
+				got = append(got, d.Format("2006-01-02"))
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Between() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	rule, err := Parse("RRULE:freq=monthly;byday=sa,-1su;interval=2")
+	if err != nil {
+		t.Fatalf("Parse(): %v", err)
+	}
+
+	want := []Weekday{{Day: time.Saturday}, {Day: time.Sunday, N: -1}}
+	if rule.Freq != Monthly || rule.Interval != 2 || !slices.Equal(rule.ByDay, want) {
+		t.Errorf("Parse() = %+v", rule)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"RRULE:",
+		"INTERVAL=2",
+		"FREQ=YEARLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;INTERVAL=x",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20260101",
+		"FREQ=DAILY;UNTIL=2026-01-01",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=MONTHLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=DAILY;WKST=SU",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=DAILY;INTERVAL",
+	}
+
+	for _, s := range tests {
+		t.Run(s, func(t *testing.T) {
+			if _, err := Parse(s); !errors.Is(err, ErrInvalidRule) {
+				t.Errorf("Parse(%q) error = %v, want ErrInvalidRule", s, err)
+			}
+		})
+	}
+}
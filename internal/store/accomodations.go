@@ -6,12 +6,13 @@ import (
 )
 
 type Accomodation struct {
-	ID              int64   `json:"id"`
-	Trip_id         int64   `json:"trip_id"`
-	Name            string  `json:"name"`
-	Description     string  `json:"description"`
-	Price_per_night float64 `json:"price_per_night"`
-	Created_at      string  `json:"created_at"`
+	ID              int64  `json:"id"`
+	Trip_id         int64  `json:"trip_id"`
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	Price_per_night Money  `json:"price_per_night"`
+	Location        *Point `json:"location"`
+	Created_at      string `json:"created_at"`
 }
 
 type AccomodationStore struct {
@@ -20,8 +21,8 @@ type AccomodationStore struct {
 
 func (s *AccomodationStore) Create(ctx context.Context, accomodation *Accomodation) error {
 	query := `
-	INSERT INTO accomodation (name, trip_id, description, price_per_night) 
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO accomodation (name, trip_id, description, price_per_night, currency, lat, lng)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	RETURNING id, created_at
 	`
 
@@ -32,8 +33,12 @@ func (s *AccomodationStore) Create(ctx context.Context, accomodation *Accomodati
 		ctx,
 		query,
 		accomodation.Name,
+		accomodation.Trip_id,
 		accomodation.Description,
-		accomodation.Price_per_night,
+		accomodation.Price_per_night.Amount,
+		accomodation.Price_per_night.Currency,
+		accomodation.Location.lat(),
+		accomodation.Location.lng(),
 	).Scan(
 		&accomodation.ID,
 		&accomodation.Created_at,
@@ -46,7 +51,7 @@ func (s *AccomodationStore) Create(ctx context.Context, accomodation *Accomodati
 }
 
 func (s *AccomodationStore) GetByID(ctx context.Context, accomodation_id int64) (*Accomodation, error) {
-	query := `SELECET id, trip_id, name, description, price_per_night, created_at 
+	query := `SELECT id, trip_id, name, description, price_per_night, currency, lat, lng, created_at
 	FROM accomodation 
 	WHERE id = $1`
 
@@ -54,6 +59,7 @@ func (s *AccomodationStore) GetByID(ctx context.Context, accomodation_id int64)
 	defer cancel()
 
 	accomodation := &Accomodation{}
+	var point nullPoint
 
 	err := s.db.QueryRowContext(
 		ctx, query, accomodation_id,
@@ -62,7 +68,10 @@ func (s *AccomodationStore) GetByID(ctx context.Context, accomodation_id int64)
 		&accomodation.Trip_id,
 		&accomodation.Name,
 		&accomodation.Description,
-		&accomodation.Price_per_night,
+		&accomodation.Price_per_night.Amount,
+		&accomodation.Price_per_night.Currency,
+		&point.Lat,
+		&point.Lng,
 		&accomodation.Created_at,
 	)
 	if err != nil {
@@ -72,11 +81,13 @@ func (s *AccomodationStore) GetByID(ctx context.Context, accomodation_id int64)
 		return nil, err
 	}
 
+	accomodation.Location = point.point()
+
 	return accomodation, nil
 }
 
 func (s *AccomodationStore) GetByTripID(ctx context.Context, trip_id int64) ([]Accomodation, error) {
-	query := `SELECET id, trip_id, name, description, price_per_night, created_at 
+	query := `SELECT id, trip_id, name, description, price_per_night, currency, lat, lng, created_at
 	FROM accomodation 
 	WHERE trip_id = $1`
 
@@ -92,17 +103,24 @@ func (s *AccomodationStore) GetByTripID(ctx context.Context, trip_id int64) ([]A
 	var accomodations []Accomodation
 
 	for rows.Next() {
-		var accomodation Accomodation
+		var (
+			accomodation Accomodation
+			point        nullPoint
+		)
 		if err := rows.Scan(
 			&accomodation.ID,
 			&accomodation.Trip_id,
 			&accomodation.Name,
 			&accomodation.Description,
-			&accomodation.Price_per_night,
+			&accomodation.Price_per_night.Amount,
+			&accomodation.Price_per_night.Currency,
+			&point.Lat,
+			&point.Lng,
 			&accomodation.Created_at,
 		); err != nil {
 			return nil, err
 		}
+		accomodation.Location = point.point()
 		accomodations = append(accomodations, accomodation)
 	}
 
@@ -110,15 +128,16 @@ func (s *AccomodationStore) GetByTripID(ctx context.Context, trip_id int64) ([]A
 }
 
 func (s *AccomodationStore) UpdateByID(ctx context.Context, accomodation *Accomodation) error {
-	query := `UPDATE accomodation SET name = $1, description = $2, price_per_night = $3
-	WHERE id = $4
+	query := `UPDATE accomodation SET name = $1, description = $2, price_per_night = $3, currency = $4, lat = $5, lng = $6
+	WHERE id = $7
 	RETURNING id`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
 	err := s.db.QueryRowContext(
-		ctx, query, accomodation.Name, accomodation.Description, accomodation.Price_per_night, accomodation.ID,
+		ctx, query, accomodation.Name, accomodation.Description, accomodation.Price_per_night.Amount, accomodation.Price_per_night.Currency,
+		accomodation.Location.lat(), accomodation.Location.lng(), accomodation.ID,
 	).Scan(&accomodation.ID)
 
 	if err != nil {
@@ -6,12 +6,13 @@ import (
 )
 
 type Activity struct {
-	ID          int64   `json:"id"`
-	Trip_id     int64   `json:"trip_id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	Created_at  string  `json:"created_at"`
+	ID          int64  `json:"id"`
+	Trip_id     int64  `json:"trip_id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       Money  `json:"price"`
+	Tax_exempt  bool   `json:"tax_exempt"`
+	Created_at  string `json:"created_at"`
 }
 
 type ActivityStore struct {
@@ -19,14 +20,14 @@ type ActivityStore struct {
 }
 
 func (s *ActivityStore) Create(ctx context.Context, activity *Activity) error {
-	query := `INSERT INTO activity (trip_id, name, description, price)
-	VALUES ($1, $2, $3)
+	query := `INSERT INTO activity (trip_id, name, description, price, currency, tax_exempt)
+	VALUES ($1, $2, $3, $4, $5, $6)
 	RETURNING id, created_at`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query, activity.Trip_id, activity.Name, activity.Description, activity.Price).Scan(
+	err := s.db.QueryRowContext(ctx, query, activity.Trip_id, activity.Name, activity.Description, activity.Price.Amount, activity.Price.Currency, activity.Tax_exempt).Scan(
 		&activity.ID,
 		&activity.Created_at,
 	)
@@ -38,7 +39,7 @@ func (s *ActivityStore) Create(ctx context.Context, activity *Activity) error {
 }
 
 func (s *ActivityStore) GetById(ctx context.Context, id int64) (*Activity, error) {
-	query := `SELECT id, trip_id, name, description, price, created_at
+	query := `SELECT id, trip_id, name, description, price, currency, tax_exempt, created_at
 	FROM activity 
 	WHERE id = $1`
 
@@ -51,7 +52,9 @@ func (s *ActivityStore) GetById(ctx context.Context, id int64) (*Activity, error
 		&activty.Trip_id,
 		&activty.Name,
 		&activty.Description,
-		&activty.Price,
+		&activty.Price.Amount,
+		&activty.Price.Currency,
+		&activty.Tax_exempt,
 		&activty.Created_at,
 	)
 	if err != nil {
@@ -65,7 +68,7 @@ func (s *ActivityStore) GetById(ctx context.Context, id int64) (*Activity, error
 }
 
 func (s *ActivityStore) GetByTripId(ctx context.Context, tripId int64) ([]Activity, error) {
-	query := `SELECT id, trip_id, name, description, price, created_at
+	query := `SELECT id, trip_id, name, description, price, currency, tax_exempt, created_at
 	FROM activity
 	WHERE trip_id = $1`
 
@@ -86,12 +89,14 @@ func (s *ActivityStore) GetByTripId(ctx context.Context, tripId int64) ([]Activi
 	for rows.Next() {
 		var activity Activity
 		if err := rows.Scan(
-			activity.ID,
-			activity.Trip_id,
-			activity.Name,
-			activity.Description,
-			activity.Price,
-			activity.Created_at,
+			&activity.ID,
+			&activity.Trip_id,
+			&activity.Name,
+			&activity.Description,
+			&activity.Price.Amount,
+			&activity.Price.Currency,
+			&activity.Tax_exempt,
+			&activity.Created_at,
 		); err != nil {
 			return nil, err
 		}
@@ -103,14 +108,14 @@ func (s *ActivityStore) GetByTripId(ctx context.Context, tripId int64) ([]Activi
 
 func (s *ActivityStore) UpdateById(ctx context.Context, activity *Activity) error {
 	query := `UPDATE activity
-	SET name = $1, description = $2, price = $3
-	WHERE id = $4
+	SET name = $1, description = $2, price = $3, currency = $4, tax_exempt = $5
+	WHERE id = $6
 	RETURNING id`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query, activity.Name, activity.Description, activity.Price, activity.ID).Scan(&activity.ID)
+	err := s.db.QueryRowContext(ctx, query, activity.Name, activity.Description, activity.Price.Amount, activity.Price.Currency, activity.Tax_exempt, activity.ID).Scan(&activity.ID)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidTransition = errors.New("invalid booking status transition")
+
+// bookingTransitions is the booking state machine, it lists the statuses a
+// booking may move to from each status. Completed and cancelled are final.
+var bookingTransitions = map[string][]string{
+	BookingStatusPending:   {BookingStatusConfirmed, BookingStatusCancelled},
+	BookingStatusConfirmed: {BookingStatusCheckedIn, BookingStatusCompleted, BookingStatusCancelled},
+	BookingStatusCheckedIn: {BookingStatusCompleted},
+	BookingStatusCompleted: {},
+	BookingStatusCancelled: {},
+}
+
+func CanTransitionBooking(from, to string) bool {
+	for _, next := range bookingTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BookingStatusChange is one recorded transition of a booking, Changed_by is
+// nil when the system made the change.
+type BookingStatusChange struct {
+	ID          int64  `json:"id"`
+	Booking_id  int64  `json:"booking_id"`
+	From_status string `json:"from_status"`
+	To_status   string `json:"to_status"`
+	Changed_by  *int64 `json:"changed_by"`
+	Reason      string `json:"reason"`
+	Created_at  string `json:"created_at"`
+}
+
+// Transition moves a booking to change.To_status if the state machine allows
+// it, adjusts the trip's seats and records the change in the history.
+func (s *BookingStore) Transition(ctx context.Context, change *BookingStatusChange) (*Booking, error) {
+	var booking *Booking
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		b, err := transitionBooking(ctx, tx, change)
+		if err != nil {
+			return err
+		}
+		booking = b
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return booking, nil
+}
+
+func (s *BookingStore) GetHistory(ctx context.Context, bookingID int64) ([]BookingStatusChange, error) {
+	query := `SELECT id, booking_id, from_status, to_status, changed_by, reason, created_at
+	FROM booking_status_history
+	WHERE booking_id = $1
+	ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []BookingStatusChange
+
+	for rows.Next() {
+		var change BookingStatusChange
+		if err := rows.Scan(
+			&change.ID,
+			&change.Booking_id,
+			&change.From_status,
+			&change.To_status,
+			&change.Changed_by,
+			&change.Reason,
+			&change.Created_at,
+		); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+
+	return history, nil
+}
+
+// transitionBooking is the single place a booking's status is changed. It
+// locks the booking, validates the move against the state machine, releases
+// the seat when the booking stops occupying one and writes the history row.
+func transitionBooking(ctx context.Context, tx *sql.Tx, change *BookingStatusChange) (*Booking, error) {
+	var expired bool
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT status, COALESCE(expires_at < NOW(), FALSE) FROM booking WHERE id = $1 FOR UPDATE`,
+		change.Booking_id,
+	).Scan(&change.From_status, &expired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if !CanTransitionBooking(change.From_status, change.To_status) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, change.From_status, change.To_status)
+	}
+
+	if change.From_status == BookingStatusPending && expired && change.To_status == BookingStatusConfirmed {
+		return nil, ErrHoldExpired
+	}
+
+	booking := &Booking{}
+
+	// only pending bookings are held, any other status clears the hold
+	query := `UPDATE booking SET status = $1, expires_at = NULL
+	WHERE id = $2
+	RETURNING id, user_id, trip_id, status, quantity, from_stop_id, to_stop_id, expires_at, created_at`
+
+	err = tx.QueryRowContext(ctx, query, change.To_status, change.Booking_id).Scan(
+		&booking.ID,
+		&booking.User_id,
+		&booking.Trip_id,
+		&booking.Status,
+		&booking.Quantity,
+		&booking.From_stop_id,
+		&booking.To_stop_id,
+		&booking.Expires_at,
+		&booking.Created_at,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if holdsSeat(change.From_status) && !holdsSeat(change.To_status) {
+		if err := releaseSeats(ctx, tx, booking.Trip_id, booking.Quantity, booking.From_stop_id, booking.To_stop_id); err != nil {
+			return nil, err
+		}
+	}
+
+	query = `INSERT INTO booking_status_history (booking_id, from_status, to_status, changed_by, reason)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	err = tx.QueryRowContext(
+		ctx, query, change.Booking_id, change.From_status, change.To_status, change.Changed_by, change.Reason,
+	).Scan(&change.ID, &change.Created_at)
+	if err != nil {
+		return nil, err
+	}
+
+	return booking, nil
+}
@@ -3,40 +3,59 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+const (
+	BookingStatusPending   = "pending"
+	BookingStatusConfirmed = "confirmed"
+	BookingStatusCheckedIn = "checked_in"
+	BookingStatusCompleted = "completed"
+	BookingStatusCancelled = "cancelled"
+)
+
+var (
+	ErrTripFull      = errors.New("trip has no available seats")
+	ErrTripCancelled = errors.New("trip is cancelled")
+	ErrHoldExpired   = errors.New("booking hold has expired")
 )
 
 type Booking struct {
-	ID         int64  `json:"id"`
-	User_id    int64  `json:"user_id"`
-	Trip_id    int64  `json:"trip_id"`
-	Status     string `json:"status"`
-	Created_at string `json:"created_at"`
+	ID       int64  `json:"id"`
+	User_id  int64  `json:"user_id"`
+	Trip_id  int64  `json:"trip_id"`
+	Status   string `json:"status"`
+	Quantity int    `json:"quantity"`
+	// From_stop_id and To_stop_id are where the booking boards and alights,
+	// nil for the first and the last stop
+	From_stop_id *int64      `json:"from_stop_id"`
+	To_stop_id   *int64      `json:"to_stop_id"`
+	Passengers   []Passenger `json:"passengers,omitempty"`
+	Expires_at   *string     `json:"expires_at"`
+	Created_at   string      `json:"created_at"`
 }
 
 type BookingStore struct {
-	db *sql.DB
+	db  *sql.DB
+	cfg Config
 }
 
+// Create inserts the booking with its passengers and takes a seat per
+// passenger from the trip in the same transaction, the trip row is locked so
+// concurrent bookings can't oversell.
 func (s *BookingStore) Create(ctx context.Context, booking *Booking) error {
-	query := `INSERT INTO booking (user_id, trip_id, status)
-	VALUES ($1, $2, $3)
-	RETURNING id, created_at`
-
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
-
-	err := s.db.QueryRowContext(
-		ctx, query, booking.User_id, booking.Trip_id, booking.Status,
-	).Scan(&booking.ID, &booking.Created_at)
-	if err != nil {
-		return err
-	}
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
 
-	return nil
+		return createBooking(ctx, tx, s.cfg, booking)
+	})
 }
 
 func (s *BookingStore) GetByID(ctx context.Context, bookingID int64) (*Booking, error) {
-	query := `SELECT id, user_id, trip_id, status, created_at FROM booking WHERE id = $1`
+	query := `SELECT id, user_id, trip_id, status, quantity, from_stop_id, to_stop_id, expires_at, created_at FROM booking WHERE id = $1`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -48,6 +67,10 @@ func (s *BookingStore) GetByID(ctx context.Context, bookingID int64) (*Booking,
 		&booking.User_id,
 		&booking.Trip_id,
 		&booking.Status,
+		&booking.Quantity,
+		&booking.From_stop_id,
+		&booking.To_stop_id,
+		&booking.Expires_at,
 		&booking.Created_at,
 	)
 	if err != nil {
@@ -57,11 +80,16 @@ func (s *BookingStore) GetByID(ctx context.Context, bookingID int64) (*Booking,
 		return nil, err
 	}
 
+	booking.Passengers, err = getPassengersByBookingID(ctx, s.db, booking.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	return booking, nil
 }
 
 func (s *BookingStore) GetByTripID(ctx context.Context, tripID int64) ([]Booking, error) {
-	query := `SELECT id, user_id, trip_id, status, created_at FROM booking WHERE trip_id = $1`
+	query := `SELECT id, user_id, trip_id, status, quantity, from_stop_id, to_stop_id, expires_at, created_at FROM booking WHERE trip_id = $1`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -79,7 +107,7 @@ func (s *BookingStore) GetByTripID(ctx context.Context, tripID int64) ([]Booking
 
 	for rows.Next() {
 		var booking Booking
-		if err := rows.Scan(&booking.ID, &booking.User_id, &booking.Trip_id, &booking.Status, &booking.Created_at); err != nil {
+		if err := rows.Scan(&booking.ID, &booking.User_id, &booking.Trip_id, &booking.Status, &booking.Quantity, &booking.From_stop_id, &booking.To_stop_id, &booking.Expires_at, &booking.Created_at); err != nil {
 			return nil, err
 		}
 		bookings = append(bookings, booking)
@@ -89,7 +117,7 @@ func (s *BookingStore) GetByTripID(ctx context.Context, tripID int64) ([]Booking
 }
 
 func (s *BookingStore) GetByUserID(ctx context.Context, userID int64) ([]Booking, error) {
-	query := `SELECT id, user_id, trip_id, status, created_at FROM booking WHERE user_id = $1`
+	query := `SELECT id, user_id, trip_id, status, quantity, from_stop_id, to_stop_id, expires_at, created_at FROM booking WHERE user_id = $1`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -107,7 +135,7 @@ func (s *BookingStore) GetByUserID(ctx context.Context, userID int64) ([]Booking
 
 	for rows.Next() {
 		var booking Booking
-		if err := rows.Scan(&booking.ID, &booking.User_id, &booking.Trip_id, &booking.Status, &booking.Created_at); err != nil {
+		if err := rows.Scan(&booking.ID, &booking.User_id, &booking.Trip_id, &booking.Status, &booking.Quantity, &booking.From_stop_id, &booking.To_stop_id, &booking.Expires_at, &booking.Created_at); err != nil {
 			return nil, err
 		}
 		bookings = append(bookings, booking)
@@ -116,17 +144,166 @@ func (s *BookingStore) GetByUserID(ctx context.Context, userID int64) ([]Booking
 	return bookings, nil
 }
 
-func (s *BookingStore) UpdateByID(ctx context.Context, booking *Booking) error {
-	query := `UPDATE booking SET status = $1 WHERE id = $2 
-	RETURNING id`
+// ExpireHolds cancels pending bookings whose hold has run out and returns
+// their seats to the trips, it returns the bookings it cancelled.
+func (s *BookingStore) ExpireHolds(ctx context.Context) ([]Booking, error) {
+	var expired []Booking
 
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// SKIP LOCKED leaves bookings that are being confirmed right now to
+		// that request, they are picked up on the next sweep if still pending
+		query := `
+		SELECT id FROM booking
+		WHERE status = 'pending' AND expires_at < NOW()
+		ORDER BY expires_at
+		LIMIT 100
+		FOR UPDATE SKIP LOCKED
+		`
 
-	err := s.db.QueryRowContext(ctx, query, booking.Status, booking.ID).Scan(&booking.ID)
+		rows, err := tx.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var ids []int64
+
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			booking, err := transitionBooking(ctx, tx, &BookingStatusChange{
+				Booking_id: id,
+				To_status:  BookingStatusCancelled,
+				Reason:     "hold expired",
+			})
+			if err != nil {
+				return err
+			}
+			expired = append(expired, *booking)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return expired, nil
+}
+
+// createBooking reserves the seats for the booking and inserts it with its
+// passengers, quantity always follows the number of passengers.
+func createBooking(ctx context.Context, tx *sql.Tx, cfg Config, booking *Booking) error {
+	booking.Quantity = len(booking.Passengers)
+
+	if holdsSeat(booking.Status) {
+		if err := reserveSeats(ctx, tx, booking.Trip_id, booking.Quantity, booking.From_stop_id, booking.To_stop_id); err != nil {
+			return err
+		}
+	}
+
+	query := `INSERT INTO booking (user_id, trip_id, status, quantity, from_stop_id, to_stop_id, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $3 = 'pending' THEN NOW() + make_interval(secs => $7) END)
+	RETURNING id, expires_at, created_at`
+
+	err := tx.QueryRowContext(
+		ctx, query, booking.User_id, booking.Trip_id, booking.Status, booking.Quantity, booking.From_stop_id, booking.To_stop_id,
+		cfg.BookingHoldDuration.Seconds(),
+	).Scan(&booking.ID, &booking.Expires_at, &booking.Created_at)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrConflict
+		}
 		return err
 	}
 
+	for i := range booking.Passengers {
+		booking.Passengers[i].Booking_id = booking.ID
+		if err := createPassenger(ctx, tx, &booking.Passengers[i]); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// holdsSeat reports whether a booking in the given status occupies a seat.
+func holdsSeat(status string) bool {
+	return status != BookingStatusCancelled
+}
+
+// reserveSeats locks the trip row and takes n seats from it between the
+// stops from and to, nil meaning the first and the last. It fails with
+// ErrTripFull when a leg in between has not enough left, ErrTripCancelled
+// when the trip doesn't depart and ErrInvalidSegment when the stops don't
+// make a ride on the trip.
+func reserveSeats(ctx context.Context, tx *sql.Tx, tripID int64, n int, from, to *int64) error {
+	var (
+		available int
+		cancelled bool
+	)
+
+	err := tx.QueryRowContext(
+		ctx, `SELECT available_seats, cancelled_at IS NOT NULL FROM trip WHERE id = $1 FOR UPDATE`, tripID,
+	).Scan(&available, &cancelled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if cancelled {
+		return ErrTripCancelled
+	}
+
+	legs, err := bookingLegs(ctx, tx, tripID, from, to)
+	if err != nil {
+		return err
+	}
+
+	if legs != nil {
+		return reserveLegs(ctx, tx, tripID, legs, n)
+	}
+
+	if available < n {
+		return ErrTripFull
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE trip SET available_seats = available_seats - $1 WHERE id = $2`, n, tripID)
+
+	return err
+}
+
+func releaseSeats(ctx context.Context, tx *sql.Tx, tripID int64, n int, from, to *int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM trip WHERE id = $1 FOR UPDATE`, tripID)
+	if err != nil {
+		return err
+	}
+
+	legs, err := bookingLegs(ctx, tx, tripID, from, to)
+	if err != nil {
+		return err
+	}
+
+	if legs != nil {
+		return adjustLegs(ctx, tx, tripID, legs, n)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE trip SET available_seats = available_seats + $1 WHERE id = $2`, n, tripID)
+
+	return err
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// CancellationPolicy decides how much of a booking is refunded when it is
+// cancelled. Trips without a policy are not refundable.
+type CancellationPolicy struct {
+	ID         int64                    `json:"id"`
+	Name       string                   `json:"name"`
+	Rules      []CancellationPolicyRule `json:"rules"`
+	Created_at string                   `json:"created_at"`
+}
+
+// CancellationPolicyRule refunds Refund_percent of the payment when the
+// booking is cancelled at least Min_days_before days before the trip starts.
+type CancellationPolicyRule struct {
+	ID              int64 `json:"id"`
+	Policy_id       int64 `json:"policy_id"`
+	Min_days_before int   `json:"min_days_before"`
+	Refund_percent  int   `json:"refund_percent"`
+}
+
+// RefundPercent returns the share of the payment that is refunded for a
+// cancellation daysBefore days ahead of the trip, the applicable rule with the
+// highest Min_days_before wins and nothing is refunded when none applies.
+func (p *CancellationPolicy) RefundPercent(daysBefore int) int {
+	best := -1
+	percent := 0
+
+	for _, rule := range p.Rules {
+		if daysBefore >= rule.Min_days_before && rule.Min_days_before > best {
+			best = rule.Min_days_before
+			percent = rule.Refund_percent
+		}
+	}
+
+	return percent
+}
+
+type CancellationPolicyStore struct {
+	db *sql.DB
+}
+
+func (s *CancellationPolicyStore) Create(ctx context.Context, policy *CancellationPolicy) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(
+			ctx, `INSERT INTO cancellation_policy (name) VALUES ($1) RETURNING id, created_at`, policy.Name,
+		).Scan(&policy.ID, &policy.Created_at)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				return ErrConflict
+			}
+			return err
+		}
+
+		query := `INSERT INTO cancellation_policy_rule (policy_id, min_days_before, refund_percent)
+		VALUES ($1, $2, $3)
+		RETURNING id`
+
+		for i := range policy.Rules {
+			rule := &policy.Rules[i]
+			rule.Policy_id = policy.ID
+
+			err := tx.QueryRowContext(ctx, query, rule.Policy_id, rule.Min_days_before, rule.Refund_percent).Scan(&rule.ID)
+			if err != nil {
+				var pqErr *pq.Error
+				if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+					return ErrConflict
+				}
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *CancellationPolicyStore) GetByID(ctx context.Context, policyID int64) (*CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	policy := &CancellationPolicy{}
+
+	err := s.db.QueryRowContext(
+		ctx, `SELECT id, name, created_at FROM cancellation_policy WHERE id = $1`, policyID,
+	).Scan(&policy.ID, &policy.Name, &policy.Created_at)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, policyRulesQuery, policy.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policy.Rules, err = scanPolicyRules(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (s *CancellationPolicyStore) GetAll(ctx context.Context) ([]CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, created_at FROM cancellation_policy ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []CancellationPolicy
+
+	for rows.Next() {
+		var policy CancellationPolicy
+		if err := rows.Scan(&policy.ID, &policy.Name, &policy.Created_at); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range policies {
+		rows, err := s.db.QueryContext(ctx, policyRulesQuery, policies[i].ID)
+		if err != nil {
+			return nil, err
+		}
+
+		policies[i].Rules, err = scanPolicyRules(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return policies, nil
+}
+
+const policyRulesQuery = `SELECT id, policy_id, min_days_before, refund_percent
+FROM cancellation_policy_rule
+WHERE policy_id = $1
+ORDER BY min_days_before DESC`
+
+func scanPolicyRules(rows *sql.Rows) ([]CancellationPolicyRule, error) {
+	var rules []CancellationPolicyRule
+
+	for rows.Next() {
+		var rule CancellationPolicyRule
+		if err := rows.Scan(&rule.ID, &rule.Policy_id, &rule.Min_days_before, &rule.Refund_percent); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
@@ -0,0 +1,466 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var ErrCreditExceedsInvoice = errors.New("credit exceeds what is left to credit on the invoice")
+
+// CreditNote reduces what an invoice bills. It is spread over the invoice's
+// lines in proportion to their totals, each part keeping its line's tax.
+type CreditNote struct {
+	ID                 int64            `json:"id"`
+	Credit_note_number string           `json:"credit_note_number"`
+	Invoice_id         int64            `json:"invoice_id"`
+	Invoice_number     string           `json:"invoice_number"`
+	User_id            int64            `json:"user_id"`
+	Refund_id          *int64           `json:"refund_id"`
+	Reason             string           `json:"reason"`
+	Subtotal           Money            `json:"subtotal"`
+	Tax                Money            `json:"tax"`
+	Total              Money            `json:"total"`
+	Tax_breakdown      []TaxBreakdown   `json:"tax_breakdown"`
+	Lines              []CreditNoteLine `json:"lines"`
+	Created_by         *int64           `json:"created_by"`
+	Issued_at          string           `json:"issued_at"`
+}
+
+type CreditNoteLine struct {
+	ID              int64  `json:"id"`
+	Credit_note_id  int64  `json:"credit_note_id"`
+	Invoice_line_id *int64 `json:"invoice_line_id"`
+	Description     string `json:"description"`
+	Tax_name        string `json:"tax_name"`
+	Tax_rate        int    `json:"tax_rate"`
+	Net             Money  `json:"net"`
+	Tax             Money  `json:"tax"`
+	Total           Money  `json:"total"`
+}
+
+type CreditNoteStore struct {
+	db  *sql.DB
+	cfg Config
+}
+
+// Create issues a credit note of note.Total against note.Invoice_id, it
+// fails with ErrCreditExceedsInvoice when the invoice has less left to
+// credit.
+func (s *CreditNoteStore) Create(ctx context.Context, note *CreditNote) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		return issueCreditNote(ctx, tx, s.cfg, note)
+	})
+}
+
+const creditNoteQuery = `
+SELECT c.id, c.credit_note_number, c.invoice_id, i.invoice_number, i.user_id, c.refund_id, c.reason,
+	c.subtotal, c.tax, c.total, c.currency, c.created_by, c.issued_at
+FROM credit_note c
+JOIN invoice i ON i.id = c.invoice_id
+`
+
+func (s *CreditNoteStore) GetByNumber(ctx context.Context, number string) (*CreditNote, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, creditNoteQuery+`WHERE c.credit_note_number = $1`, number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes, err := s.scanCreditNotes(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(notes) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &notes[0], nil
+}
+
+func (s *CreditNoteStore) GetByInvoiceID(ctx context.Context, invoiceID int64) ([]CreditNote, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, creditNoteQuery+`WHERE c.invoice_id = $1 ORDER BY c.issued_at, c.id`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return s.scanCreditNotes(ctx, rows)
+}
+
+// issueCreditNote numbers and stores the credit note. An unpaid invoice that
+// ends up fully credited is void, nothing is left to pay on it.
+func issueCreditNote(ctx context.Context, tx *sql.Tx, cfg Config, note *CreditNote) error {
+	var (
+		total    Money
+		credited int64
+		status   string
+	)
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT invoice_number, user_id, total, currency, status FROM invoice WHERE id = $1 FOR UPDATE`,
+		note.Invoice_id,
+	).Scan(&note.Invoice_number, &note.User_id, &total.Amount, &total.Currency, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	err = tx.QueryRowContext(
+		ctx, `SELECT COALESCE(SUM(total), 0) FROM credit_note WHERE invoice_id = $1`, note.Invoice_id,
+	).Scan(&credited)
+	if err != nil {
+		return err
+	}
+
+	if note.Total.Currency != total.Currency {
+		return fmt.Errorf("%w: credit in %s for an invoice in %s", ErrCurrencyMismatch, note.Total.Currency, total.Currency)
+	}
+
+	if note.Total.Amount <= 0 || note.Total.Amount > total.Amount-credited {
+		return fmt.Errorf("%w: %s left", ErrCreditExceedsInvoice, NewMoney(total.Amount-credited, total.Currency))
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT id, description, tax_name, tax_rate, total FROM invoice_line WHERE invoice_id = $1 ORDER BY id`,
+		note.Invoice_id,
+	)
+	if err != nil {
+		return err
+	}
+
+	var lines []InvoiceLine
+	for rows.Next() {
+		var line InvoiceLine
+		if err := rows.Scan(&line.ID, &line.Description, &line.Tax_name, &line.Tax_rate, &line.Total.Amount); err != nil {
+			rows.Close()
+			return err
+		}
+		line.Total.Currency = total.Currency
+		lines = append(lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	note.Lines = allocateCredit(note.Total, total, lines, cfg.DefaultTaxRate)
+	note.Subtotal = NewMoney(0, total.Currency)
+	note.Tax = NewMoney(0, total.Currency)
+	for _, line := range note.Lines {
+		note.Subtotal.Amount += line.Net.Amount
+		note.Tax.Amount += line.Tax.Amount
+	}
+	note.Tax_breakdown = creditNoteTaxBreakdown(note.Lines)
+
+	if note.Credit_note_number, err = nextCreditNoteNumber(ctx, tx); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		`INSERT INTO credit_note (credit_note_number, invoice_id, refund_id, reason, subtotal, tax, total, currency, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, issued_at`,
+		note.Credit_note_number,
+		note.Invoice_id,
+		note.Refund_id,
+		note.Reason,
+		note.Subtotal.Amount,
+		note.Tax.Amount,
+		note.Total.Amount,
+		note.Total.Currency,
+		note.Created_by,
+	).Scan(&note.ID, &note.Issued_at)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+
+	for i := range note.Lines {
+		line := &note.Lines[i]
+		line.Credit_note_id = note.ID
+
+		err := tx.QueryRowContext(
+			ctx,
+			`INSERT INTO credit_note_line (credit_note_id, invoice_line_id, description, tax_name, tax_rate, net, tax, total, currency)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id`,
+			line.Credit_note_id,
+			line.Invoice_line_id,
+			line.Description,
+			line.Tax_name,
+			line.Tax_rate,
+			line.Net.Amount,
+			line.Tax.Amount,
+			line.Total.Amount,
+			line.Total.Currency,
+		).Scan(&line.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if credited+note.Total.Amount == total.Amount && (status == InvoiceStatusUnpaid || status == InvoiceStatusOverdue) {
+		if _, err := tx.ExecContext(ctx, `UPDATE invoice SET status = 'void' WHERE id = $1`, note.Invoice_id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// creditRefund issues the credit note of a completed refund against the
+// invoice of the refunded payment. A refund is credited once, and never for
+// more than the invoice has left.
+func creditRefund(ctx context.Context, tx *sql.Tx, cfg Config, refundID int64) error {
+	var (
+		invoiceID int64
+		amount    Money
+		reason    string
+		credited  bool
+	)
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT i.id, r.amount, r.currency, r.reason, EXISTS (SELECT 1 FROM credit_note WHERE refund_id = r.id)
+		FROM refund r
+		JOIN invoice i ON i.payment_id = r.payment_id AND i.status <> 'void'
+		WHERE r.id = $1
+		ORDER BY i.id DESC
+		LIMIT 1`,
+		refundID,
+	).Scan(&invoiceID, &amount.Amount, &amount.Currency, &reason, &credited)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// the payment was never invoiced
+			return nil
+		}
+		return err
+	}
+
+	if credited {
+		return nil
+	}
+
+	var left int64
+
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT i.total - COALESCE((SELECT SUM(total) FROM credit_note WHERE invoice_id = i.id), 0) FROM invoice i WHERE i.id = $1`,
+		invoiceID,
+	).Scan(&left)
+	if err != nil {
+		return err
+	}
+
+	amount.Amount = min(amount.Amount, left)
+	if amount.Amount <= 0 {
+		return nil
+	}
+
+	if reason == "" {
+		reason = "refund"
+	}
+
+	return issueCreditNote(ctx, tx, cfg, &CreditNote{
+		Invoice_id: invoiceID,
+		Refund_id:  &refundID,
+		Reason:     reason,
+		Total:      amount,
+	})
+}
+
+// allocateCredit spreads credit over the invoice lines in proportion to
+// their totals, the last line takes the rounding difference. Credit on an
+// invoice without lines is taxed at defaultRate.
+func allocateCredit(credit, invoiceTotal Money, lines []InvoiceLine, defaultRate int) []CreditNoteLine {
+	var (
+		allocated []CreditNoteLine
+		remaining = credit.Amount
+	)
+
+	for i, line := range lines {
+		amount := remaining
+		if i < len(lines)-1 && invoiceTotal.Amount > 0 {
+			amount = divRound(line.Total.Amount*credit.Amount, invoiceTotal.Amount)
+		}
+		remaining -= amount
+
+		if amount == 0 {
+			continue
+		}
+
+		lineID := line.ID
+		total := NewMoney(amount, credit.Currency)
+		net, tax := total.SplitTax(line.Tax_rate)
+
+		allocated = append(allocated, CreditNoteLine{
+			Invoice_line_id: &lineID,
+			Description:     line.Description,
+			Tax_name:        line.Tax_name,
+			Tax_rate:        line.Tax_rate,
+			Net:             net,
+			Tax:             tax,
+			Total:           total,
+		})
+	}
+
+	if len(lines) == 0 {
+		net, tax := credit.SplitTax(defaultRate)
+		allocated = append(allocated, CreditNoteLine{
+			Description: "Credit",
+			Tax_name:    "Tax",
+			Tax_rate:    defaultRate,
+			Net:         net,
+			Tax:         tax,
+			Total:       credit,
+		})
+	}
+
+	return allocated
+}
+
+func creditNoteTaxBreakdown(lines []CreditNoteLine) []TaxBreakdown {
+	taxed := make([]InvoiceLine, len(lines))
+	for i, line := range lines {
+		taxed[i] = InvoiceLine{Tax_name: line.Tax_name, Tax_rate: line.Tax_rate, Net: line.Net, Tax: line.Tax}
+	}
+
+	return taxBreakdown(taxed)
+}
+
+// nextCreditNoteNumber works like nextInvoiceNumber on a sequence of its
+// own.
+func nextCreditNoteNumber(ctx context.Context, tx *sql.Tx) (string, error) {
+	var year, number int
+
+	err := tx.QueryRowContext(
+		ctx,
+		`INSERT INTO credit_note_sequence (year, last_number)
+		VALUES (EXTRACT(YEAR FROM CURRENT_DATE)::INT, 1)
+		ON CONFLICT (year) DO UPDATE SET last_number = credit_note_sequence.last_number + 1
+		RETURNING year, last_number`,
+	).Scan(&year, &number)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("CN-%d-%06d", year, number), nil
+}
+
+func (s *CreditNoteStore) scanCreditNotes(ctx context.Context, rows *sql.Rows) ([]CreditNote, error) {
+	var (
+		notes []CreditNote
+		ids   []int64
+	)
+
+	for rows.Next() {
+		var (
+			note     CreditNote
+			currency string
+		)
+		if err := rows.Scan(
+			&note.ID,
+			&note.Credit_note_number,
+			&note.Invoice_id,
+			&note.Invoice_number,
+			&note.User_id,
+			&note.Refund_id,
+			&note.Reason,
+			&note.Subtotal.Amount,
+			&note.Tax.Amount,
+			&note.Total.Amount,
+			&currency,
+			&note.Created_by,
+			&note.Issued_at,
+		); err != nil {
+			return nil, err
+		}
+
+		note.Subtotal.Currency = currency
+		note.Tax.Currency = currency
+		note.Total.Currency = currency
+
+		notes = append(notes, note)
+		ids = append(ids, note.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(notes) == 0 {
+		return notes, nil
+	}
+
+	lineRows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, credit_note_id, invoice_line_id, description, tax_name, tax_rate, net, tax, total, currency
+		FROM credit_note_line
+		WHERE credit_note_id = ANY($1)
+		ORDER BY id`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer lineRows.Close()
+
+	byID := make(map[int64]*CreditNote, len(notes))
+	for i := range notes {
+		byID[notes[i].ID] = &notes[i]
+	}
+
+	for lineRows.Next() {
+		var line CreditNoteLine
+		if err := lineRows.Scan(
+			&line.ID,
+			&line.Credit_note_id,
+			&line.Invoice_line_id,
+			&line.Description,
+			&line.Tax_name,
+			&line.Tax_rate,
+			&line.Net.Amount,
+			&line.Tax.Amount,
+			&line.Total.Amount,
+			&line.Total.Currency,
+		); err != nil {
+			return nil, err
+		}
+
+		line.Net.Currency = line.Total.Currency
+		line.Tax.Currency = line.Total.Currency
+
+		note := byID[line.Credit_note_id]
+		note.Lines = append(note.Lines, line)
+	}
+	if err := lineRows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range notes {
+		notes[i].Tax_breakdown = creditNoteTaxBreakdown(notes[i].Lines)
+	}
+
+	return notes, nil
+}
@@ -0,0 +1,309 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// earthRadiusKm is the mean radius of the earth, distances are measured on a
+// sphere which is within half a percent of the real thing.
+const earthRadiusKm = 6371.0088
+
+// kmPerDegree is the length of a degree of latitude, and of longitude at the
+// equator.
+const kmPerDegree = math.Pi * earthRadiusKm / 180
+
+const (
+	TripPointDeparture   = "departure"
+	TripPointDestination = "destination"
+)
+
+// Point is a WGS 84 coordinate in degrees.
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+func (p *Point) lat() any {
+	if p == nil {
+		return nil
+	}
+	return p.Lat
+}
+
+func (p *Point) lng() any {
+	if p == nil {
+		return nil
+	}
+	return p.Lng
+}
+
+// nullPoint scans a point whose columns may both be NULL.
+type nullPoint struct {
+	Lat sql.NullFloat64
+	Lng sql.NullFloat64
+}
+
+func (p nullPoint) point() *Point {
+	if !p.Lat.Valid || !p.Lng.Valid {
+		return nil
+	}
+	return &Point{Lat: p.Lat.Float64, Lng: p.Lng.Float64}
+}
+
+// BoundingBox is an area between two parallels and two meridians. A box with
+// Min_lng past Max_lng crosses the antimeridian.
+type BoundingBox struct {
+	Min_lat float64
+	Min_lng float64
+	Max_lat float64
+	Max_lng float64
+}
+
+// GeoSearch finds the places within Radius_km of Center, or inside Box when
+// there is no center. Results are nearest first when there is a center.
+type GeoSearch struct {
+	Center    *Point
+	Radius_km float64
+	Box       *BoundingBox
+	Limit     int
+}
+
+// box returns the area to look in: Box, or the smallest box holding the
+// circle around Center.
+func (g GeoSearch) box() BoundingBox {
+	if g.Center == nil {
+		return *g.Box
+	}
+
+	dLat := g.Radius_km / kmPerDegree
+	box := BoundingBox{
+		Min_lat: math.Max(g.Center.Lat-dLat, -90),
+		Max_lat: math.Min(g.Center.Lat+dLat, 90),
+		Min_lng: -180,
+		Max_lng: 180,
+	}
+
+	// near a pole the circle spans every meridian
+	if box.Min_lat == -90 || box.Max_lat == 90 {
+		return box
+	}
+
+	ratio := math.Sin(g.Radius_km/earthRadiusKm) / math.Cos(g.Center.Lat*math.Pi/180)
+	if ratio >= 1 {
+		return box
+	}
+
+	dLng := math.Asin(ratio) * 180 / math.Pi
+
+	box.Min_lng = wrapLng(g.Center.Lng - dLng)
+	box.Max_lng = wrapLng(g.Center.Lng + dLng)
+
+	return box
+}
+
+func wrapLng(lng float64) float64 {
+	switch {
+	case lng < -180:
+		return lng + 360
+	case lng > 180:
+		return lng - 360
+	default:
+		return lng
+	}
+}
+
+// where narrows the columns latColumn and lngColumn to the search area and
+// returns the conditions together with the distance to the center in km,
+// NULL without a center. arg adds a query argument and returns its
+// placeholder.
+func (g GeoSearch) where(latColumn, lngColumn string, arg func(any) string) (string, string) {
+	box := g.box()
+
+	conditions := []string{
+		fmt.Sprintf("%s BETWEEN %s AND %s", latColumn, arg(box.Min_lat), arg(box.Max_lat)),
+	}
+
+	if box.Min_lng <= box.Max_lng {
+		conditions = append(conditions, fmt.Sprintf("%s BETWEEN %s AND %s", lngColumn, arg(box.Min_lng), arg(box.Max_lng)))
+	} else {
+		conditions = append(conditions, fmt.Sprintf("(%s >= %s OR %s <= %s)", lngColumn, arg(box.Min_lng), lngColumn, arg(box.Max_lng)))
+	}
+
+	if g.Center == nil {
+		return strings.Join(conditions, " AND "), "NULL::float8"
+	}
+
+	// haversine, LEAST keeps rounding from pushing asin past its domain
+	lat, lng := arg(g.Center.Lat)+"::float8", arg(g.Center.Lng)+"::float8"
+	distance := fmt.Sprintf(
+		"(%s * 2 * asin(LEAST(1, sqrt(power(sin(radians(%s - %s) / 2), 2) + cos(radians(%s)) * cos(radians(%s)) * power(sin(radians(%s - %s) / 2), 2)))))",
+		strconv.FormatFloat(earthRadiusKm, 'f', -1, 64), latColumn, lat, lat, latColumn, lngColumn, lng,
+	)
+
+	conditions = append(conditions, fmt.Sprintf("%s <= %s", distance, arg(g.Radius_km)))
+
+	return strings.Join(conditions, " AND "), distance
+}
+
+func (g GeoSearch) limit() int {
+	if g.Limit <= 0 {
+		return 50
+	}
+	return g.Limit
+}
+
+// TripDistance is a trip found by a nearby search with the distance of the
+// searched point to the center in km, nil for bounding box searches.
+type TripDistance struct {
+	Trip
+	Distance_km *float64 `json:"distance_km"`
+}
+
+// Nearby returns the upcoming trips, cancelled ones left out, whose departure or destination, as
+// point says, lies in the search area.
+func (s *TripStore) Nearby(ctx context.Context, point string, search GeoSearch) ([]TripDistance, error) {
+	if point != TripPointDeparture && point != TripPointDestination {
+		return nil, fmt.Errorf("unknown trip point %q", point)
+	}
+
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where, distance := search.where(point+"_lat", point+"_lng", arg)
+
+	query := fmt.Sprintf(`
+	SELECT id, name, description, location, start_date, end_date, price, currency, seats, available_seats,
+		cancellation_policy_id, operator_id, departure_lat, departure_lng, destination_lat, destination_lng, template_id, cancelled_at, created_at, distance
+	FROM (
+		SELECT *, %s AS distance
+		FROM trip
+		WHERE start_date >= CURRENT_DATE AND cancelled_at IS NULL AND %s
+	) t
+	ORDER BY distance ASC NULLS LAST, start_date ASC, id ASC
+	LIMIT %s`,
+		distance, where, arg(search.limit()),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trips []TripDistance
+
+	for rows.Next() {
+		var (
+			trip                   TripDistance
+			departure, destination nullPoint
+		)
+		if err := rows.Scan(
+			&trip.ID,
+			&trip.Name,
+			&trip.Decription,
+			&trip.Location,
+			&trip.Start_date,
+			&trip.End_date,
+			&trip.Price.Amount,
+			&trip.Price.Currency,
+			&trip.Seats,
+			&trip.Available_seats,
+			&trip.Cancellation_policy_id,
+			&trip.Operator_id,
+			&departure.Lat,
+			&departure.Lng,
+			&destination.Lat,
+			&destination.Lng,
+			&trip.Template_id,
+			&trip.Cancelled_at,
+			&trip.Created_at,
+			&trip.Distance_km,
+		); err != nil {
+			return nil, err
+		}
+
+		trip.Departure = departure.point()
+		trip.Destination = destination.point()
+		trips = append(trips, trip)
+	}
+
+	return trips, rows.Err()
+}
+
+// AccomodationDistance is an accomodation found by a nearby search with its
+// distance to the center in km, nil for bounding box searches.
+type AccomodationDistance struct {
+	Accomodation
+	Distance_km *float64 `json:"distance_km"`
+}
+
+// Nearby returns the accomodations in the search area.
+func (s *AccomodationStore) Nearby(ctx context.Context, search GeoSearch) ([]AccomodationDistance, error) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where, distance := search.where("lat", "lng", arg)
+
+	query := fmt.Sprintf(`
+	SELECT id, trip_id, name, description, price_per_night, currency, lat, lng, created_at, distance
+	FROM (
+		SELECT *, %s AS distance
+		FROM accomodation
+		WHERE %s
+	) a
+	ORDER BY distance ASC NULLS LAST, id ASC
+	LIMIT %s`,
+		distance, where, arg(search.limit()),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accomodations []AccomodationDistance
+
+	for rows.Next() {
+		var (
+			accomodation AccomodationDistance
+			point        nullPoint
+		)
+		if err := rows.Scan(
+			&accomodation.ID,
+			&accomodation.Trip_id,
+			&accomodation.Name,
+			&accomodation.Description,
+			&accomodation.Price_per_night.Amount,
+			&accomodation.Price_per_night.Currency,
+			&point.Lat,
+			&point.Lng,
+			&accomodation.Created_at,
+			&accomodation.Distance_km,
+		); err != nil {
+			return nil, err
+		}
+
+		accomodation.Location = point.point()
+		accomodations = append(accomodations, accomodation)
+	}
+
+	return accomodations, rows.Err()
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// IdempotencyLockTimeout is how long a request may hold its key before a
+// retry is allowed to take it over, it outlives the server's request timeout
+// so a key is only taken over once the original request is gone.
+var IdempotencyLockTimeout = time.Minute * 2
+
+// IdempotencyKey remembers the outcome of a request made with an
+// Idempotency-Key header. Response_status is nil while the request is still
+// being handled.
+type IdempotencyKey struct {
+	ID              int64   `json:"id"`
+	Scope           string  `json:"scope"`
+	Key             string  `json:"key"`
+	Method          string  `json:"method"`
+	Path            string  `json:"path"`
+	Fingerprint     string  `json:"fingerprint"`
+	Response_status *int    `json:"response_status"`
+	Response_body   []byte  `json:"-"`
+	Content_type    *string `json:"content_type"`
+	Created_at      string  `json:"created_at"`
+	Completed_at    *string `json:"completed_at"`
+}
+
+type IdempotencyKeyStore struct {
+	db *sql.DB
+}
+
+// Begin claims the key for a request. It reports true when the caller owns
+// the key and should handle the request, otherwise key is filled with the
+// stored row so the caller can replay or reject it. A key abandoned by a
+// request with the same fingerprint is taken over after
+// IdempotencyLockTimeout.
+func (s *IdempotencyKeyStore) Begin(ctx context.Context, key *IdempotencyKey) (bool, error) {
+	query := `INSERT INTO idempotency_key (scope, key, method, path, fingerprint)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (scope, key) DO UPDATE SET created_at = NOW()
+	WHERE idempotency_key.completed_at IS NULL
+		AND idempotency_key.fingerprint = EXCLUDED.fingerprint
+		AND idempotency_key.created_at < NOW() - make_interval(secs => $6)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx, query, key.Scope, key.Key, key.Method, key.Path, key.Fingerprint, IdempotencyLockTimeout.Seconds(),
+	).Scan(&key.ID, &key.Created_at)
+	if err == nil {
+		return true, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	query = `SELECT id, method, path, fingerprint, response_status, response_body, content_type, created_at, completed_at
+	FROM idempotency_key
+	WHERE scope = $1 AND key = $2`
+
+	err = s.db.QueryRowContext(ctx, query, key.Scope, key.Key).Scan(
+		&key.ID,
+		&key.Method,
+		&key.Path,
+		&key.Fingerprint,
+		&key.Response_status,
+		&key.Response_body,
+		&key.Content_type,
+		&key.Created_at,
+		&key.Completed_at,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrNotFound
+		}
+		return false, err
+	}
+
+	return false, nil
+}
+
+// Complete stores the response so retries with the key replay it.
+func (s *IdempotencyKeyStore) Complete(ctx context.Context, key *IdempotencyKey) error {
+	query := `UPDATE idempotency_key
+	SET response_status = $1, response_body = $2, content_type = $3, completed_at = NOW()
+	WHERE id = $4`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, key.Response_status, key.Response_body, key.Content_type, key.ID)
+
+	return err
+}
+
+// Release forgets a key whose request failed so it can be retried.
+func (s *IdempotencyKeyStore) Release(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_key WHERE id = $1 AND completed_at IS NULL`, id)
+
+	return err
+}
+
+// DeleteExpired removes keys older than ttl and returns how many were removed.
+func (s *IdempotencyKeyStore) DeleteExpired(ctx context.Context, ttl time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(
+		ctx, `DELETE FROM idempotency_key WHERE created_at < NOW() - make_interval(secs => $1)`, ttl.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
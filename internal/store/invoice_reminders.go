@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+)
+
+// InvoiceReminder is a dunning reminder recorded for an overdue invoice.
+// Stage counts up with every reminder of the schedule the invoice reached.
+type InvoiceReminder struct {
+	ID             int64  `json:"id"`
+	Invoice_id     int64  `json:"invoice_id"`
+	Invoice_number string `json:"invoice_number"`
+	Booking_id     int64  `json:"booking_id"`
+	User_id        int64  `json:"user_id"`
+	Total          Money  `json:"total"`
+	Due_date       string `json:"due_date"`
+	Stage          int    `json:"stage"`
+	Days_overdue   int    `json:"days_overdue"`
+	Sent_at        string `json:"sent_at"`
+}
+
+// MarkOverdue flags unpaid invoices whose due date has passed and returns
+// how many it flagged.
+func (s *InvoiceStore) MarkOverdue(ctx context.Context) (int64, error) {
+	query := `UPDATE invoice SET status = 'overdue' WHERE status = 'unpaid' AND due_date::date < CURRENT_DATE`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// RecordReminders records the reminders that are due for overdue invoices of
+// bookings that are still active. schedule lists the days after the due date
+// a reminder goes out, an invoice that skipped stages gets only the latest.
+func (s *InvoiceStore) RecordReminders(ctx context.Context, schedule []int) ([]InvoiceReminder, error) {
+	days := append([]int(nil), schedule...)
+	sort.Ints(days)
+
+	query := `
+	SELECT i.id, i.invoice_number, i.booking_id, i.user_id, i.total, i.currency, i.due_date,
+		CURRENT_DATE - i.due_date::date, COALESCE(MAX(r.stage), 0)
+	FROM invoice i
+	JOIN booking b ON b.id = i.booking_id
+	LEFT JOIN invoice_reminder r ON r.invoice_id = i.id
+	WHERE i.status = 'overdue' AND b.status IN ('pending', 'confirmed')
+	GROUP BY i.id
+	ORDER BY i.id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var due []InvoiceReminder
+
+	for rows.Next() {
+		var (
+			reminder InvoiceReminder
+			sent     int
+		)
+		if err := rows.Scan(
+			&reminder.Invoice_id,
+			&reminder.Invoice_number,
+			&reminder.Booking_id,
+			&reminder.User_id,
+			&reminder.Total.Amount,
+			&reminder.Total.Currency,
+			&reminder.Due_date,
+			&reminder.Days_overdue,
+			&sent,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		for reminder.Stage < len(days) && days[reminder.Stage] <= reminder.Days_overdue {
+			reminder.Stage++
+		}
+
+		if reminder.Stage > sent {
+			due = append(due, reminder)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var recorded []InvoiceReminder
+
+	for _, reminder := range due {
+		err := s.db.QueryRowContext(
+			ctx,
+			`INSERT INTO invoice_reminder (invoice_id, stage, days_overdue)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (invoice_id, stage) DO NOTHING
+			RETURNING id, sent_at`,
+			reminder.Invoice_id, reminder.Stage, reminder.Days_overdue,
+		).Scan(&reminder.ID, &reminder.Sent_at)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				// another run recorded it first
+				continue
+			}
+			return nil, err
+		}
+
+		recorded = append(recorded, reminder)
+	}
+
+	return recorded, nil
+}
+
+// DeleteReminder forgets a reminder that couldn't be delivered so the next
+// run sends it again.
+func (s *InvoiceStore) DeleteReminder(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM invoice_reminder WHERE id = $1`, id)
+
+	return err
+}
+
+// GetOverdueSince returns invoices overdue for at least days whose booking
+// is still active.
+func (s *InvoiceStore) GetOverdueSince(ctx context.Context, days int) ([]Invoice, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		invoiceQuery+`WHERE i.status = 'overdue'
+		AND CURRENT_DATE - i.due_date::date >= $1
+		AND i.booking_id IN (SELECT id FROM booking WHERE status IN ('pending', 'confirmed'))
+		ORDER BY i.id`,
+		days,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return s.scanInvoices(ctx, rows)
+}
+
+// CancelForOverdueInvoice cancels the booking of an overdue invoice and
+// credits what is left on the invoice, which voids it, in one transaction.
+// It fails with ErrNotFound when the invoice isn't overdue anymore.
+func (s *BookingStore) CancelForOverdueInvoice(ctx context.Context, invoiceID int64, reason string) (*Cancellation, error) {
+	var cancellation *Cancellation
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var (
+			bookingID int64
+			left      Money
+		)
+
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT i.booking_id, i.total - COALESCE((SELECT SUM(total) FROM credit_note WHERE invoice_id = i.id), 0), i.currency
+			FROM invoice i
+			WHERE i.id = $1 AND i.status = 'overdue'
+			FOR UPDATE`,
+			invoiceID,
+		).Scan(&bookingID, &left.Amount, &left.Currency)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		cancellation, err = cancelBooking(ctx, tx, &BookingStatusChange{
+			Booking_id: bookingID,
+			Reason:     reason,
+		})
+		if err != nil {
+			return err
+		}
+
+		if left.Amount <= 0 {
+			return nil
+		}
+
+		return issueCreditNote(ctx, tx, s.cfg, &CreditNote{
+			Invoice_id: invoiceID,
+			Reason:     "booking cancelled, invoice unpaid",
+			Total:      left,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cancellation, nil
+}
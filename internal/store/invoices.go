@@ -3,65 +3,245 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+const (
+	InvoiceStatusPaid              = "paid"
+	InvoiceStatusUnpaid            = "unpaid"
+	InvoiceStatusOverdue           = "overdue"
+	InvoiceStatusPartiallyRefunded = "partially_refunded"
+	InvoiceStatusRefunded          = "refunded"
+	InvoiceStatusVoid              = "void"
+
+	InvoiceLineTrip         = "trip"
+	InvoiceLineActivity     = "activity"
+	InvoiceLineAccomodation = "accomodation"
 )
 
+var ErrPriceChanged = errors.New("booking price changed since the payment was started")
+
 type Invoice struct {
-	ID             int64  `json:"id"`
-	Payment_id     int64  `json:"payment_id"`
-	Invoice_number string `json:"invoice_number"`
-	Issue_at       string `json:"issue_at"`
-	Due_date       string `json:"due_date"`
-	Status         string `json:"status"`
+	ID             int64          `json:"id"`
+	Payment_id     int64          `json:"payment_id"`
+	Booking_id     int64          `json:"booking_id"`
+	User_id        int64          `json:"user_id"`
+	Invoice_number string         `json:"invoice_number"`
+	Issue_at       string         `json:"issue_at"`
+	Due_date       string         `json:"due_date"`
+	Status         string         `json:"status"`
+	Subtotal       Money          `json:"subtotal"`
+	Tax            Money          `json:"tax"`
+	Total          Money          `json:"total"`
+	Credited       Money          `json:"credited"`
+	Outstanding    Money          `json:"outstanding"`
+	Tax_breakdown  []TaxBreakdown `json:"tax_breakdown"`
+	Lines          []InvoiceLine  `json:"lines"`
+}
+
+// InvoiceLine is one priced item of a booking. Prices include tax, Net and
+// Tax split Total at the rate of the tax rule that applies to the item.
+type InvoiceLine struct {
+	ID           int64  `json:"id"`
+	Invoice_id   int64  `json:"invoice_id"`
+	Kind         string `json:"kind"`
+	Reference_id int64  `json:"reference_id"`
+	Description  string `json:"description"`
+	Quantity     int    `json:"quantity"`
+	Unit_price   Money  `json:"unit_price"`
+	Tax_name     string `json:"tax_name"`
+	Tax_rate     int    `json:"tax_rate"`
+	Net          Money  `json:"net"`
+	Tax          Money  `json:"tax"`
+	Total        Money  `json:"total"`
+}
+
+// TaxBreakdown sums the lines taxed under the same tax at the same rate.
+type TaxBreakdown struct {
+	Tax_name string `json:"tax_name"`
+	Tax_rate int    `json:"tax_rate"`
+	Net      Money  `json:"net"`
+	Tax      Money  `json:"tax"`
+}
+
+// Quote is what a booking costs: the trip plus the activities and
+// accomodation that come with it, for every passenger.
+type Quote struct {
+	Booking_id    int64          `json:"booking_id"`
+	Lines         []InvoiceLine  `json:"lines"`
+	Subtotal      Money          `json:"subtotal"`
+	Tax           Money          `json:"tax"`
+	Total         Money          `json:"total"`
+	Tax_breakdown []TaxBreakdown `json:"tax_breakdown"`
 }
 
 type InvoiceStore struct {
-	db *sql.DB
+	db  *sql.DB
+	cfg Config
 }
 
-func (s *InvoiceStore) Create(ctx context.Context, invoice *Invoice) error {
-	query := `INSERT INTO invoice (payment_id, invoice_number, issue_at, due_date, status)
-	VALUES ($1, $2, $3, $4, $5)
-	RETURNING id, issue_at
-	`
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
+// Issue invoices a payment that has none yet, it fails with ErrConflict when
+// the payment already has an invoice that isn't void.
+func (s *InvoiceStore) Issue(ctx context.Context, paymentID int64) (*Invoice, error) {
+	var invoice *Invoice
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		payment := &Payment{}
+
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT id, booking_id, user_id, amount, currency, status, transaction_id, created_at
+			FROM payment
+			WHERE id = $1
+			FOR UPDATE`,
+			paymentID,
+		).Scan(
+			&payment.ID,
+			&payment.Booking_id,
+			&payment.User_id,
+			&payment.Amount.Amount,
+			&payment.Amount.Currency,
+			&payment.Status,
+			&payment.Transaction_id,
+			&payment.Created_at,
+		)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		var invoiced bool
+
+		err = tx.QueryRowContext(
+			ctx, `SELECT EXISTS (SELECT 1 FROM invoice WHERE payment_id = $1 AND status <> 'void')`, paymentID,
+		).Scan(&invoiced)
+		if err != nil {
+			return err
+		}
+
+		if invoiced {
+			return ErrConflict
+		}
+
+		invoice, err = issueInvoice(ctx, tx, s.cfg, payment)
 
-	err := s.db.QueryRowContext(
-		ctx, query, invoice.Payment_id, invoice.Invoice_number, invoice.Issue_at, invoice.Due_date, invoice.Status,
-	).Scan(&invoice.ID, &invoice.Issue_at)
-	if err != nil {
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	return invoice, nil
 }
 
-func (s *InvoiceStore) UpdateByInvoiceNumber(ctx context.Context, invoice *Invoice) error {
-	query := `UPDATE invoice 
-	SET status = $1 
-	WHERE invoice_number = $2
-	RETURNING id`
+// invoiceQuery selects invoices with what has been credited on them, what
+// their payment settled and what was refunded of it since.
+const invoiceQuery = `
+SELECT i.id, i.payment_id, i.booking_id, i.user_id, i.invoice_number, i.issue_at, i.due_date, i.status,
+	i.subtotal, i.tax, i.total, i.currency,
+	COALESCE((SELECT SUM(c.total) FROM credit_note c WHERE c.invoice_id = i.id), 0),
+	COALESCE((
+		SELECT p.amount FROM payment p
+		WHERE p.id = i.payment_id AND p.status IN ('complete', 'partially_refunded', 'refunded')
+	), 0),
+	COALESCE((SELECT SUM(r.amount) FROM refund r WHERE r.payment_id = i.payment_id AND r.status = 'complete'), 0)
+FROM invoice i
+`
 
+func (s *InvoiceStore) GetByInvoiceNumber(ctx context.Context, invoiceNumber string) (*Invoice, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query, invoice.Status, invoice.Invoice_number).Scan(&invoice.ID)
+	rows, err := s.db.QueryContext(ctx, invoiceQuery+`WHERE i.invoice_number = $1`, invoiceNumber)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer rows.Close()
+
+	invoices, err := s.scanInvoices(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(invoices) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &invoices[0], nil
 }
 
-func (s *InvoiceStore) GetByInvoiceNumber(ctx context.Context, invoiceNumber string) (*Invoice, error) {
-	query := `SELECT id, payment_id, invoice_number, issue_at, due_date, status FROM invoice WHERE invoice_number = $1 `
+func (s *InvoiceStore) GetByUserID(ctx context.Context, userID int64) ([]Invoice, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
 
+	rows, err := s.db.QueryContext(ctx, invoiceQuery+`WHERE i.user_id = $1 ORDER BY i.issue_at DESC, i.id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return s.scanInvoices(ctx, rows)
+}
+
+func (s *InvoiceStore) GetByBookingID(ctx context.Context, bookingID int64) ([]Invoice, error) {
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	invoice := &Invoice{}
+	rows, err := s.db.QueryContext(ctx, invoiceQuery+`WHERE i.booking_id = $1 ORDER BY i.issue_at, i.id`, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return s.scanInvoices(ctx, rows)
+}
+
+// Quote prices the booking as it would be invoiced now.
+func (s *BookingStore) Quote(ctx context.Context, bookingID int64) (*Quote, error) {
+	var quote *Quote
 
-	err := s.db.QueryRowContext(ctx, query, invoiceNumber).Scan(
-		&invoice.ID, &invoice.Payment_id, &invoice.Issue_at, &invoice.Due_date, &invoice.Status,
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var err error
+		quote, err = quoteBooking(ctx, tx, s.cfg, bookingID)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return quote, nil
+}
+
+func quoteBooking(ctx context.Context, tx *sql.Tx, cfg Config, bookingID int64) (*Quote, error) {
+	var (
+		tripID     int64
+		tripName   string
+		location   string
+		price      Money
+		passengers int
+		nights     int
 	)
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT t.id, t.name, t.location, t.price, t.currency, b.quantity, GREATEST(t.end_date - t.start_date, 0)
+		FROM booking b
+		JOIN trip t ON t.id = b.trip_id
+		WHERE b.id = $1`,
+		bookingID,
+	).Scan(&tripID, &tripName, &location, &price.Amount, &price.Currency, &passengers, &nights)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
@@ -69,5 +249,339 @@ func (s *InvoiceStore) GetByInvoiceNumber(ctx context.Context, invoiceNumber str
 		return nil, err
 	}
 
+	rules, err := loadTaxRules(ctx, tx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	quote := &Quote{Booking_id: bookingID}
+
+	quote.Lines = append(quote.Lines, InvoiceLine{
+		Kind:         InvoiceLineTrip,
+		Reference_id: tripID,
+		Description:  tripName,
+		Quantity:     passengers,
+		Unit_price:   price,
+	})
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, name, price, currency, tax_exempt FROM activity WHERE trip_id = $1 ORDER BY id`, tripID)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var taxExempt bool
+
+		line := InvoiceLine{Kind: InvoiceLineActivity, Quantity: passengers}
+		if err := rows.Scan(&line.Reference_id, &line.Description, &line.Unit_price.Amount, &line.Unit_price.Currency, &taxExempt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if taxExempt {
+			line.Tax_name = "Tax exempt"
+		}
+		quote.Lines = append(quote.Lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if nights > 0 {
+		rows, err := tx.QueryContext(ctx, `SELECT id, name, price_per_night, currency FROM accomodation WHERE trip_id = $1 ORDER BY id`, tripID)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			line := InvoiceLine{Kind: InvoiceLineAccomodation, Quantity: passengers * nights}
+			if err := rows.Scan(&line.Reference_id, &line.Description, &line.Unit_price.Amount, &line.Unit_price.Currency); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			line.Description = fmt.Sprintf("%s, %d nights", line.Description, nights)
+			quote.Lines = append(quote.Lines, line)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	quote.Subtotal = NewMoney(0, price.Currency)
+	quote.Tax = NewMoney(0, price.Currency)
+	quote.Total = NewMoney(0, price.Currency)
+
+	for i := range quote.Lines {
+		line := &quote.Lines[i]
+
+		if line.Unit_price.Currency != price.Currency {
+			return nil, fmt.Errorf("%w: %s %q is priced in %s, the trip in %s", ErrCurrencyMismatch, line.Kind, line.Description, line.Unit_price.Currency, price.Currency)
+		}
+
+		// exempt lines are named already and keep a zero rate
+		if line.Tax_name == "" {
+			line.Tax_name, line.Tax_rate = rules.resolve(line.Kind, cfg.DefaultTaxRate)
+		}
+		line.Total = line.Unit_price.Mul(int64(line.Quantity))
+		line.Net, line.Tax = line.Total.SplitTax(line.Tax_rate)
+
+		quote.Subtotal.Amount += line.Net.Amount
+		quote.Tax.Amount += line.Tax.Amount
+		quote.Total.Amount += line.Total.Amount
+	}
+
+	quote.Tax_breakdown = taxBreakdown(quote.Lines)
+
+	return quote, nil
+}
+
+// issueInvoice numbers and stores the invoice for the payment, its lines are
+// the booking's quote which must still add up to the payment.
+func issueInvoice(ctx context.Context, tx *sql.Tx, cfg Config, payment *Payment) (*Invoice, error) {
+	quote, err := quoteBooking(ctx, tx, cfg, payment.Booking_id)
+	if err != nil {
+		return nil, err
+	}
+
+	if quote.Total != payment.Amount {
+		return nil, fmt.Errorf("%w: it costs %s, the payment is %s", ErrPriceChanged, quote.Total, payment.Amount)
+	}
+
+	number, err := nextInvoiceNumber(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice := &Invoice{
+		Payment_id:     payment.ID,
+		Booking_id:     payment.Booking_id,
+		User_id:        payment.User_id,
+		Invoice_number: number,
+		Status:         invoiceStatusForPayment(payment.Status),
+		Subtotal:       quote.Subtotal,
+		Tax:            quote.Tax,
+		Total:          quote.Total,
+		Tax_breakdown:  quote.Tax_breakdown,
+		Lines:          quote.Lines,
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		`INSERT INTO invoice (payment_id, booking_id, user_id, invoice_number, due_date, status, subtotal, tax, total, currency)
+		VALUES ($1, $2, $3, $4, CURRENT_DATE + $5::INT, $6, $7, $8, $9, $10)
+		RETURNING id, issue_at, due_date`,
+		invoice.Payment_id,
+		invoice.Booking_id,
+		invoice.User_id,
+		invoice.Invoice_number,
+		cfg.InvoiceDueDays,
+		invoice.Status,
+		invoice.Subtotal.Amount,
+		invoice.Tax.Amount,
+		invoice.Total.Amount,
+		invoice.Total.Currency,
+	).Scan(&invoice.ID, &invoice.Issue_at, &invoice.Due_date)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range invoice.Lines {
+		line := &invoice.Lines[i]
+		line.Invoice_id = invoice.ID
+
+		err := tx.QueryRowContext(
+			ctx,
+			`INSERT INTO invoice_line (invoice_id, kind, reference_id, description, quantity, unit_price, tax_name, tax_rate, net, tax, total, currency)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			RETURNING id`,
+			line.Invoice_id,
+			line.Kind,
+			line.Reference_id,
+			line.Description,
+			line.Quantity,
+			line.Unit_price.Amount,
+			line.Tax_name,
+			line.Tax_rate,
+			line.Net.Amount,
+			line.Tax.Amount,
+			line.Total.Amount,
+			line.Total.Currency,
+		).Scan(&line.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return invoice, nil
 }
+
+// nextInvoiceNumber takes the next number of the current year. The counter
+// row stays locked until the transaction ends, so concurrent invoices queue
+// up and a rolled back invoice leaves no gap.
+func nextInvoiceNumber(ctx context.Context, tx *sql.Tx) (string, error) {
+	var year, number int
+
+	err := tx.QueryRowContext(
+		ctx,
+		`INSERT INTO invoice_sequence (year, last_number)
+		VALUES (EXTRACT(YEAR FROM CURRENT_DATE)::INT, 1)
+		ON CONFLICT (year) DO UPDATE SET last_number = invoice_sequence.last_number + 1
+		RETURNING year, last_number`,
+	).Scan(&year, &number)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("INV-%d-%06d", year, number), nil
+}
+
+func invoiceStatusForPayment(status string) string {
+	switch status {
+	case PaymentStatusComplete:
+		return InvoiceStatusPaid
+	case PaymentStatusPartiallyRefunded:
+		return InvoiceStatusPartiallyRefunded
+	case PaymentStatusRefunded:
+		return InvoiceStatusRefunded
+	case PaymentStatusFailed:
+		return InvoiceStatusVoid
+	default:
+		return InvoiceStatusUnpaid
+	}
+}
+
+func taxBreakdown(lines []InvoiceLine) []TaxBreakdown {
+	var breakdown []TaxBreakdown
+
+	for _, line := range lines {
+		i := 0
+		for i < len(breakdown) && (breakdown[i].Tax_name != line.Tax_name || breakdown[i].Tax_rate != line.Tax_rate) {
+			i++
+		}
+
+		if i == len(breakdown) {
+			breakdown = append(breakdown, TaxBreakdown{
+				Tax_name: line.Tax_name,
+				Tax_rate: line.Tax_rate,
+				Net:      NewMoney(0, line.Net.Currency),
+				Tax:      NewMoney(0, line.Tax.Currency),
+			})
+		}
+
+		breakdown[i].Net.Amount += line.Net.Amount
+		breakdown[i].Tax.Amount += line.Tax.Amount
+	}
+
+	return breakdown
+}
+
+// scanInvoices reads invoice rows and loads their lines.
+func (s *InvoiceStore) scanInvoices(ctx context.Context, rows *sql.Rows) ([]Invoice, error) {
+	var (
+		invoices []Invoice
+		ids      []int64
+	)
+
+	for rows.Next() {
+		var (
+			invoice  Invoice
+			currency string
+			paid     int64
+			refunded int64
+		)
+		if err := rows.Scan(
+			&invoice.ID,
+			&invoice.Payment_id,
+			&invoice.Booking_id,
+			&invoice.User_id,
+			&invoice.Invoice_number,
+			&invoice.Issue_at,
+			&invoice.Due_date,
+			&invoice.Status,
+			&invoice.Subtotal.Amount,
+			&invoice.Tax.Amount,
+			&invoice.Total.Amount,
+			&currency,
+			&invoice.Credited.Amount,
+			&paid,
+			&refunded,
+		); err != nil {
+			return nil, err
+		}
+
+		invoice.Subtotal.Currency = currency
+		invoice.Tax.Currency = currency
+		invoice.Total.Currency = currency
+		invoice.Credited.Currency = currency
+
+		// credit notes lower what is billed, refunds give back what was
+		// paid, a negative balance is owed to the customer
+		invoice.Outstanding = NewMoney(invoice.Total.Amount-invoice.Credited.Amount-paid+refunded, currency)
+
+		invoices = append(invoices, invoice)
+		ids = append(ids, invoice.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(invoices) == 0 {
+		return invoices, nil
+	}
+
+	lineRows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, invoice_id, kind, reference_id, description, quantity, unit_price, tax_name, tax_rate, net, tax, total, currency
+		FROM invoice_line
+		WHERE invoice_id = ANY($1)
+		ORDER BY id`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer lineRows.Close()
+
+	byID := make(map[int64]*Invoice, len(invoices))
+	for i := range invoices {
+		byID[invoices[i].ID] = &invoices[i]
+	}
+
+	for lineRows.Next() {
+		var line InvoiceLine
+		if err := lineRows.Scan(
+			&line.ID,
+			&line.Invoice_id,
+			&line.Kind,
+			&line.Reference_id,
+			&line.Description,
+			&line.Quantity,
+			&line.Unit_price.Amount,
+			&line.Tax_name,
+			&line.Tax_rate,
+			&line.Net.Amount,
+			&line.Tax.Amount,
+			&line.Total.Amount,
+			&line.Total.Currency,
+		); err != nil {
+			return nil, err
+		}
+
+		line.Unit_price.Currency = line.Total.Currency
+		line.Net.Currency = line.Total.Currency
+		line.Tax.Currency = line.Total.Currency
+
+		invoice := byID[line.Invoice_id]
+		invoice.Lines = append(invoice.Lines, line)
+	}
+	if err := lineRows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range invoices {
+		invoices[i].Tax_breakdown = taxBreakdown(invoices[i].Lines)
+	}
+
+	return invoices, nil
+}
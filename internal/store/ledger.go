@@ -0,0 +1,472 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+const (
+	LedgerAccountAsset     = "asset"
+	LedgerAccountLiability = "liability"
+	LedgerAccountRevenue   = "revenue"
+	LedgerAccountExpense   = "expense"
+
+	LedgerKindPaymentCapture = "payment_capture"
+	LedgerKindFee            = "fee"
+	LedgerKindRefund         = "refund"
+	LedgerKindPayout         = "payout"
+)
+
+var ErrInsufficientBalance = errors.New("operator balance is too low for this payout")
+
+// LedgerAccount is an account of the ledger. Balance is on the account's
+// normal side, so what operators are owed shows as a positive liability.
+type LedgerAccount struct {
+	ID          int64  `json:"id"`
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Operator_id *int64 `json:"operator_id"`
+	Balance     Money  `json:"balance"`
+	Created_at  string `json:"created_at"`
+}
+
+type Payout struct {
+	ID          int64  `json:"id"`
+	Operator_id int64  `json:"operator_id"`
+	Amount      Money  `json:"amount"`
+	Description string `json:"description"`
+	Created_by  *int64 `json:"created_by"`
+	Created_at  string `json:"created_at"`
+}
+
+// LedgerTotal is the sum of all debits and credits posted in a currency.
+type LedgerTotal struct {
+	Currency string `json:"currency"`
+	Debits   int64  `json:"debits"`
+	Credits  int64  `json:"credits"`
+}
+
+// TrialBalance proves the books balance: in every currency debits equal
+// credits and no single transaction is out of balance.
+type TrialBalance struct {
+	Accounts                []LedgerAccount `json:"accounts"`
+	Totals                  []LedgerTotal   `json:"totals"`
+	Unbalanced_transactions []int64         `json:"unbalanced_transactions"`
+	Balanced                bool            `json:"balanced"`
+}
+
+type LedgerStore struct {
+	db *sql.DB
+}
+
+const ledgerAccountsQuery = `
+SELECT a.id, a.code, a.name, a.type, a.currency, a.operator_id, COALESCE(SUM(e.amount), 0), a.created_at
+FROM ledger_account a
+LEFT JOIN ledger_entry e ON e.account_id = a.id
+`
+
+func (s *LedgerStore) GetAccounts(ctx context.Context) ([]LedgerAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, ledgerAccountsQuery+`GROUP BY a.id ORDER BY a.code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLedgerAccounts(rows)
+}
+
+// GetOperatorAccounts returns the accounts holding what is owed to the
+// operator, one per currency.
+func (s *LedgerStore) GetOperatorAccounts(ctx context.Context, operatorID int64) ([]LedgerAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, ledgerAccountsQuery+`WHERE a.operator_id = $1 GROUP BY a.id ORDER BY a.code`, operatorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanLedgerAccounts(rows)
+}
+
+func (s *LedgerStore) GetTrialBalance(ctx context.Context) (*TrialBalance, error) {
+	accounts, err := s.GetAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	balance := &TrialBalance{Accounts: accounts, Balanced: true}
+
+	query := `
+	SELECT t.currency,
+		COALESCE(SUM(e.amount) FILTER (WHERE e.amount > 0), 0),
+		COALESCE(-SUM(e.amount) FILTER (WHERE e.amount < 0), 0)
+	FROM ledger_entry e
+	JOIN ledger_transaction t ON t.id = e.transaction_id
+	GROUP BY t.currency
+	ORDER BY t.currency
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var total LedgerTotal
+		if err := rows.Scan(&total.Currency, &total.Debits, &total.Credits); err != nil {
+			return nil, err
+		}
+		if total.Debits != total.Credits {
+			balance.Balanced = false
+		}
+		balance.Totals = append(balance.Totals, total)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+	SELECT transaction_id
+	FROM ledger_entry
+	GROUP BY transaction_id
+	HAVING SUM(amount) <> 0
+	ORDER BY transaction_id
+	`
+
+	rows, err = s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		balance.Unbalanced_transactions = append(balance.Unbalanced_transactions, id)
+		balance.Balanced = false
+	}
+
+	return balance, rows.Err()
+}
+
+// CreatePayout records money paid out to an operator and posts it against
+// what the operator is owed, it fails with ErrInsufficientBalance when the
+// operator is owed less than the payout.
+func (s *LedgerStore) CreatePayout(ctx context.Context, payout *Payout) error {
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		payable, err := operatorPayableAccount(ctx, tx, &payout.Operator_id, payout.Amount.Currency)
+		if err != nil {
+			return err
+		}
+
+		// the account row lock serialises payouts to the same operator
+		var owed int64
+
+		err = tx.QueryRowContext(ctx, `SELECT id FROM ledger_account WHERE id = $1 FOR UPDATE`, payable).Scan(&payable)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(
+			ctx, `SELECT COALESCE(-SUM(amount), 0) FROM ledger_entry WHERE account_id = $1`, payable,
+		).Scan(&owed)
+		if err != nil {
+			return err
+		}
+
+		if owed < payout.Amount.Amount {
+			return fmt.Errorf("%w: owed %s", ErrInsufficientBalance, NewMoney(owed, payout.Amount.Currency))
+		}
+
+		err = tx.QueryRowContext(
+			ctx,
+			`INSERT INTO payout (operator_id, amount, currency, description, created_by)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at`,
+			payout.Operator_id, payout.Amount.Amount, payout.Amount.Currency, payout.Description, payout.Created_by,
+		).Scan(&payout.ID, &payout.Created_at)
+		if err != nil {
+			return err
+		}
+
+		cash, err := cashAccount(ctx, tx, payout.Amount.Currency)
+		if err != nil {
+			return err
+		}
+
+		return postLedgerTransaction(ctx, tx, LedgerKindPayout, payout.ID, payout.Amount.Currency, payout.Description, []ledgerLine{
+			{payable, payout.Amount.Amount},
+			{cash, -payout.Amount.Amount},
+		})
+	})
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// ledgerLine is one side of a posting, debits are positive and credits
+// negative.
+type ledgerLine struct {
+	accountID int64
+	amount    int64
+}
+
+// postLedgerTransaction writes a balanced transaction. A transaction already
+// posted for the same kind and reference is left as is, so posting is safe
+// to repeat.
+func postLedgerTransaction(ctx context.Context, tx *sql.Tx, kind string, referenceID int64, currency, description string, lines []ledgerLine) error {
+	var sum int64
+	for _, line := range lines {
+		sum += line.amount
+	}
+
+	if sum != 0 {
+		return fmt.Errorf("ledger %s transaction for %d does not balance, off by %d", kind, referenceID, sum)
+	}
+
+	var transactionID int64
+
+	err := tx.QueryRowContext(
+		ctx,
+		`INSERT INTO ledger_transaction (kind, reference_id, currency, description)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (kind, reference_id) DO NOTHING
+		RETURNING id`,
+		kind, referenceID, currency, description,
+	).Scan(&transactionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	for _, line := range lines {
+		if line.amount == 0 {
+			continue
+		}
+
+		_, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO ledger_entry (transaction_id, account_id, amount) VALUES ($1, $2, $3)`,
+			transactionID, line.accountID, line.amount,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// postPaymentCapture books a captured payment as cash owed to the trip's
+// operator, then takes the platform fee out of what the operator is owed.
+func postPaymentCapture(ctx context.Context, tx *sql.Tx, cfg Config, payment *Payment) error {
+	operatorID, err := bookingOperator(ctx, tx, payment.Booking_id)
+	if err != nil {
+		return err
+	}
+
+	currency := payment.Amount.Currency
+
+	cash, err := cashAccount(ctx, tx, currency)
+	if err != nil {
+		return err
+	}
+
+	payable, err := operatorPayableAccount(ctx, tx, operatorID, currency)
+	if err != nil {
+		return err
+	}
+
+	err = postLedgerTransaction(ctx, tx, LedgerKindPaymentCapture, payment.ID, currency, "payment captured", []ledgerLine{
+		{cash, payment.Amount.Amount},
+		{payable, -payment.Amount.Amount},
+	})
+	if err != nil {
+		return err
+	}
+
+	fee := payment.Amount.Percent(cfg.PlatformFeePercent)
+	if fee.IsZero() {
+		return nil
+	}
+
+	revenue, err := platformFeeAccount(ctx, tx, currency)
+	if err != nil {
+		return err
+	}
+
+	return postLedgerTransaction(ctx, tx, LedgerKindFee, payment.ID, currency, "platform fee", []ledgerLine{
+		{payable, fee.Amount},
+		{revenue, -fee.Amount},
+	})
+}
+
+// postRefund books a completed refund. The platform gives back the share
+// of its fee that matches the refunded share of the payment, the operator
+// carries the rest.
+func postRefund(ctx context.Context, tx *sql.Tx, refundID int64) error {
+	var (
+		bookingID  int64
+		paymentID  int64
+		refund     Money
+		paid       int64
+		feeCharged int64
+	)
+
+	query := `
+	SELECT r.booking_id, r.payment_id, r.amount, r.currency, p.amount,
+		COALESCE((
+			SELECT -SUM(e.amount)
+			FROM ledger_entry e
+			JOIN ledger_transaction t ON t.id = e.transaction_id
+			JOIN ledger_account a ON a.id = e.account_id
+			WHERE t.kind = 'fee' AND t.reference_id = p.id AND a.type = 'revenue'
+		), 0)
+	FROM refund r
+	JOIN payment p ON p.id = r.payment_id
+	WHERE r.id = $1
+	`
+
+	err := tx.QueryRowContext(ctx, query, refundID).Scan(
+		&bookingID, &paymentID, &refund.Amount, &refund.Currency, &paid, &feeCharged,
+	)
+	if err != nil {
+		return err
+	}
+
+	var feeShare int64
+	if paid > 0 {
+		feeShare = divRound(feeCharged*refund.Amount, paid)
+	}
+
+	operatorID, err := bookingOperator(ctx, tx, bookingID)
+	if err != nil {
+		return err
+	}
+
+	cash, err := cashAccount(ctx, tx, refund.Currency)
+	if err != nil {
+		return err
+	}
+
+	payable, err := operatorPayableAccount(ctx, tx, operatorID, refund.Currency)
+	if err != nil {
+		return err
+	}
+
+	revenue, err := platformFeeAccount(ctx, tx, refund.Currency)
+	if err != nil {
+		return err
+	}
+
+	return postLedgerTransaction(ctx, tx, LedgerKindRefund, refundID, refund.Currency, fmt.Sprintf("refund of payment %d", paymentID), []ledgerLine{
+		{payable, refund.Amount - feeShare},
+		{revenue, feeShare},
+		{cash, -refund.Amount},
+	})
+}
+
+func bookingOperator(ctx context.Context, tx *sql.Tx, bookingID int64) (*int64, error) {
+	var operatorID *int64
+
+	err := tx.QueryRowContext(
+		ctx, `SELECT t.operator_id FROM booking b JOIN trip t ON t.id = b.trip_id WHERE b.id = $1`, bookingID,
+	).Scan(&operatorID)
+
+	return operatorID, err
+}
+
+func cashAccount(ctx context.Context, tx *sql.Tx, currency string) (int64, error) {
+	return ledgerAccount(ctx, tx, "cash:"+currency, "Cash held at the payment provider", LedgerAccountAsset, currency, nil)
+}
+
+func platformFeeAccount(ctx context.Context, tx *sql.Tx, currency string) (int64, error) {
+	return ledgerAccount(ctx, tx, "platform_fees:"+currency, "Platform fees", LedgerAccountRevenue, currency, nil)
+}
+
+// operatorPayableAccount is what the platform owes the operator, trips
+// without an operator are booked to a shared unassigned account.
+func operatorPayableAccount(ctx context.Context, tx *sql.Tx, operatorID *int64, currency string) (int64, error) {
+	if operatorID == nil {
+		return ledgerAccount(ctx, tx, "operator_payable:unassigned:"+currency, "Owed for trips without an operator", LedgerAccountLiability, currency, nil)
+	}
+
+	code := fmt.Sprintf("operator_payable:%d:%s", *operatorID, currency)
+	name := fmt.Sprintf("Owed to operator %d", *operatorID)
+
+	return ledgerAccount(ctx, tx, code, name, LedgerAccountLiability, currency, operatorID)
+}
+
+// ledgerAccount returns the id of the account with the given code, opening
+// it on first use.
+func ledgerAccount(ctx context.Context, tx *sql.Tx, code, name, accountType, currency string, operatorID *int64) (int64, error) {
+	var id int64
+
+	err := tx.QueryRowContext(
+		ctx,
+		`INSERT INTO ledger_account (code, name, type, currency, operator_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (code) DO UPDATE SET code = EXCLUDED.code
+		RETURNING id`,
+		code, name, accountType, currency, operatorID,
+	).Scan(&id)
+
+	return id, err
+}
+
+func scanLedgerAccounts(rows *sql.Rows) ([]LedgerAccount, error) {
+	var accounts []LedgerAccount
+
+	for rows.Next() {
+		var account LedgerAccount
+		if err := rows.Scan(
+			&account.ID,
+			&account.Code,
+			&account.Name,
+			&account.Type,
+			&account.Balance.Currency,
+			&account.Operator_id,
+			&account.Balance.Amount,
+			&account.Created_at,
+		); err != nil {
+			return nil, err
+		}
+
+		// entries are stored debit positive, credit-normal accounts are
+		// shown the other way round
+		if account.Type == LedgerAccountLiability || account.Type == LedgerAccountRevenue {
+			account.Balance.Amount = -account.Balance.Amount
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
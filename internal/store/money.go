@@ -0,0 +1,109 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrCurrencyMismatch = errors.New("currencies do not match")
+
+// Money is an exact amount in the currency's minor unit (cents for EUR) with
+// its ISO 4217 currency code. Amounts are never floats so sums and
+// percentages don't drift.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// currencyExponents lists the currencies whose minor unit isn't a hundredth,
+// every other currency has two decimals.
+var currencyExponents = map[string]int{
+	"BHD": 3, "CLP": 0, "IQD": 3, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0,
+	"KWD": 3, "LYD": 3, "OMR": 3, "PYG": 0, "TND": 3, "UGX": 0, "VND": 0,
+	"XAF": 0, "XOF": 0,
+}
+
+// CurrencyExponent returns the number of decimals of the currency's minor
+// unit.
+func CurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+
+	return 2
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Mul(n int64) Money {
+	return Money{Amount: m.Amount * n, Currency: m.Currency}
+}
+
+// Percent returns p percent of m rounded half away from zero to the minor
+// unit.
+func (m Money) Percent(p int) Money {
+	return Money{Amount: divRound(m.Amount*int64(p), 100), Currency: m.Currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// String formats m in major units, e.g. "12.50 EUR".
+func (m Money) String() string {
+	exp := CurrencyExponent(m.Currency)
+	if exp == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+
+	unit := int64(1)
+	for i := 0; i < exp; i++ {
+		unit *= 10
+	}
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/unit, exp, amount%unit, m.Currency)
+}
+
+// divRound divides a by b rounding half away from zero, b must be positive.
+func divRound(a, b int64) int64 {
+	if a < 0 {
+		return -((-a + b/2) / b)
+	}
+
+	return (a + b/2) / b
+}
+
+// SplitTax splits a tax-inclusive amount into its net amount and the tax at
+// rate basis points (2000 is 20%), the tax takes the rounding difference so
+// net and tax always add up to m.
+func (m Money) SplitTax(rate int) (net, tax Money) {
+	netAmount := divRound(m.Amount*10000, int64(10000+rate))
+
+	return Money{Amount: netAmount, Currency: m.Currency}, Money{Amount: m.Amount - netAmount, Currency: m.Currency}
+}
@@ -0,0 +1,113 @@
+package store
+
+import "testing"
+
+func TestDivRound(t *testing.T) {
+	tests := []struct {
+		a, b, want int64
+	}{
+		{0, 3, 0},
+		{4, 3, 1},
+		{5, 3, 2},
+		{1, 3, 0},
+		{5, 2, 3},
+		{7, 2, 4},
+		{2, 4, 1},
+		{-1, 3, 0},
+		{-4, 3, -1},
+		{-5, 3, -2},
+		{-5, 2, -3},
+		{-1, 2, -1},
+		{-2, 4, -1},
+	}
+
+	for _, tt := range tests {
+		if got := divRound(tt.a, tt.b); got != tt.want {
+			t.Errorf("divRound(%d, %d) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyPercent(t *testing.T) {
+	tests := []struct {
+		amount  int64
+		percent int
+		want    int64
+	}{
+		{1050, 10, 105},
+		{1050, 100, 1050},
+		{1050, 0, 0},
+		{1234, 33, 407},
+		// half a cent rounds away from zero
+		{5, 10, 1},
+		{25, 2, 1},
+		{15, 10, 2},
+		{-5, 10, -1},
+		{-25, 2, -1},
+		{-1234, 33, -407},
+		{4, 10, 0},
+		{-4, 10, 0},
+	}
+
+	for _, tt := range tests {
+		got := NewMoney(tt.amount, "eur").Percent(tt.percent)
+		if got.Amount != tt.want || got.Currency != "EUR" {
+			t.Errorf("%d.Percent(%d) = %v, want %d EUR", tt.amount, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestMoneySplitTax(t *testing.T) {
+	tests := []struct {
+		amount int64
+		rate   int
+		net    int64
+		tax    int64
+	}{
+		{1200, 2000, 1000, 200},
+		{100, 2000, 83, 17},
+		{1000, 0, 1000, 0},
+		{0, 2000, 0, 0},
+		{-1200, 2000, -1000, -200},
+		{-100, 2000, -83, -17},
+		// a net of exactly half a unit rounds away from zero
+		{1, 10000, 1, 0},
+		{3, 10000, 2, 1},
+		{-1, 10000, -1, 0},
+		{-3, 10000, -2, -1},
+		{999, 700, 934, 65},
+	}
+
+	for _, tt := range tests {
+		m := NewMoney(tt.amount, "EUR")
+		net, tax := m.SplitTax(tt.rate)
+
+		if net.Amount != tt.net || tax.Amount != tt.tax {
+			t.Errorf("%d.SplitTax(%d) = %d, %d, want %d, %d", tt.amount, tt.rate, net.Amount, tax.Amount, tt.net, tt.tax)
+		}
+
+		if net.Amount+tax.Amount != m.Amount {
+			t.Errorf("%d.SplitTax(%d) doesn't add up: %d + %d", tt.amount, tt.rate, net.Amount, tax.Amount)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{NewMoney(1250, "eur"), "12.50 EUR"},
+		{NewMoney(5, "EUR"), "0.05 EUR"},
+		{NewMoney(-1250, "EUR"), "-12.50 EUR"},
+		{NewMoney(-5, "EUR"), "-0.05 EUR"},
+		{NewMoney(1500, "JPY"), "1500 JPY"},
+		{NewMoney(1234, "KWD"), "1.234 KWD"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
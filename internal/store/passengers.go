@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Passenger struct {
+	ID            int64  `json:"id"`
+	Booking_id    int64  `json:"booking_id"`
+	Full_name     string `json:"full_name"`
+	Date_of_birth string `json:"date_of_birth"`
+	Id_document   string `json:"id_document"`
+	Special_needs string `json:"special_needs"`
+	Created_at    string `json:"created_at"`
+}
+
+// ManifestEntry is a passenger travelling on a trip together with the
+// booking they travel under.
+type ManifestEntry struct {
+	Passenger
+	Booking_status string `json:"booking_status"`
+	Booked_by      string `json:"booked_by"`
+	// Boards_at and Alights_at name the stops of the booking's segment, nil
+	// for the first and the last stop
+	Boards_at  *string `json:"boards_at"`
+	Alights_at *string `json:"alights_at"`
+}
+
+type PassengerStore struct {
+	db *sql.DB
+}
+
+// GetManifestByTripID lists every passenger on the trip's bookings that are
+// not cancelled, ordered by name.
+func (s *PassengerStore) GetManifestByTripID(ctx context.Context, tripID int64) ([]ManifestEntry, error) {
+	query := `
+	SELECT p.id, p.booking_id, p.full_name, to_char(p.date_of_birth, 'YYYY-MM-DD'), p.id_document, p.special_needs, p.created_at,
+	b.status, u.email, boards.name, alights.name
+	FROM passenger p
+	JOIN booking b ON b.id = p.booking_id
+	JOIN "user" u ON u.id = b.user_id
+	LEFT JOIN trip_stop boards ON boards.id = b.from_stop_id
+	LEFT JOIN trip_stop alights ON alights.id = b.to_stop_id
+	WHERE b.trip_id = $1 AND b.status <> 'cancelled'
+	ORDER BY p.full_name, p.id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, tripID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var manifest []ManifestEntry
+
+	for rows.Next() {
+		var entry ManifestEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.Booking_id,
+			&entry.Full_name,
+			&entry.Date_of_birth,
+			&entry.Id_document,
+			&entry.Special_needs,
+			&entry.Created_at,
+			&entry.Booking_status,
+			&entry.Booked_by,
+			&entry.Boards_at,
+			&entry.Alights_at,
+		); err != nil {
+			return nil, err
+		}
+		manifest = append(manifest, entry)
+	}
+
+	return manifest, nil
+}
+
+func createPassenger(ctx context.Context, tx *sql.Tx, passenger *Passenger) error {
+	query := `INSERT INTO passenger (booking_id, full_name, date_of_birth, id_document, special_needs)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	return tx.QueryRowContext(
+		ctx,
+		query,
+		passenger.Booking_id,
+		passenger.Full_name,
+		passenger.Date_of_birth,
+		passenger.Id_document,
+		passenger.Special_needs,
+	).Scan(&passenger.ID, &passenger.Created_at)
+}
+
+func getPassengersByBookingID(ctx context.Context, db *sql.DB, bookingID int64) ([]Passenger, error) {
+	query := `SELECT id, booking_id, full_name, to_char(date_of_birth, 'YYYY-MM-DD'), id_document, special_needs, created_at
+	FROM passenger
+	WHERE booking_id = $1
+	ORDER BY id`
+
+	rows, err := db.QueryContext(ctx, query, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passengers []Passenger
+
+	for rows.Next() {
+		var passenger Passenger
+		if err := rows.Scan(
+			&passenger.ID,
+			&passenger.Booking_id,
+			&passenger.Full_name,
+			&passenger.Date_of_birth,
+			&passenger.Id_document,
+			&passenger.Special_needs,
+			&passenger.Created_at,
+		); err != nil {
+			return nil, err
+		}
+		passengers = append(passengers, passenger)
+	}
+
+	return passengers, nil
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+var ErrDuplicateEvent = errors.New("payment event has already been processed")
+
+// PaymentEvent is a raw notification received from the payment provider.
+// Every event is kept, Processed_at is set once it has been applied and Error
+// holds the reason the last attempt failed or the event was skipped.
+type PaymentEvent struct {
+	ID           int64           `json:"id"`
+	Event_id     string          `json:"event_id"`
+	Type         string          `json:"type"`
+	Payload      json.RawMessage `json:"payload"`
+	Received_at  string          `json:"received_at"`
+	Processed_at *string         `json:"processed_at"`
+	Error        *string         `json:"error"`
+}
+
+type PaymentEventStore struct {
+	db  *sql.DB
+	cfg Config
+}
+
+// Record stores the raw event, an event that was already received keeps its
+// original row and the row is loaded into event.
+func (s *PaymentEventStore) Record(ctx context.Context, event *PaymentEvent) error {
+	query := `INSERT INTO payment_event (event_id, type, payload)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (event_id) DO UPDATE SET event_id = EXCLUDED.event_id
+	RETURNING id, received_at, processed_at, error`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(ctx, query, event.Event_id, event.Type, []byte(event.Payload)).Scan(
+		&event.ID,
+		&event.Received_at,
+		&event.Processed_at,
+		&event.Error,
+	)
+}
+
+// SettlePayment applies a payment outcome reported by the event, the event
+// is marked processed in the same transaction so a replay is rejected with
+// ErrDuplicateEvent instead of being applied twice.
+func (s *PaymentEventStore) SettlePayment(ctx context.Context, eventID, transactionID, status string) (*PaymentSettlement, error) {
+	var settlement *PaymentSettlement
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if err := lockUnprocessedEvent(ctx, tx, eventID); err != nil {
+			return err
+		}
+
+		var err error
+		settlement, err = settlePayment(ctx, tx, s.cfg, transactionID, status)
+		if err != nil {
+			return err
+		}
+
+		return markEventProcessed(ctx, tx, eventID, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return settlement, nil
+}
+
+// SettleRefund applies a refund outcome reported by the event. Only pending
+// refunds change, a late event can't flip a refund that already settled.
+func (s *PaymentEventStore) SettleRefund(ctx context.Context, eventID, transactionID, status string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if err := lockUnprocessedEvent(ctx, tx, eventID); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(
+			ctx,
+			`UPDATE refund SET status = $1 WHERE transaction_id = $2 AND status = 'pending' RETURNING id`,
+			status,
+			transactionID,
+		)
+		if err != nil {
+			return err
+		}
+
+		var settled []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			settled = append(settled, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if status == RefundStatusComplete {
+			for _, id := range settled {
+				if err := completeRefund(ctx, tx, s.cfg, id); err != nil {
+					return err
+				}
+			}
+		}
+
+		return markEventProcessed(ctx, tx, eventID, nil)
+	})
+}
+
+// Skip marks an event the service has no use for as processed.
+func (s *PaymentEventStore) Skip(ctx context.Context, eventID, reason string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if err := lockUnprocessedEvent(ctx, tx, eventID); err != nil {
+			return err
+		}
+
+		return markEventProcessed(ctx, tx, eventID, &reason)
+	})
+}
+
+// MarkFailed records why processing the event failed, it stays unprocessed
+// so the provider's retry applies it.
+func (s *PaymentEventStore) MarkFailed(ctx context.Context, eventID, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE payment_event SET error = $1 WHERE event_id = $2`, reason, eventID)
+
+	return err
+}
+
+func lockUnprocessedEvent(ctx context.Context, tx *sql.Tx, eventID string) error {
+	var processed bool
+
+	err := tx.QueryRowContext(
+		ctx, `SELECT processed_at IS NOT NULL FROM payment_event WHERE event_id = $1 FOR UPDATE`, eventID,
+	).Scan(&processed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if processed {
+		return ErrDuplicateEvent
+	}
+
+	return nil
+}
+
+func markEventProcessed(ctx context.Context, tx *sql.Tx, eventID string, reason *string) error {
+	_, err := tx.ExecContext(
+		ctx, `UPDATE payment_event SET processed_at = NOW(), error = $1 WHERE event_id = $2`, reason, eventID,
+	)
+
+	return err
+}
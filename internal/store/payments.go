@@ -3,44 +3,106 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 )
 
 type Payment struct {
-	ID             int64   `json:"id"`
-	Booking_id     int64   `json:"booking_id"`
-	User_id        int64   `json:"user_id"`
-	Amount         float64 `json:"amount"`
-	Status         string  `json:"status"`
-	Transaction_id string  `json:"transation_id"`
-	Created_at     string  `json:"created_at"`
+	ID             int64  `json:"id"`
+	Booking_id     int64  `json:"booking_id"`
+	User_id        int64  `json:"user_id"`
+	Amount         Money  `json:"amount"`
+	Status         string `json:"status"`
+	Transaction_id string `json:"transation_id"`
+	Created_at     string `json:"created_at"`
 }
 
 type PaymentStore struct {
-	db *sql.DB
+	db  *sql.DB
+	cfg Config
 }
 
+// Create records the payment and issues its invoice. It fails with
+// ErrCurrencyMismatch when the payment isn't in the currency of the trip it
+// pays for and with ErrPriceChanged when it no longer covers the booking.
 func (s *PaymentStore) Create(ctx context.Context, payment *Payment) error {
-	query := `INSERT INTO payment (booking_id, user_id, amount, status, transaction_id)
-	VALUES ($1, $2, $3, $4, $5)
-	RETURING id, created_at
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var tripCurrency string
+
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT t.currency FROM booking b JOIN trip t ON t.id = b.trip_id WHERE b.id = $1`,
+			payment.Booking_id,
+		).Scan(&tripCurrency)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		if payment.Amount.Currency != tripCurrency {
+			return fmt.Errorf("%w: payment in %s for a trip priced in %s", ErrCurrencyMismatch, payment.Amount.Currency, tripCurrency)
+		}
+
+		query := `INSERT INTO payment (booking_id, user_id, amount, currency, status, transaction_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+		`
+
+		err = tx.QueryRowContext(
+			ctx, query, payment.Booking_id, payment.User_id, payment.Amount.Amount, payment.Amount.Currency, payment.Status, payment.Transaction_id,
+		).Scan(
+			&payment.ID, &payment.Created_at,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = issueInvoice(ctx, tx, s.cfg, payment)
+
+		return err
+	})
+}
+
+func (s *PaymentStore) GetByID(ctx context.Context, paymentID int64) (*Payment, error) {
+	query := `
+	SELECT id, booking_id, user_id, amount, currency, status, transaction_id, created_at
+	FROM payment
+	WHERE id = $1
 	`
+
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(
-		ctx, query, payment.Booking_id, payment.User_id, payment.Amount, payment.Status, payment.Transaction_id,
-	).Scan(
-		&payment.ID, &payment.Created_at,
+	payment := &Payment{}
+
+	err := s.db.QueryRowContext(ctx, query, paymentID).Scan(
+		&payment.ID,
+		&payment.Booking_id,
+		&payment.User_id,
+		&payment.Amount.Amount,
+		&payment.Amount.Currency,
+		&payment.Status,
+		&payment.Transaction_id,
+		&payment.Created_at,
 	)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
 	}
-	return nil
+
+	return payment, nil
 }
 
 func (s *PaymentStore) GetByUserID(ctx context.Context, userId int64) ([]Payment, error) {
 	query := `
-	SELECT id, booking_id, user_id, amount, status, transaction_id, created_at
+	SELECT id, booking_id, user_id, amount, currency, status, transaction_id, created_at
 	FROM payment
 	WHERE user_id = $1
 	`
@@ -59,13 +121,14 @@ func (s *PaymentStore) GetByUserID(ctx context.Context, userId int64) ([]Payment
 	for rows.Next() {
 		var payment Payment
 		if err := rows.Scan(
-			payment.ID,
-			payment.Booking_id,
-			payment.User_id,
-			payment.Amount,
-			payment.Status,
-			payment.Transaction_id,
-			payment.Created_at,
+			&payment.ID,
+			&payment.Booking_id,
+			&payment.User_id,
+			&payment.Amount.Amount,
+			&payment.Amount.Currency,
+			&payment.Status,
+			&payment.Transaction_id,
+			&payment.Created_at,
 		); err != nil {
 			return nil, err
 		}
@@ -78,6 +141,46 @@ func (s *PaymentStore) GetByUserID(ctx context.Context, userId int64) ([]Payment
 
 }
 
+func (s *PaymentStore) GetByBookingID(ctx context.Context, bookingID int64) ([]Payment, error) {
+	query := `
+	SELECT id, booking_id, user_id, amount, currency, status, transaction_id, created_at
+	FROM payment
+	WHERE booking_id = $1
+	ORDER BY created_at, id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+
+	for rows.Next() {
+		var payment Payment
+		if err := rows.Scan(
+			&payment.ID,
+			&payment.Booking_id,
+			&payment.User_id,
+			&payment.Amount.Amount,
+			&payment.Amount.Currency,
+			&payment.Status,
+			&payment.Transaction_id,
+			&payment.Created_at,
+		); err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
+
 func (s *PaymentStore) UpdateByID(ctx context.Context, payment *Payment) error {
 	query := `
 	UPDATE payment SET  status = $1
@@ -93,3 +196,156 @@ func (s *PaymentStore) UpdateByID(ctx context.Context, payment *Payment) error {
 	}
 	return nil
 }
+
+// PaymentSettlement is what settling a payment changed. Applied is false when
+// the payment had already left pending and nothing was changed. Refund is set
+// when the payment succeeded for a booking that could no longer be confirmed
+// and has to be returned.
+type PaymentSettlement struct {
+	Payment *Payment `json:"payment"`
+	Booking *Booking `json:"booking"`
+	Refund  *Refund  `json:"refund"`
+	Applied bool     `json:"applied"`
+}
+
+// Settle records the final status the provider reported for the payment with
+// the given transaction id, see settlePayment.
+func (s *PaymentStore) Settle(ctx context.Context, transactionID, status string) (*PaymentSettlement, error) {
+	var settlement *PaymentSettlement
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var err error
+		settlement, err = settlePayment(ctx, tx, s.cfg, transactionID, status)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return settlement, nil
+}
+
+// settlePayment moves a pending payment to complete or failed. A completed
+// payment marks its invoice paid and confirms its pending booking, when the
+// booking was cancelled or its hold ran out the payment is queued for a full
+// refund instead so the booking is never brought back. Payments that already
+// left pending are not touched, which makes late or repeated outcomes no-ops.
+func settlePayment(ctx context.Context, tx *sql.Tx, cfg Config, transactionID, status string) (*PaymentSettlement, error) {
+	payment := &Payment{}
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT id, booking_id, user_id, amount, currency, status, transaction_id, created_at
+		FROM payment
+		WHERE transaction_id = $1
+		FOR UPDATE`,
+		transactionID,
+	).Scan(
+		&payment.ID,
+		&payment.Booking_id,
+		&payment.User_id,
+		&payment.Amount.Amount,
+		&payment.Amount.Currency,
+		&payment.Status,
+		&payment.Transaction_id,
+		&payment.Created_at,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	settlement := &PaymentSettlement{Payment: payment}
+
+	if payment.Status != PaymentStatusPending || (status != PaymentStatusComplete && status != PaymentStatusFailed) {
+		return settlement, nil
+	}
+
+	settlement.Applied = true
+	payment.Status = status
+
+	if _, err := tx.ExecContext(ctx, `UPDATE payment SET status = $1 WHERE id = $2`, status, payment.ID); err != nil {
+		return nil, err
+	}
+
+	if status == PaymentStatusFailed {
+		_, err := tx.ExecContext(
+			ctx, `UPDATE invoice SET status = 'void' WHERE payment_id = $1 AND status IN ('unpaid', 'overdue')`, payment.ID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return settlement, nil
+	}
+
+	if err := postPaymentCapture(ctx, tx, cfg, payment); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx, `UPDATE invoice SET status = 'paid' WHERE payment_id = $1 AND status IN ('unpaid', 'overdue')`, payment.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookingStatus string
+
+	err = tx.QueryRowContext(ctx, `SELECT status FROM booking WHERE id = $1`, payment.Booking_id).Scan(&bookingStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	var reason string
+
+	switch bookingStatus {
+	case BookingStatusPending:
+		settlement.Booking, err = transitionBooking(ctx, tx, &BookingStatusChange{
+			Booking_id: payment.Booking_id,
+			To_status:  BookingStatusConfirmed,
+			Reason:     "payment captured",
+		})
+		switch {
+		case err == nil:
+			return settlement, nil
+		case errors.Is(err, ErrHoldExpired):
+			reason = "payment arrived after the booking hold expired"
+		default:
+			return nil, err
+		}
+	case BookingStatusCancelled:
+		reason = "payment arrived for a cancelled booking"
+	default:
+		// confirmed by other means already
+		return settlement, nil
+	}
+
+	settlement.Refund = &Refund{
+		Payment_id:     payment.ID,
+		Booking_id:     payment.Booking_id,
+		Amount:         payment.Amount,
+		Refund_percent: 100,
+		Reason:         reason,
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		`INSERT INTO refund (payment_id, booking_id, amount, currency, refund_percent, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, status, created_at`,
+		payment.ID, payment.Booking_id, payment.Amount.Amount, payment.Amount.Currency, 100, reason,
+	).Scan(&settlement.Refund.ID, &settlement.Refund.Status, &settlement.Refund.Created_at)
+	if err != nil {
+		return nil, err
+	}
+
+	// the payment reads refunded once the provider completes the refund
+	return settlement, nil
+}
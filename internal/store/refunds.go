@@ -0,0 +1,323 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+const (
+	PaymentStatusPending           = "pending"
+	PaymentStatusComplete          = "complete"
+	PaymentStatusFailed            = "failed"
+	PaymentStatusPartiallyRefunded = "partially_refunded"
+	PaymentStatusRefunded          = "refunded"
+
+	RefundStatusPending  = "pending"
+	RefundStatusComplete = "complete"
+	RefundStatusFailed   = "failed"
+)
+
+type Refund struct {
+	ID             int64   `json:"id"`
+	Payment_id     int64   `json:"payment_id"`
+	Booking_id     int64   `json:"booking_id"`
+	Amount         Money   `json:"amount"`
+	Refund_percent int     `json:"refund_percent"`
+	Status         string  `json:"status"`
+	Reason         string  `json:"reason"`
+	Transaction_id *string `json:"transaction_id"`
+	Created_at     string  `json:"created_at"`
+}
+
+// Cancellation is the outcome of cancelling a booking, the refunds that were
+// issued under the trip's policy for Days_before days notice.
+type Cancellation struct {
+	Booking        *Booking `json:"booking"`
+	Policy_id      *int64   `json:"policy_id"`
+	Days_before    int      `json:"days_before"`
+	Refund_percent int      `json:"refund_percent"`
+	Refunds        []Refund `json:"refunds"`
+}
+
+type RefundStore struct {
+	db  *sql.DB
+	cfg Config
+}
+
+func (s *RefundStore) GetByBookingID(ctx context.Context, bookingID int64) ([]Refund, error) {
+	query := `SELECT id, payment_id, booking_id, amount, currency, refund_percent, status, reason, transaction_id, created_at
+	FROM refund
+	WHERE booking_id = $1
+	ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []Refund
+
+	for rows.Next() {
+		var refund Refund
+		if err := rows.Scan(
+			&refund.ID,
+			&refund.Payment_id,
+			&refund.Booking_id,
+			&refund.Amount.Amount,
+			&refund.Amount.Currency,
+			&refund.Refund_percent,
+			&refund.Status,
+			&refund.Reason,
+			&refund.Transaction_id,
+			&refund.Created_at,
+		); err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, refund)
+	}
+
+	return refunds, rows.Err()
+}
+
+// Create records a refund outside of a cancellation, e.g. when a payment
+// has to be returned because its booking could not be confirmed.
+func (s *RefundStore) Create(ctx context.Context, refund *Refund) error {
+	query := `INSERT INTO refund (payment_id, booking_id, amount, currency, refund_percent, status, reason, transaction_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		refund.Payment_id,
+		refund.Booking_id,
+		refund.Amount.Amount,
+		refund.Amount.Currency,
+		refund.Refund_percent,
+		refund.Status,
+		refund.Reason,
+		refund.Transaction_id,
+	).Scan(&refund.ID, &refund.Created_at)
+}
+
+// UpdateByID stores the outcome the payment provider reported for the
+// refund, see completeRefund for what a completed refund books.
+func (s *RefundStore) UpdateByID(ctx context.Context, refund *Refund) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `UPDATE refund SET status = $1, transaction_id = $2 WHERE id = $3`
+
+		res, err := tx.ExecContext(ctx, query, refund.Status, refund.Transaction_id, refund.ID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrNotFound
+		}
+
+		if refund.Status == RefundStatusComplete {
+			return completeRefund(ctx, tx, s.cfg, refund.ID)
+		}
+
+		return nil
+	})
+}
+
+// completeRefund books a refund the payment provider completed: the payment
+// and its invoice are marked refunded or partially refunded, it is posted to
+// the ledger and credited against the payment's invoice.
+func completeRefund(ctx context.Context, tx *sql.Tx, cfg Config, refundID int64) error {
+	var (
+		paymentID int64
+		status    string
+	)
+
+	// only completed refunds count, a pending or failed one hasn't paid the
+	// customer back
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT p.id, CASE WHEN SUM(r.amount) >= p.amount THEN 'refunded' ELSE 'partially_refunded' END
+		FROM payment p
+		JOIN refund r ON r.payment_id = p.id AND r.status = 'complete'
+		WHERE p.id = (SELECT payment_id FROM refund WHERE id = $1)
+		GROUP BY p.id`,
+		refundID,
+	).Scan(&paymentID, &status)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE payment SET status = $1 WHERE id = $2`, status, paymentID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE invoice SET status = $1 WHERE payment_id = $2`, status, paymentID); err != nil {
+		return err
+	}
+
+	if err := postRefund(ctx, tx, refundID); err != nil {
+		return err
+	}
+
+	return creditRefund(ctx, tx, cfg, refundID)
+}
+
+// Cancel cancels the booking and refunds its completed payments as the
+// trip's cancellation policy allows. The payments and their invoices are
+// marked refunded or partially refunded once the refunds complete.
+func (s *BookingStore) Cancel(ctx context.Context, change *BookingStatusChange) (*Cancellation, error) {
+	var cancellation *Cancellation
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var err error
+		cancellation, err = cancelBooking(ctx, tx, change)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cancellation, nil
+}
+
+func cancelBooking(ctx context.Context, tx *sql.Tx, change *BookingStatusChange) (*Cancellation, error) {
+	cancellation := &Cancellation{}
+
+	change.To_status = BookingStatusCancelled
+
+	booking, err := transitionBooking(ctx, tx, change)
+	if err != nil {
+		return nil, err
+	}
+	cancellation.Booking = booking
+
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT cancellation_policy_id, start_date - CURRENT_DATE FROM trip WHERE id = $1`,
+		booking.Trip_id,
+	).Scan(&cancellation.Policy_id, &cancellation.Days_before)
+	if err != nil {
+		return nil, err
+	}
+
+	if cancellation.Policy_id == nil {
+		return cancellation, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, policyRulesQuery, *cancellation.Policy_id)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &CancellationPolicy{ID: *cancellation.Policy_id}
+	policy.Rules, err = scanPolicyRules(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	cancellation.Refund_percent = policy.RefundPercent(cancellation.Days_before)
+	if cancellation.Refund_percent == 0 {
+		return cancellation, nil
+	}
+
+	cancellation.Refunds, err = refundBookingPayments(ctx, tx, booking.ID, cancellation.Refund_percent, change.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	return cancellation, nil
+}
+
+// refundBookingPayments refunds percent of every completed payment of the
+// booking, never more than what is left unrefunded on the payment.
+func refundBookingPayments(ctx context.Context, tx *sql.Tx, bookingID int64, percent int, reason string) ([]Refund, error) {
+	query := `
+	SELECT p.id, p.amount, p.currency, COALESCE(SUM(r.amount) FILTER (WHERE r.status <> 'failed'), 0)
+	FROM payment p
+	LEFT JOIN refund r ON r.payment_id = p.id
+	WHERE p.booking_id = $1 AND p.status IN ('complete', 'partially_refunded')
+	GROUP BY p.id
+	ORDER BY p.id
+	`
+
+	rows, err := tx.QueryContext(ctx, query, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	type refundable struct {
+		paymentID int64
+		amount    Money
+		refunded  int64
+	}
+
+	var payments []refundable
+
+	for rows.Next() {
+		var p refundable
+		if err := rows.Scan(&p.paymentID, &p.amount.Amount, &p.amount.Currency, &p.refunded); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var refunds []Refund
+
+	for _, p := range payments {
+		amount := p.amount.Percent(percent)
+		if left := p.amount.Amount - p.refunded; amount.Amount > left {
+			amount.Amount = left
+		}
+		if amount.Amount <= 0 {
+			continue
+		}
+
+		refund := Refund{
+			Payment_id:     p.paymentID,
+			Booking_id:     bookingID,
+			Amount:         amount,
+			Refund_percent: percent,
+			Reason:         reason,
+		}
+
+		err := tx.QueryRowContext(
+			ctx,
+			`INSERT INTO refund (payment_id, booking_id, amount, currency, refund_percent, reason)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, status, created_at`,
+			refund.Payment_id, refund.Booking_id, refund.Amount.Amount, refund.Amount.Currency, refund.Refund_percent, refund.Reason,
+		).Scan(&refund.ID, &refund.Status, &refund.Created_at)
+		if err != nil {
+			return nil, err
+		}
+
+		refunds = append(refunds, refund)
+	}
+
+	return refunds, nil
+}
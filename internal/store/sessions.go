@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+)
+
+type Session struct {
+	ID         int64   `json:"id"`
+	User_id    int64   `json:"user_id"`
+	Created_at string  `json:"created_at"`
+	Revoked_at *string `json:"revoked_at"`
+}
+
+type RefreshToken struct {
+	ID         int64     `json:"id"`
+	Session_id int64     `json:"session_id"`
+	Token_hash string    `json:"-"`
+	Expires_at time.Time `json:"expires_at"`
+	Used_at    *string   `json:"used_at"`
+	Created_at string    `json:"created_at"`
+}
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+// Create starts a new session and stores the first refresh token of its
+// family.
+func (s *SessionStore) Create(ctx context.Context, session *Session, token *RefreshToken) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `INSERT INTO session (user_id) VALUES ($1) RETURNING id, created_at`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, session.User_id).Scan(&session.ID, &session.Created_at)
+		if err != nil {
+			return err
+		}
+
+		token.Session_id = session.ID
+
+		return createRefreshToken(ctx, tx, token)
+	})
+}
+
+func (s *SessionStore) GetByID(ctx context.Context, sessionID int64) (*Session, error) {
+	query := `SELECT id, user_id, created_at, revoked_at FROM session WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	session := &Session{}
+
+	err := s.db.QueryRowContext(ctx, query, sessionID).Scan(
+		&session.ID,
+		&session.User_id,
+		&session.Created_at,
+		&session.Revoked_at,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Rotate exchanges the refresh token with the given hash for next, which
+// joins the same family. Presenting a token that was already rotated is
+// treated as theft and revokes the whole family.
+func (s *SessionStore) Rotate(ctx context.Context, tokenHash string, next *RefreshToken) (*Session, error) {
+	session := &Session{}
+	reused := false
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+		SELECT rt.id, rt.used_at IS NOT NULL, rt.expires_at < NOW(), s.id, s.user_id, s.created_at, s.revoked_at
+		FROM refresh_token rt
+		JOIN session s ON s.id = rt.session_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt, s
+		`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var (
+			tokenID int64
+			used    bool
+			expired bool
+		)
+
+		err := tx.QueryRowContext(ctx, query, tokenHash).Scan(
+			&tokenID,
+			&used,
+			&expired,
+			&session.ID,
+			&session.User_id,
+			&session.Created_at,
+			&session.Revoked_at,
+		)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		switch {
+		case session.Revoked_at != nil:
+			return ErrSessionRevoked
+		case used:
+			// the revocation has to be committed, so the error is only
+			// reported once the transaction is done
+			reused = true
+			return revokeSession(ctx, tx, session.ID)
+		case expired:
+			return ErrRefreshTokenExpired
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE refresh_token SET used_at = NOW() WHERE id = $1`, tokenID)
+		if err != nil {
+			return err
+		}
+
+		next.Session_id = session.ID
+
+		return createRefreshToken(ctx, tx, next)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+
+	return session, nil
+}
+
+func (s *SessionStore) RevokeByID(ctx context.Context, sessionID int64) error {
+	query := `UPDATE session SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, sessionID)
+
+	return err
+}
+
+// RevokeByUserID kills every active session of the user and returns how many
+// were revoked.
+func (s *SessionStore) RevokeByUserID(ctx context.Context, userID int64) (int64, error) {
+	query := `UPDATE session SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func createRefreshToken(ctx context.Context, tx *sql.Tx, token *RefreshToken) error {
+	query := `INSERT INTO refresh_token (session_id, token_hash, expires_at)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at`
+
+	return tx.QueryRowContext(ctx, query, token.Session_id, token.Token_hash, token.Expires_at).Scan(
+		&token.ID,
+		&token.Created_at,
+	)
+}
+
+func revokeSession(ctx context.Context, tx *sql.Tx, sessionID int64) error {
+	query := `UPDATE session SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+
+	_, err := tx.ExecContext(ctx, query, sessionID)
+
+	return err
+}
@@ -13,6 +13,23 @@ var (
 	QueryTimeoutDuration = time.Second * 5
 )
 
+// Config holds the business settings the stores apply.
+type Config struct {
+	// BookingHoldDuration is how long a pending booking keeps its seat
+	// before it is released back to the trip.
+	BookingHoldDuration time.Duration
+	// PlatformFeePercent is the share of every captured payment the platform
+	// keeps, the rest is owed to the trip's operator.
+	PlatformFeePercent int
+	// InvoiceDueDays is how long after issuing an unpaid invoice falls due.
+	InvoiceDueDays int
+	// DefaultTaxRate is the tax included in prices, in basis points.
+	DefaultTaxRate int
+	// TripScheduleHorizonDays is how far ahead the departures of trip
+	// templates are generated.
+	TripScheduleHorizonDays int
+}
+
 type Storage struct {
 	Users interface {
 		Create(context.Context, *User) error
@@ -20,25 +37,51 @@ type Storage struct {
 		GetByEmail(context.Context, string) (*User, error)
 		UpdateByID(context.Context, *User) error
 		DeleteByID(context.Context, int64) error
+		Verify(context.Context, string) (int64, error)
+		ResetPassword(context.Context, string, string) (int64, error)
 	}
 	Trips interface {
 		Create(context.Context, *Trip) error
 		GetByID(context.Context, int64) (*Trip, error)
 		GetByLocation(context.Context, string) ([]Trip, error)
 		GetUpcoming(context.Context) ([]Trip, error)
-		GetAll(context.Context) ([]Trip, error)
+		Search(context.Context, TripSearch) ([]TripResult, *TripCursor, error)
+		Nearby(context.Context, string, GeoSearch) ([]TripDistance, error)
 		UpdateByID(context.Context, *Trip) error
 	}
+	TripTemplates interface {
+		Create(context.Context, *TripTemplate) error
+		GetByID(context.Context, int64) (*TripTemplate, error)
+		GetByOperatorID(context.Context, int64) ([]TripTemplate, error)
+		GetActive(context.Context) ([]TripTemplate, error)
+		GetDepartures(context.Context, int64) ([]Trip, error)
+		Generate(context.Context, int64) (int, error)
+		Update(context.Context, *TripTemplate, *int64) (*TripTemplateUpdate, error)
+		CancelDepartures(context.Context, int64, string, string, string, *int64) (*DepartureCancellation, error)
+	}
+	TripStops interface {
+		GetByTripID(context.Context, int64) ([]TripStop, error)
+		Replace(context.Context, int64, []TripStop) error
+		Availability(context.Context, int64, *int64, *int64) (int, error)
+	}
 	Bookings interface {
 		Create(context.Context, *Booking) error
 		GetByID(context.Context, int64) (*Booking, error)
 		GetByTripID(context.Context, int64) ([]Booking, error)
 		GetByUserID(context.Context, int64) ([]Booking, error)
-		UpdateByID(context.Context, *Booking) error
+		Transition(context.Context, *BookingStatusChange) (*Booking, error)
+		GetHistory(context.Context, int64) ([]BookingStatusChange, error)
+		ExpireHolds(context.Context) ([]Booking, error)
+		Cancel(context.Context, *BookingStatusChange) (*Cancellation, error)
+		CancelForOverdueInvoice(context.Context, int64, string) (*Cancellation, error)
+		Quote(context.Context, int64) (*Quote, error)
 	}
 	Payments interface {
 		Create(context.Context, *Payment) error
+		GetByID(context.Context, int64) (*Payment, error)
 		GetByUserID(context.Context, int64) ([]Payment, error)
+		GetByBookingID(context.Context, int64) ([]Payment, error)
+		Settle(context.Context, string, string) (*PaymentSettlement, error)
 		UpdateByID(context.Context, *Payment) error
 	}
 	Subscriptions interface {
@@ -48,9 +91,14 @@ type Storage struct {
 		DeleteByUserID(context.Context, int64) error
 	}
 	Invoices interface {
-		Create(context.Context, *Invoice) error
-		UpdateByInvoiceNumber(context.Context, *Invoice) error
+		Issue(context.Context, int64) (*Invoice, error)
 		GetByInvoiceNumber(context.Context, string) (*Invoice, error)
+		GetByUserID(context.Context, int64) ([]Invoice, error)
+		GetByBookingID(context.Context, int64) ([]Invoice, error)
+		MarkOverdue(context.Context) (int64, error)
+		RecordReminders(context.Context, []int) ([]InvoiceReminder, error)
+		DeleteReminder(context.Context, int64) error
+		GetOverdueSince(context.Context, int) ([]Invoice, error)
 	}
 	Comments interface {
 		Create(context.Context, *Comment) error
@@ -70,6 +118,7 @@ type Storage struct {
 		Create(context.Context, *Accomodation) error
 		GetByID(context.Context, int64) (*Accomodation, error)
 		GetByTripID(context.Context, int64) ([]Accomodation, error)
+		Nearby(context.Context, GeoSearch) ([]AccomodationDistance, error)
 		UpdateByID(context.Context, *Accomodation) error
 	}
 	AccomodationPhotos interface {
@@ -93,23 +142,114 @@ type Storage struct {
 		DeleteById(context.Context, int64) error
 		DeleteByActivityId(context.Context, int64) error
 	}
+	Roles interface {
+		GetByName(context.Context, string) (*Role, error)
+	}
+	Sessions interface {
+		Create(context.Context, *Session, *RefreshToken) error
+		GetByID(context.Context, int64) (*Session, error)
+		Rotate(context.Context, string, *RefreshToken) (*Session, error)
+		RevokeByID(context.Context, int64) error
+		RevokeByUserID(context.Context, int64) (int64, error)
+	}
+	UserTokens interface {
+		Create(context.Context, *UserToken) error
+	}
+	Passengers interface {
+		GetManifestByTripID(context.Context, int64) ([]ManifestEntry, error)
+	}
+	CancellationPolicies interface {
+		Create(context.Context, *CancellationPolicy) error
+		GetByID(context.Context, int64) (*CancellationPolicy, error)
+		GetAll(context.Context) ([]CancellationPolicy, error)
+	}
+	Refunds interface {
+		Create(context.Context, *Refund) error
+		GetByBookingID(context.Context, int64) ([]Refund, error)
+		UpdateByID(context.Context, *Refund) error
+	}
+	PaymentEvents interface {
+		Record(context.Context, *PaymentEvent) error
+		SettlePayment(context.Context, string, string, string) (*PaymentSettlement, error)
+		SettleRefund(context.Context, string, string, string) error
+		Skip(context.Context, string, string) error
+		MarkFailed(context.Context, string, string) error
+	}
+	IdempotencyKeys interface {
+		Begin(context.Context, *IdempotencyKey) (bool, error)
+		Complete(context.Context, *IdempotencyKey) error
+		Release(context.Context, int64) error
+		DeleteExpired(context.Context, time.Duration) (int64, error)
+	}
+	CreditNotes interface {
+		Create(context.Context, *CreditNote) error
+		GetByNumber(context.Context, string) (*CreditNote, error)
+		GetByInvoiceID(context.Context, int64) ([]CreditNote, error)
+	}
+	TaxRules interface {
+		Create(context.Context, *TaxRule) error
+		GetAll(context.Context) ([]TaxRule, error)
+		DeleteByID(context.Context, int64) error
+	}
+	Ledger interface {
+		GetAccounts(context.Context) ([]LedgerAccount, error)
+		GetOperatorAccounts(context.Context, int64) ([]LedgerAccount, error)
+		GetTrialBalance(context.Context) (*TrialBalance, error)
+		CreatePayout(context.Context, *Payout) error
+	}
+	Waitlist interface {
+		Join(context.Context, *WaitlistEntry) error
+		GetByID(context.Context, int64) (*WaitlistEntry, error)
+		GetByTripID(context.Context, int64) ([]WaitlistEntry, error)
+		GetByUserID(context.Context, int64) ([]WaitlistEntry, error)
+		Leave(context.Context, int64) error
+		Promote(context.Context, int64) ([]WaitlistEntry, error)
+	}
 	//add more interface like based on the tables we are
 	// on having in our database
 }
 
-func NewStorage(db *sql.DB) Storage {
+func withTx(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func NewStorage(db *sql.DB, cfg Config) Storage {
 	return Storage{
-		Users:              &UserStore{db},
-		Trips:              &TripStore{db},
-		Bookings:           &BookingStore{db},
-		Payments:           &PaymentStore{db},
-		Subscriptions:      &SubscriptionStore{db},
-		Invoices:           &InvoiceStore{db},
-		Comments:           &CommentStore{db},
-		Photos:             &PhotoStore{db},
-		Accomodations:      &AccomodationStore{db},
-		AccomodationPhotos: &AccomodationPhotoStore{db},
-		Activities:         &ActivityStore{db},
-		ActivityPhotos:     &ActivityPhotoStore{db},
+		Users:                &UserStore{db},
+		Trips:                &TripStore{db},
+		TripTemplates:        &TripTemplateStore{db, cfg},
+		TripStops:            &TripStopStore{db},
+		Bookings:             &BookingStore{db, cfg},
+		Payments:             &PaymentStore{db, cfg},
+		Subscriptions:        &SubscriptionStore{db},
+		Invoices:             &InvoiceStore{db, cfg},
+		Comments:             &CommentStore{db},
+		Photos:               &PhotoStore{db},
+		Accomodations:        &AccomodationStore{db},
+		AccomodationPhotos:   &AccomodationPhotoStore{db},
+		Activities:           &ActivityStore{db},
+		ActivityPhotos:       &ActivityPhotoStore{db},
+		Roles:                &RoleStore{db},
+		Sessions:             &SessionStore{db},
+		UserTokens:           &UserTokenStore{db},
+		Passengers:           &PassengerStore{db},
+		Waitlist:             &WaitlistStore{db, cfg},
+		CancellationPolicies: &CancellationPolicyStore{db},
+		Refunds:              &RefundStore{db, cfg},
+		PaymentEvents:        &PaymentEventStore{db, cfg},
+		IdempotencyKeys:      &IdempotencyKeyStore{db},
+		Ledger:               &LedgerStore{db},
+		TaxRules:             &TaxRuleStore{db},
+		CreditNotes:          &CreditNoteStore{db, cfg},
 	}
 }
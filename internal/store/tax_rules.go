@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// TaxRule sets the rate, in basis points, of the tax included in the price
+// of items sold for trips in Location. A rule without a location or item
+// type applies to any.
+type TaxRule struct {
+	ID         int64   `json:"id"`
+	Name       string  `json:"name"`
+	Location   *string `json:"location"`
+	Item_type  *string `json:"item_type"`
+	Rate       int     `json:"rate"`
+	Created_at string  `json:"created_at"`
+}
+
+type TaxRuleStore struct {
+	db *sql.DB
+}
+
+func (s *TaxRuleStore) Create(ctx context.Context, rule *TaxRule) error {
+	query := `INSERT INTO tax_rule (name, location, item_type, rate)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, rule.Name, rule.Location, rule.Item_type, rule.Rate).Scan(
+		&rule.ID,
+		&rule.Created_at,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *TaxRuleStore) GetAll(ctx context.Context) ([]TaxRule, error) {
+	query := `SELECT id, name, location, item_type, rate, created_at FROM tax_rule ORDER BY location NULLS FIRST, item_type NULLS FIRST`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTaxRules(rows)
+}
+
+func (s *TaxRuleStore) DeleteByID(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM tax_rule WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// taxRules are the rules that can apply to a trip's location.
+type taxRules []TaxRule
+
+func loadTaxRules(ctx context.Context, tx *sql.Tx, location string) (taxRules, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT id, name, location, item_type, rate, created_at
+		FROM tax_rule
+		WHERE location IS NULL OR LOWER(location) = LOWER($1)`,
+		location,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTaxRules(rows)
+}
+
+// resolve picks the rule for an item of kind. A rule for the location beats
+// one for the item type, one for both beats either. Without a rule the item
+// is taxed at defaultRate.
+func (rules taxRules) resolve(kind string, defaultRate int) (name string, rate int) {
+	name, rate = "Tax", defaultRate
+	best := -1
+
+	for _, rule := range rules {
+		if rule.Item_type != nil && *rule.Item_type != kind {
+			continue
+		}
+
+		score := 0
+		if rule.Location != nil {
+			score += 2
+		}
+		if rule.Item_type != nil {
+			score++
+		}
+
+		if score > best {
+			best = score
+			name, rate = rule.Name, rule.Rate
+		}
+	}
+
+	return name, rate
+}
+
+func scanTaxRules(rows *sql.Rows) ([]TaxRule, error) {
+	var rules []TaxRule
+
+	for rows.Next() {
+		var rule TaxRule
+		if err := rows.Scan(
+			&rule.ID,
+			&rule.Name,
+			&rule.Location,
+			&rule.Item_type,
+			&rule.Rate,
+			&rule.Created_at,
+		); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
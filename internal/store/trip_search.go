@@ -0,0 +1,332 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TripSortDate   = "date"
+	TripSortPrice  = "price"
+	TripSortRating = "rating"
+
+	// TripSortRelevance puts the trips matching a text query best first, it
+	// needs a query.
+	TripSortRelevance = "relevance"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TripSearch filters trips. Zero values don't filter, Query is a web search
+// style text query over the trip's name, location, description and activity
+// names, Location matches the start of the trip's location ignoring case and
+// the dates bound the day a trip starts. Sort is one of the TripSort values,
+// prefixed with "-" for descending order.
+type TripSearch struct {
+	Query     string
+	Location  string
+	From      string
+	To        string
+	Min_price *int64
+	Max_price *int64
+	Currency  string
+	Min_seats int
+	Sort      string
+	Limit     int
+	After     *TripCursor
+}
+
+// TripResult is a trip found by a search with its reviews' average rating.
+// Searches with a text query also rank the trip and highlight the matching
+// words of its description and activities in the snippet.
+type TripResult struct {
+	Trip
+	Rating  *float64 `json:"rating"`
+	Reviews int      `json:"reviews"`
+	Rank    *float64 `json:"rank,omitempty"`
+	Snippet *string  `json:"snippet,omitempty"`
+}
+
+// TripCursor points just past the last trip of a page, in the order the
+// page was sorted by.
+type TripCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func (c *TripCursor) Encode() string {
+	data, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeTripCursor(s string) (*TripCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := &TripCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if !cursor.validValue() {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// validValue reports whether the cursor's value parses as the type its sort
+// key is cast to, so an edited or stale cursor can't fail the query.
+func (c *TripCursor) validValue() bool {
+	switch strings.TrimPrefix(c.Sort, "-") {
+	case TripSortDate:
+		_, err := time.Parse("2006-01-02", c.Value)
+		return err == nil
+	case TripSortPrice:
+		_, err := strconv.ParseInt(c.Value, 10, 64)
+		return err == nil
+	case TripSortRating, TripSortRelevance:
+		v, err := strconv.ParseFloat(c.Value, 64)
+		return err == nil && !math.IsNaN(v) && !math.IsInf(v, 0)
+	default:
+		return false
+	}
+}
+
+// tripSortKeys maps a sort to the column it orders by and the type its
+// cursor value is cast to.
+var tripSortKeys = map[string]struct{ column, cast string }{
+	TripSortDate:   {"s.start_date", "date"},
+	TripSortPrice:  {"s.price", "bigint"},
+	TripSortRating: {"COALESCE(s.rating, 0)", "float8"},
+	// negated so the best match comes first in ascending order
+	TripSortRelevance: {"(-s.rank)", "float8"},
+}
+
+// The snippet's matched words are marked with control characters, dropped
+// from the text beforehand, so the text can be HTML escaped before they
+// become <mark> tags.
+const (
+	snippetStartSel = "\x02"
+	snippetStopSel  = "\x03"
+)
+
+// tripHeadlineOptions marks the matched words with the snippet selectors
+// and keeps the snippet to a couple of short fragments.
+const tripHeadlineOptions = "StartSel=\"" + snippetStartSel + "\", StopSel=\"" + snippetStopSel + "\", " +
+	"MaxWords=20, MinWords=8, MaxFragments=2, FragmentDelimiter=\" … \""
+
+var snippetReplacer = strings.NewReplacer(snippetStartSel, "<mark>", snippetStopSel, "</mark>")
+
+// highlightSnippet escapes the snippet and wraps its matched words in
+// <mark>.
+func highlightSnippet(snippet string) string {
+	return snippetReplacer.Replace(html.EscapeString(snippet))
+}
+
+// Search returns a page of trips matching the search and the cursor of the
+// next page, nil on the last page.
+func (s *TripStore) Search(ctx context.Context, search TripSearch) ([]TripResult, *TripCursor, error) {
+	sort := search.Sort
+	if sort == "" {
+		sort = TripSortDate
+		if search.Query != "" {
+			sort = TripSortRelevance
+		}
+	}
+
+	desc := strings.HasPrefix(sort, "-")
+	key, ok := tripSortKeys[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sort %q", sort)
+	}
+	if strings.TrimPrefix(sort, "-") == TripSortRelevance && search.Query == "" {
+		return nil, nil, fmt.Errorf("sorting by %s needs a query", TripSortRelevance)
+	}
+
+	// cancelled departures are never found
+	where := []string{"t.cancelled_at IS NULL"}
+	var args []any
+
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	rank, snippet := "NULL::float8", "NULL::text"
+	if search.Query != "" {
+		query := "websearch_to_tsquery('english', " + arg(search.Query) + ")"
+		where = append(where, "t.search_vector @@ "+query)
+		rank = "ts_rank_cd(t.search_vector, " + query + ")::float8"
+		snippet = fmt.Sprintf(
+			"ts_headline('english', translate(concat_ws(' ', s.description, s.activity_names), chr(2) || chr(3), ''), %s, %s)",
+			query, arg(tripHeadlineOptions),
+		)
+	}
+
+	if search.Location != "" {
+		where = append(where, "LOWER(t.location) LIKE "+arg(escapeLike(strings.ToLower(search.Location))+"%"))
+	}
+	if search.From != "" {
+		where = append(where, "t.start_date >= "+arg(search.From)+"::date")
+	}
+	if search.To != "" {
+		where = append(where, "t.start_date <= "+arg(search.To)+"::date")
+	}
+	if search.Min_price != nil {
+		where = append(where, "t.price >= "+arg(*search.Min_price))
+	}
+	if search.Max_price != nil {
+		where = append(where, "t.price <= "+arg(*search.Max_price))
+	}
+	if search.Currency != "" {
+		where = append(where, "t.currency = "+arg(strings.ToUpper(search.Currency)))
+	}
+	if search.Min_seats > 0 {
+		where = append(where, "t.available_seats >= "+arg(search.Min_seats))
+	}
+
+	filter := "WHERE " + strings.Join(where, " AND ")
+
+	order, compare := "ASC", ">"
+	if desc {
+		order, compare = "DESC", "<"
+	}
+
+	after := ""
+	if search.After != nil {
+		if search.After.Sort != sort || !search.After.validValue() {
+			return nil, nil, ErrInvalidCursor
+		}
+		after = fmt.Sprintf(
+			"WHERE (%s, s.id) %s (%s::%s, %s)",
+			key.column, compare, arg(search.After.Value), key.cast, arg(search.After.ID),
+		)
+	}
+
+	limit := search.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	query := fmt.Sprintf(`
+	SELECT s.id, s.name, s.description, s.location, s.start_date, s.end_date, s.price, s.currency, s.seats,
+		s.available_seats, s.cancellation_policy_id, s.operator_id, s.departure_lat, s.departure_lng,
+		s.destination_lat, s.destination_lng, s.template_id, s.cancelled_at, s.created_at, s.rating, s.reviews,
+		s.rank, %s
+	FROM (
+		SELECT t.*, r.rating, COALESCE(r.reviews, 0) AS reviews, %s AS rank
+		FROM trip t
+		LEFT JOIN (
+			SELECT trip_id, AVG(rating)::float8 AS rating, COUNT(*) AS reviews
+			FROM comment
+			WHERE rating IS NOT NULL
+			GROUP BY trip_id
+		) r ON r.trip_id = t.id
+		%s
+	) s
+	%s
+	ORDER BY %s %s, s.id %s
+	LIMIT %s`,
+		snippet, rank, filter, after, key.column, order, order, arg(limit+1),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var trips []TripResult
+
+	for rows.Next() {
+		var (
+			trip                   TripResult
+			departure, destination nullPoint
+		)
+		if err := rows.Scan(
+			&trip.ID,
+			&trip.Name,
+			&trip.Decription,
+			&trip.Location,
+			&trip.Start_date,
+			&trip.End_date,
+			&trip.Price.Amount,
+			&trip.Price.Currency,
+			&trip.Seats,
+			&trip.Available_seats,
+			&trip.Cancellation_policy_id,
+			&trip.Operator_id,
+			&departure.Lat,
+			&departure.Lng,
+			&destination.Lat,
+			&destination.Lng,
+			&trip.Template_id,
+			&trip.Cancelled_at,
+			&trip.Created_at,
+			&trip.Rating,
+			&trip.Reviews,
+			&trip.Rank,
+			&trip.Snippet,
+		); err != nil {
+			return nil, nil, err
+		}
+
+		if trip.Snippet != nil {
+			snippet := highlightSnippet(*trip.Snippet)
+			trip.Snippet = &snippet
+		}
+
+		trip.Departure = departure.point()
+		trip.Destination = destination.point()
+		trips = append(trips, trip)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(trips) <= limit {
+		return trips, nil, nil
+	}
+
+	trips = trips[:limit]
+	last := trips[limit-1]
+
+	next := &TripCursor{Sort: sort, ID: last.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case TripSortDate:
+		next.Value = last.Start_date[:min(len(last.Start_date), 10)]
+	case TripSortPrice:
+		next.Value = strconv.FormatInt(last.Price.Amount, 10)
+	case TripSortRelevance:
+		next.Value = strconv.FormatFloat(-*last.Rank, 'g', -1, 64)
+	default:
+		var rating float64
+		if last.Rating != nil {
+			rating = *last.Rating
+		}
+		next.Value = strconv.FormatFloat(rating, 'g', -1, 64)
+	}
+
+	return trips, next, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,324 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrInvalidSegment = errors.New("the boarding stop must come before the alighting stop on the same trip")
+	ErrSegmentBooked  = errors.New("a booking boards or alights at the trip's stops")
+)
+
+// TripStop is a stop on a trip's route, Position orders the stops from 0.
+// Available_seats is what is left on the leg to the next stop, nil on the
+// last stop which starts no leg.
+type TripStop struct {
+	ID              int64   `json:"id"`
+	Trip_id         int64   `json:"trip_id"`
+	Position        int     `json:"position"`
+	Name            string  `json:"name"`
+	Location        *Point  `json:"location"`
+	Arrives_at      *string `json:"arrives_at"`
+	Departs_at      *string `json:"departs_at"`
+	Available_seats *int    `json:"available_seats"`
+	Created_at      string  `json:"created_at"`
+}
+
+type TripStopStore struct {
+	db *sql.DB
+}
+
+func (s *TripStopStore) GetByTripID(ctx context.Context, tripID int64) ([]TripStop, error) {
+	query := `SELECT id, trip_id, position, name, lat, lng, to_char(arrives_at, 'YYYY-MM-DD"T"HH24:MI'),
+		to_char(departs_at, 'YYYY-MM-DD"T"HH24:MI'), available_seats, created_at
+	FROM trip_stop
+	WHERE trip_id = $1
+	ORDER BY position`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, tripID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stops []TripStop
+
+	for rows.Next() {
+		var (
+			stop      TripStop
+			point     nullPoint
+			available int
+		)
+		if err := rows.Scan(
+			&stop.ID,
+			&stop.Trip_id,
+			&stop.Position,
+			&stop.Name,
+			&point.Lat,
+			&point.Lng,
+			&stop.Arrives_at,
+			&stop.Departs_at,
+			&available,
+			&stop.Created_at,
+		); err != nil {
+			return nil, err
+		}
+
+		stop.Location = point.point()
+		stop.Available_seats = &available
+		stops = append(stops, stop)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(stops) > 0 {
+		stops[len(stops)-1].Available_seats = nil
+	}
+
+	return stops, nil
+}
+
+// Replace sets the trip's route to stops, in order, and fills in their
+// ids. Every leg starts with the seats the trip has left, so stops can't be
+// replaced while a pending or confirmed booking picked one, that fails with
+// ErrSegmentBooked. No stops makes the trip a single leg again.
+func (s *TripStopStore) Replace(ctx context.Context, tripID int64, stops []TripStop) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var available int
+
+		err := tx.QueryRowContext(ctx, `SELECT available_seats FROM trip WHERE id = $1 FOR UPDATE`, tripID).Scan(&available)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		var booked bool
+
+		err = tx.QueryRowContext(
+			ctx,
+			`SELECT EXISTS (
+				SELECT 1 FROM booking
+				WHERE trip_id = $1 AND status IN ('pending', 'confirmed') AND (from_stop_id IS NOT NULL OR to_stop_id IS NOT NULL)
+			)`,
+			tripID,
+		).Scan(&booked)
+		if err != nil {
+			return err
+		}
+
+		if booked {
+			return ErrSegmentBooked
+		}
+
+		// bookings that no longer hold a seat let go of the old stops
+		_, err = tx.ExecContext(
+			ctx,
+			`UPDATE booking SET from_stop_id = NULL, to_stop_id = NULL
+			WHERE trip_id = $1 AND status NOT IN ('pending', 'confirmed') AND (from_stop_id IS NOT NULL OR to_stop_id IS NOT NULL)`,
+			tripID,
+		)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM trip_stop WHERE trip_id = $1`, tripID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO trip_stop (trip_id, position, name, lat, lng, arrives_at, departs_at, available_seats)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+		for i := range stops {
+			stop := &stops[i]
+			stop.Trip_id = tripID
+			stop.Position = i
+			stop.Available_seats = nil
+			if i < len(stops)-1 {
+				stop.Available_seats = &available
+			}
+
+			err := tx.QueryRowContext(
+				ctx,
+				query,
+				stop.Trip_id,
+				stop.Position,
+				stop.Name,
+				stop.Location.lat(),
+				stop.Location.lng(),
+				stop.Arrives_at,
+				stop.Departs_at,
+				available,
+			).Scan(&stop.ID, &stop.Created_at)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Availability returns the seats left from the stop fromStop to the stop
+// toStop, nil stops meaning the first and the last. A trip without stops
+// is a single leg.
+func (s *TripStopStore) Availability(ctx context.Context, tripID int64, fromStop, toStop *int64) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var available int
+
+	err := s.db.QueryRowContext(ctx, `SELECT available_seats FROM trip WHERE id = $1`, tripID).Scan(&available)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	legs, err := bookingLegs(ctx, s.db, tripID, fromStop, toStop)
+	if err != nil {
+		return 0, err
+	}
+
+	if legs == nil {
+		return available, nil
+	}
+
+	err = s.db.QueryRowContext(
+		ctx,
+		`SELECT MIN(available_seats) FROM trip_stop WHERE trip_id = $1 AND position >= $2 AND position < $3`,
+		tripID, legs.first, legs.end,
+	).Scan(&available)
+
+	return available, err
+}
+
+// legs are the positions of the stops starting the legs a booking rides,
+// from first up to, not including, end.
+type legs struct {
+	first int
+	end   int
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// bookingLegs resolves the legs of the trip ridden from fromStop to toStop,
+// nil stops meaning the first and the last. It returns nil legs for a trip
+// without stops and ErrInvalidSegment when the stops aren't the trip's or
+// are out of order.
+func bookingLegs(ctx context.Context, db queryRower, tripID int64, fromStop, toStop *int64) (*legs, error) {
+	var (
+		count          int
+		first, last    sql.NullInt64
+		fromPos, toPos sql.NullInt64
+	)
+
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*), MIN(position), MAX(position),
+			MIN(position) FILTER (WHERE id = $2), MIN(position) FILTER (WHERE id = $3)
+		FROM trip_stop
+		WHERE trip_id = $1`,
+		tripID, fromStop, toStop,
+	).Scan(&count, &first, &last, &fromPos, &toPos)
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		if fromStop != nil || toStop != nil {
+			return nil, ErrInvalidSegment
+		}
+		return nil, nil
+	}
+
+	l := &legs{first: int(first.Int64), end: int(last.Int64)}
+
+	if fromStop != nil {
+		if !fromPos.Valid {
+			return nil, ErrInvalidSegment
+		}
+		l.first = int(fromPos.Int64)
+	}
+
+	if toStop != nil {
+		if !toPos.Valid {
+			return nil, ErrInvalidSegment
+		}
+		l.end = int(toPos.Int64)
+	}
+
+	if l.first >= l.end {
+		return nil, ErrInvalidSegment
+	}
+
+	return l, nil
+}
+
+// reserveLegs takes n seats from every leg in l, failing with ErrTripFull
+// when one of them has fewer left. The trip row must be locked.
+func reserveLegs(ctx context.Context, tx *sql.Tx, tripID int64, l *legs, n int) error {
+	var available int
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT MIN(available_seats) FROM trip_stop WHERE trip_id = $1 AND position >= $2 AND position < $3`,
+		tripID, l.first, l.end,
+	).Scan(&available)
+	if err != nil {
+		return err
+	}
+
+	if available < n {
+		return ErrTripFull
+	}
+
+	return adjustLegs(ctx, tx, tripID, l, -n)
+}
+
+// adjustLegs adds delta seats to every leg in l, nil l meaning all of the
+// trip's legs, and keeps the trip's available seats at what is left on its
+// fullest leg, the seats that can be booked end to end. Legs never drop
+// below no seats left.
+func adjustLegs(ctx context.Context, tx *sql.Tx, tripID int64, l *legs, delta int) error {
+	query := `UPDATE trip_stop SET available_seats = GREATEST(available_seats + $1, 0)
+	WHERE trip_id = $2 AND position < (SELECT MAX(position) FROM trip_stop WHERE trip_id = $2)`
+	args := []any{delta, tripID}
+
+	if l != nil {
+		query += ` AND position >= $3 AND position < $4`
+		args = append(args, l.first, l.end)
+	}
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(
+		ctx,
+		`UPDATE trip
+		SET available_seats = legs.available
+		FROM (
+			SELECT MIN(available_seats) AS available FROM trip_stop
+			WHERE trip_id = $1 AND position < (SELECT MAX(position) FROM trip_stop WHERE trip_id = $1)
+		) legs
+		WHERE id = $1 AND legs.available IS NOT NULL`,
+		tripID,
+	)
+
+	return err
+}
@@ -0,0 +1,754 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"transportService/internal/rrule"
+
+	"github.com/lib/pq"
+)
+
+var ErrSeatsBooked = errors.New("a departure has more seats booked than the template offers")
+
+// TripTemplate describes a trip an operator runs on a schedule. Rrule is an
+// RRULE-style recurrence starting on First_date, the departures it yields
+// become trips except on the Exception_dates. A template that is no longer
+// active generates no more departures.
+type TripTemplate struct {
+	ID                     int64    `json:"id"`
+	Operator_id            int64    `json:"operator_id"`
+	Name                   string   `json:"name"`
+	Description            string   `json:"description"`
+	Location               string   `json:"location"`
+	Duration_days          int      `json:"duration_days"`
+	Price                  Money    `json:"price"`
+	Seats                  int      `json:"seats"`
+	Cancellation_policy_id *int64   `json:"cancellation_policy_id"`
+	Departure              *Point   `json:"departure"`
+	Destination            *Point   `json:"destination"`
+	Rrule                  string   `json:"rrule"`
+	First_date             string   `json:"first_date"`
+	Exception_dates        []string `json:"exception_dates"`
+	Active                 bool     `json:"active"`
+	Generated_until        *string  `json:"generated_until"`
+	Created_at             string   `json:"created_at"`
+	Updated_at             string   `json:"updated_at"`
+}
+
+// DepartureCancellation is the outcome of cancelling departures: the trips
+// cancelled, their bookings, cancelled too, and the full refunds of what was
+// paid for them.
+type DepartureCancellation struct {
+	Trip_ids []int64   `json:"trip_ids"`
+	Bookings []Booking `json:"bookings"`
+	Refunds  []Refund  `json:"refunds"`
+}
+
+func (c *DepartureCancellation) add(other *DepartureCancellation) {
+	c.Trip_ids = append(c.Trip_ids, other.Trip_ids...)
+	c.Bookings = append(c.Bookings, other.Bookings...)
+	c.Refunds = append(c.Refunds, other.Refunds...)
+}
+
+// TripTemplateUpdate is the outcome of editing a template: how many future
+// departures took the change, how many were generated for a new schedule
+// and the departures that dropped out of it.
+type TripTemplateUpdate struct {
+	Template  *TripTemplate         `json:"template"`
+	Updated   int                   `json:"updated"`
+	Generated int                   `json:"generated"`
+	Cancelled DepartureCancellation `json:"cancelled"`
+}
+
+type TripTemplateStore struct {
+	db  *sql.DB
+	cfg Config
+}
+
+const tripTemplateColumns = `id, operator_id, name, description, location, duration_days, price, currency, seats,
+	cancellation_policy_id, departure_lat, departure_lng, destination_lat, destination_lng, rrule, first_date::text,
+	exception_dates::text[], active, generated_until::text, created_at, updated_at`
+
+// Create saves the template and generates its departures for the coming
+// days in the same transaction.
+func (s *TripTemplateStore) Create(ctx context.Context, template *TripTemplate) error {
+	if _, err := rrule.Parse(template.Rrule); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO trip_template (operator_id, name, description, location, duration_days, price, currency, seats,
+		cancellation_policy_id, departure_lat, departure_lng, destination_lat, destination_lng, rrule, first_date, exception_dates)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+	RETURNING id, active, created_at, updated_at`
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			template.Operator_id,
+			template.Name,
+			template.Description,
+			template.Location,
+			template.Duration_days,
+			template.Price.Amount,
+			template.Price.Currency,
+			template.Seats,
+			template.Cancellation_policy_id,
+			template.Departure.lat(),
+			template.Departure.lng(),
+			template.Destination.lat(),
+			template.Destination.lng(),
+			template.Rrule,
+			template.First_date,
+			pq.Array(template.Exception_dates),
+		).Scan(
+			&template.ID,
+			&template.Active,
+			&template.Created_at,
+			&template.Updated_at,
+		)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		_, err = generateDepartures(ctx, tx, s.cfg, template)
+
+		return err
+	})
+}
+
+func (s *TripTemplateStore) GetByID(ctx context.Context, id int64) (*TripTemplate, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	template, err := scanTripTemplate(s.db.QueryRowContext(ctx, `SELECT `+tripTemplateColumns+` FROM trip_template WHERE id = $1`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return template, nil
+}
+
+func (s *TripTemplateStore) GetByOperatorID(ctx context.Context, operatorID int64) ([]TripTemplate, error) {
+	return s.query(ctx, `SELECT `+tripTemplateColumns+` FROM trip_template WHERE operator_id = $1 ORDER BY id`, operatorID)
+}
+
+// GetActive returns the templates that still generate departures.
+func (s *TripTemplateStore) GetActive(ctx context.Context) ([]TripTemplate, error) {
+	return s.query(ctx, `SELECT `+tripTemplateColumns+` FROM trip_template WHERE active ORDER BY id`)
+}
+
+func (s *TripTemplateStore) query(ctx context.Context, query string, args ...any) ([]TripTemplate, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []TripTemplate
+
+	for rows.Next() {
+		template, err := scanTripTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *template)
+	}
+
+	return templates, rows.Err()
+}
+
+// GetDepartures returns the trips generated from the template, cancelled
+// ones included, in departure order.
+func (s *TripTemplateStore) GetDepartures(ctx context.Context, id int64) ([]Trip, error) {
+	query := `SELECT id, name, description, location, start_date, end_date, price, currency, seats, available_seats, cancellation_policy_id, operator_id, departure_lat, departure_lng, destination_lat, destination_lng, template_id, cancelled_at, created_at
+	FROM trip
+	WHERE template_id = $1
+	ORDER BY start_date`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trips []Trip
+
+	for rows.Next() {
+		var (
+			trip                   Trip
+			departure, destination nullPoint
+		)
+		if err := rows.Scan(&trip.ID,
+			&trip.Name,
+			&trip.Decription,
+			&trip.Location,
+			&trip.Start_date,
+			&trip.End_date,
+			&trip.Price.Amount,
+			&trip.Price.Currency,
+			&trip.Seats,
+			&trip.Available_seats,
+			&trip.Cancellation_policy_id,
+			&trip.Operator_id,
+			&departure.Lat,
+			&departure.Lng,
+			&destination.Lat,
+			&destination.Lng,
+			&trip.Template_id,
+			&trip.Cancelled_at,
+			&trip.Created_at,
+		); err != nil {
+			return nil, err
+		}
+
+		trip.Departure = departure.point()
+		trip.Destination = destination.point()
+		trips = append(trips, trip)
+	}
+
+	return trips, rows.Err()
+}
+
+// Generate creates the template's departures up to the configured
+// TripScheduleHorizonDays ahead and returns how many were new.
+func (s *TripTemplateStore) Generate(ctx context.Context, id int64) (int, error) {
+	var generated int
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		template, err := lockTripTemplate(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		generated, err = generateDepartures(ctx, tx, s.cfg, template)
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return generated, nil
+}
+
+// Update edits the template and carries the change over to its future
+// departures, those departing today or earlier are left as they are.
+// Departures the new schedule no longer has are cancelled, it fails with
+// ErrSeatsBooked when a departure has more seats booked than
+// template.Seats.
+func (s *TripTemplateStore) Update(ctx context.Context, template *TripTemplate, changedBy *int64) (*TripTemplateUpdate, error) {
+	rule, err := rrule.Parse(template.Rrule)
+	if err != nil {
+		return nil, err
+	}
+
+	update := &TripTemplateUpdate{Template: template}
+
+	err = withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := lockTripTemplate(ctx, tx, template.ID); err != nil {
+			return err
+		}
+
+		query := `UPDATE trip_template
+		SET name = $1, description = $2, location = $3, duration_days = $4, price = $5, currency = $6, seats = $7,
+			cancellation_policy_id = $8, departure_lat = $9, departure_lng = $10, destination_lat = $11, destination_lng = $12,
+			rrule = $13, first_date = $14, exception_dates = $15, active = $16, updated_at = NOW()
+		WHERE id = $17
+		RETURNING operator_id, generated_until::text, created_at, updated_at`
+
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			template.Name,
+			template.Description,
+			template.Location,
+			template.Duration_days,
+			template.Price.Amount,
+			template.Price.Currency,
+			template.Seats,
+			template.Cancellation_policy_id,
+			template.Departure.lat(),
+			template.Departure.lng(),
+			template.Destination.lat(),
+			template.Destination.lng(),
+			template.Rrule,
+			template.First_date,
+			pq.Array(template.Exception_dates),
+			template.Active,
+			template.ID,
+		).Scan(&template.Operator_id, &template.Generated_until, &template.Created_at, &template.Updated_at)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		departures, err := lockFutureDepartures(ctx, tx, template.ID, "", "")
+		if err != nil {
+			return err
+		}
+
+		for _, departure := range departures {
+			if departure.booked > template.Seats {
+				return fmt.Errorf("%w: %d seats booked on %s", ErrSeatsBooked, departure.booked, departure.startDate)
+			}
+		}
+
+		// legs of routes with stops follow the change in seats like the trips do
+		_, err = tx.ExecContext(
+			ctx,
+			`UPDATE trip_stop s
+			SET available_seats = s.available_seats + ($1 - t.seats)
+			FROM trip t
+			WHERE s.trip_id = t.id AND t.template_id = $2 AND t.start_date > CURRENT_DATE AND t.cancelled_at IS NULL
+				AND s.position < (SELECT MAX(position) FROM trip_stop WHERE trip_id = t.id)`,
+			template.Seats,
+			template.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(
+			ctx,
+			`UPDATE trip
+			SET name = $1, description = $2, location = $3, end_date = start_date + $4::int, price = $5, currency = $6,
+				available_seats = available_seats + ($7 - seats), seats = $7, cancellation_policy_id = $8,
+				departure_lat = $9, departure_lng = $10, destination_lat = $11, destination_lng = $12
+			WHERE template_id = $13 AND start_date > CURRENT_DATE AND cancelled_at IS NULL`,
+			template.Name,
+			template.Description,
+			template.Location,
+			template.Duration_days,
+			template.Price.Amount,
+			template.Price.Currency,
+			template.Seats,
+			template.Cancellation_policy_id,
+			template.Departure.lat(),
+			template.Departure.lng(),
+			template.Destination.lat(),
+			template.Destination.lng(),
+			template.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		updated, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		update.Updated = int(updated)
+
+		scheduled, err := scheduledDates(template, rule)
+		if err != nil {
+			return err
+		}
+
+		reason := "departure dropped from the trip's schedule"
+		for _, departure := range departures {
+			if scheduled[departure.startDate] {
+				continue
+			}
+
+			cancellation, err := cancelDeparture(ctx, tx, s.cfg, departure.id, reason, changedBy)
+			if err != nil {
+				return err
+			}
+			update.Cancelled.add(cancellation)
+			update.Updated--
+		}
+
+		update.Generated, err = generateDepartures(ctx, tx, s.cfg, template)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return update, nil
+}
+
+// CancelDepartures cancels the template's future departures from from up
+// to to, both YYYY-MM-DD. Without to every future departure is cancelled
+// and the template stops generating new ones. Bookings on the departures are
+// cancelled and paid ones refunded in full whatever the cancellation policy.
+func (s *TripTemplateStore) CancelDepartures(ctx context.Context, id int64, from, to, reason string, changedBy *int64) (*DepartureCancellation, error) {
+	cancellation := &DepartureCancellation{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := lockTripTemplate(ctx, tx, id); err != nil {
+			return err
+		}
+
+		departures, err := lockFutureDepartures(ctx, tx, id, from, to)
+		if err != nil {
+			return err
+		}
+
+		for _, departure := range departures {
+			c, err := cancelDeparture(ctx, tx, s.cfg, departure.id, reason, changedBy)
+			if err != nil {
+				return err
+			}
+			cancellation.add(c)
+		}
+
+		if to == "" {
+			_, err := tx.ExecContext(ctx, `UPDATE trip_template SET active = FALSE, updated_at = NOW() WHERE id = $1`, id)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cancellation, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTripTemplate(row scanner) (*TripTemplate, error) {
+	var (
+		template               TripTemplate
+		departure, destination nullPoint
+	)
+
+	err := row.Scan(
+		&template.ID,
+		&template.Operator_id,
+		&template.Name,
+		&template.Description,
+		&template.Location,
+		&template.Duration_days,
+		&template.Price.Amount,
+		&template.Price.Currency,
+		&template.Seats,
+		&template.Cancellation_policy_id,
+		&departure.Lat,
+		&departure.Lng,
+		&destination.Lat,
+		&destination.Lng,
+		&template.Rrule,
+		&template.First_date,
+		pq.Array(&template.Exception_dates),
+		&template.Active,
+		&template.Generated_until,
+		&template.Created_at,
+		&template.Updated_at,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	template.Departure = departure.point()
+	template.Destination = destination.point()
+
+	return &template, nil
+}
+
+func lockTripTemplate(ctx context.Context, tx *sql.Tx, id int64) (*TripTemplate, error) {
+	template, err := scanTripTemplate(tx.QueryRowContext(ctx, `SELECT `+tripTemplateColumns+` FROM trip_template WHERE id = $1 FOR UPDATE`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return template, nil
+}
+
+type futureDeparture struct {
+	id        int64
+	startDate string
+	booked    int
+}
+
+// lockFutureDepartures locks the template's departures after today that
+// aren't cancelled, from and to narrow them down when not empty.
+func lockFutureDepartures(ctx context.Context, tx *sql.Tx, templateID int64, from, to string) ([]futureDeparture, error) {
+	var fromDate, toDate *string
+	if from != "" {
+		fromDate = &from
+	}
+	if to != "" {
+		toDate = &to
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT id, start_date::text, seats - available_seats
+		FROM trip
+		WHERE template_id = $1 AND start_date > CURRENT_DATE AND cancelled_at IS NULL
+			AND ($2::date IS NULL OR start_date >= $2::date) AND ($3::date IS NULL OR start_date <= $3::date)
+		ORDER BY start_date
+		FOR UPDATE`,
+		templateID, fromDate, toDate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var departures []futureDeparture
+
+	for rows.Next() {
+		var departure futureDeparture
+		if err := rows.Scan(&departure.id, &departure.startDate, &departure.booked); err != nil {
+			return nil, err
+		}
+		departures = append(departures, departure)
+	}
+
+	return departures, rows.Err()
+}
+
+// scheduledDates returns the days the template departs on up to the end of
+// what was generated for it, exception dates left out.
+func scheduledDates(template *TripTemplate, rule *rrule.Rule) (map[string]bool, error) {
+	dates := make(map[string]bool)
+
+	if template.Generated_until == nil {
+		return dates, nil
+	}
+
+	first, err := time.Parse(time.DateOnly, template.First_date)
+	if err != nil {
+		return nil, err
+	}
+
+	until, err := time.Parse(time.DateOnly, *template.Generated_until)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range rule.Between(first, first, until) {
+		dates[d.Format(time.DateOnly)] = true
+	}
+
+	for _, d := range template.Exception_dates {
+		delete(dates, d)
+	}
+
+	return dates, nil
+}
+
+// generateDepartures creates the trips the active template departs on from
+// today to cfg.TripScheduleHorizonDays ahead, days that already have a trip
+// of the template, cancelled or not, are skipped. It returns how many trips
+// were created.
+func generateDepartures(ctx context.Context, tx *sql.Tx, cfg Config, template *TripTemplate) (int, error) {
+	if !template.Active {
+		return 0, nil
+	}
+
+	rule, err := rrule.Parse(template.Rrule)
+	if err != nil {
+		return 0, err
+	}
+
+	first, err := time.Parse(time.DateOnly, template.First_date)
+	if err != nil {
+		return 0, err
+	}
+
+	var today time.Time
+	if err := tx.QueryRowContext(ctx, `SELECT CURRENT_DATE`).Scan(&today); err != nil {
+		return 0, err
+	}
+
+	until := today.AddDate(0, 0, cfg.TripScheduleHorizonDays)
+
+	exceptions := make(map[string]bool, len(template.Exception_dates))
+	for _, d := range template.Exception_dates {
+		exceptions[d] = true
+	}
+
+	query := `INSERT INTO trip (name, description, location, start_date, end_date, price, currency, seats, available_seats,
+		cancellation_policy_id, operator_id, departure_lat, departure_lng, destination_lat, destination_lng, template_id)
+	VALUES ($1, $2, $3, $4::date, $4::date + $5::int, $6, $7, $8, $8, $9, $10, $11, $12, $13, $14, $15)
+	ON CONFLICT (template_id, start_date) DO NOTHING`
+
+	var generated int
+
+	for _, d := range rule.Between(first, today, until) {
+		date := d.Format(time.DateOnly)
+		if exceptions[date] {
+			continue
+		}
+
+		res, err := tx.ExecContext(
+			ctx,
+			query,
+			template.Name,
+			template.Description,
+			template.Location,
+			date,
+			template.Duration_days,
+			template.Price.Amount,
+			template.Price.Currency,
+			template.Seats,
+			template.Cancellation_policy_id,
+			template.Operator_id,
+			template.Departure.lat(),
+			template.Departure.lng(),
+			template.Destination.lat(),
+			template.Destination.lng(),
+			template.ID,
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		generated += int(n)
+	}
+
+	generatedUntil := until.Format(time.DateOnly)
+	template.Generated_until = &generatedUntil
+
+	_, err = tx.ExecContext(ctx, `UPDATE trip_template SET generated_until = $1 WHERE id = $2`, generatedUntil, template.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	return generated, nil
+}
+
+// cancelDeparture cancels the trip and every booking on it that hasn't
+// departed, refunding what was paid in full and crediting what is left on
+// unpaid invoices so nobody is asked to pay for it.
+func cancelDeparture(ctx context.Context, tx *sql.Tx, cfg Config, tripID int64, reason string, changedBy *int64) (*DepartureCancellation, error) {
+	cancellation := &DepartureCancellation{Trip_ids: []int64{tripID}}
+
+	rows, err := tx.QueryContext(
+		ctx, `SELECT id FROM booking WHERE trip_id = $1 AND status IN ('pending', 'confirmed') ORDER BY id`, tripID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookingIDs []int64
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bookingIDs = append(bookingIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range bookingIDs {
+		booking, err := transitionBooking(ctx, tx, &BookingStatusChange{
+			Booking_id: id,
+			To_status:  BookingStatusCancelled,
+			Changed_by: changedBy,
+			Reason:     reason,
+		})
+		if err != nil {
+			return nil, err
+		}
+		cancellation.Bookings = append(cancellation.Bookings, *booking)
+
+		refunds, err := refundBookingPayments(ctx, tx, id, 100, reason)
+		if err != nil {
+			return nil, err
+		}
+		cancellation.Refunds = append(cancellation.Refunds, refunds...)
+
+		if err := creditUnpaidInvoices(ctx, tx, cfg, id, reason); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE trip SET cancelled_at = NOW() WHERE id = $1`, tripID); err != nil {
+		return nil, err
+	}
+
+	return cancellation, nil
+}
+
+// creditUnpaidInvoices credits what is left on the booking's unpaid
+// invoices, which voids them.
+func creditUnpaidInvoices(ctx context.Context, tx *sql.Tx, cfg Config, bookingID int64, reason string) error {
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT i.id, i.total - COALESCE((SELECT SUM(c.total) FROM credit_note c WHERE c.invoice_id = i.id), 0), i.currency
+		FROM invoice i
+		WHERE i.booking_id = $1 AND i.status IN ('unpaid', 'overdue')`,
+		bookingID,
+	)
+	if err != nil {
+		return err
+	}
+
+	var notes []CreditNote
+
+	for rows.Next() {
+		note := CreditNote{Reason: reason}
+		if err := rows.Scan(&note.Invoice_id, &note.Total.Amount, &note.Total.Currency); err != nil {
+			rows.Close()
+			return err
+		}
+		if note.Total.Amount > 0 {
+			notes = append(notes, note)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range notes {
+		if err := issueCreditNote(ctx, tx, cfg, &notes[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
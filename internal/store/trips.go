@@ -3,19 +3,28 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
 )
 
 type Trip struct {
-	ID              int64   `json:"id"`
-	Name            string  `json:"name"`
-	Decription      string  `json:"description"`
-	Location        string  `json:"location"`
-	Start_date      string  `json:"start_date"`
-	End_date        string  `json:"end_date"`
-	Price           float64 `json:"price"`
-	Seats           int     `json:"seats"`
-	Available_seats int     `json:"available_seats"`
-	Created_at      string  `json:"created_at"`
+	ID                     int64   `json:"id"`
+	Name                   string  `json:"name"`
+	Decription             string  `json:"description"`
+	Location               string  `json:"location"`
+	Start_date             string  `json:"start_date"`
+	End_date               string  `json:"end_date"`
+	Price                  Money   `json:"price"`
+	Seats                  int     `json:"seats"`
+	Available_seats        int     `json:"available_seats"`
+	Cancellation_policy_id *int64  `json:"cancellation_policy_id"`
+	Operator_id            *int64  `json:"operator_id"`
+	Departure              *Point  `json:"departure"`
+	Destination            *Point  `json:"destination"`
+	Template_id            *int64  `json:"template_id"`
+	Cancelled_at           *string `json:"cancelled_at"`
+	Created_at             string  `json:"created_at"`
 }
 
 type TripStore struct {
@@ -23,8 +32,9 @@ type TripStore struct {
 }
 
 func (s *TripStore) Create(ctx context.Context, trip *Trip) error {
-	query := `INSERT INTO trip (name, description, location, start_date, end_date, price, seats, available_seats)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`
+	query := `INSERT INTO trip (name, description, location, start_date, end_date, price, currency, seats, available_seats, cancellation_policy_id, operator_id,
+		departure_lat, departure_lng, destination_lat, destination_lng)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING id, created_at`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -37,14 +47,25 @@ func (s *TripStore) Create(ctx context.Context, trip *Trip) error {
 		trip.Location,
 		trip.Start_date,
 		trip.End_date,
-		trip.Price,
+		trip.Price.Amount,
+		trip.Price.Currency,
 		trip.Seats,
 		trip.Available_seats,
+		trip.Cancellation_policy_id,
+		trip.Operator_id,
+		trip.Departure.lat(),
+		trip.Departure.lng(),
+		trip.Destination.lat(),
+		trip.Destination.lng(),
 	).Scan(
 		&trip.ID,
 		&trip.Created_at,
 	)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrNotFound
+		}
 		return err
 	}
 
@@ -52,12 +73,13 @@ func (s *TripStore) Create(ctx context.Context, trip *Trip) error {
 }
 
 func (s *TripStore) GetByID(ctx context.Context, tripID int64) (*Trip, error) {
-	query := `SELECT id, name, description, location, start_date, end_date, price, seats, available_seats, created_at FROM trip WHERE id = $1`
+	query := `SELECT id, name, description, location, start_date, end_date, price, currency, seats, available_seats, cancellation_policy_id, operator_id, departure_lat, departure_lng, destination_lat, destination_lng, template_id, cancelled_at, created_at FROM trip WHERE id = $1`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
 	trip := &Trip{}
+	var departure, destination nullPoint
 
 	err := s.db.QueryRowContext(
 		ctx, query, tripID,
@@ -68,9 +90,18 @@ func (s *TripStore) GetByID(ctx context.Context, tripID int64) (*Trip, error) {
 		&trip.Location,
 		&trip.Start_date,
 		&trip.End_date,
-		&trip.Price,
+		&trip.Price.Amount,
+		&trip.Price.Currency,
 		&trip.Seats,
 		&trip.Available_seats,
+		&trip.Cancellation_policy_id,
+		&trip.Operator_id,
+		&departure.Lat,
+		&departure.Lng,
+		&destination.Lat,
+		&destination.Lng,
+		&trip.Template_id,
+		&trip.Cancelled_at,
 		&trip.Created_at,
 	)
 	if err != nil {
@@ -80,11 +111,14 @@ func (s *TripStore) GetByID(ctx context.Context, tripID int64) (*Trip, error) {
 		return nil, err
 	}
 
+	trip.Departure = departure.point()
+	trip.Destination = destination.point()
+
 	return trip, nil
 }
 
 func (s *TripStore) GetByLocation(ctx context.Context, location string) ([]Trip, error) {
-	query := `SELECT id, name, description, location, start_date, end_date, price, seats, available_seats, created_at FROM trip WHERE location = $1`
+	query := `SELECT id, name, description, location, start_date, end_date, price, currency, seats, available_seats, cancellation_policy_id, operator_id, departure_lat, departure_lng, destination_lat, destination_lng, template_id, cancelled_at, created_at FROM trip WHERE location = $1 AND cancelled_at IS NULL`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -98,21 +132,35 @@ func (s *TripStore) GetByLocation(ctx context.Context, location string) ([]Trip,
 	var trips []Trip
 
 	for rows.Next() {
-		var trip Trip
+		var (
+			trip                   Trip
+			departure, destination nullPoint
+		)
 		if err := rows.Scan(&trip.ID,
 			&trip.Name,
 			&trip.Decription,
 			&trip.Location,
 			&trip.Start_date,
 			&trip.End_date,
-			&trip.Price,
+			&trip.Price.Amount,
+			&trip.Price.Currency,
 			&trip.Seats,
 			&trip.Available_seats,
+			&trip.Cancellation_policy_id,
+			&trip.Operator_id,
+			&departure.Lat,
+			&departure.Lng,
+			&destination.Lat,
+			&destination.Lng,
+			&trip.Template_id,
+			&trip.Cancelled_at,
 			&trip.Created_at,
 		); err != nil {
 			return nil, err
 		}
 
+		trip.Departure = departure.point()
+		trip.Destination = destination.point()
 		trips = append(trips, trip)
 	}
 
@@ -120,9 +168,9 @@ func (s *TripStore) GetByLocation(ctx context.Context, location string) ([]Trip,
 }
 
 func (s *TripStore) GetUpcoming(ctx context.Context) ([]Trip, error) {
-	query := `SELECT id, name, description, location, start_date, end_date, price, seats, available_seats, created_at
+	query := `SELECT id, name, description, location, start_date, end_date, price, currency, seats, available_seats, cancellation_policy_id, operator_id, departure_lat, departure_lng, destination_lat, destination_lng, template_id, cancelled_at, created_at
 	FROM trip
-	WHERE start_date >= CURRENT_DATE
+	WHERE start_date >= CURRENT_DATE AND cancelled_at IS NULL
 	ORDER BY start_date ASC
 	`
 
@@ -138,60 +186,35 @@ func (s *TripStore) GetUpcoming(ctx context.Context) ([]Trip, error) {
 	var trips []Trip
 
 	for rows.Next() {
-		var trip Trip
-		if err := rows.Scan(&trip.ID,
-			&trip.Name,
-			&trip.Decription,
-			&trip.Location,
-			&trip.Start_date,
-			&trip.End_date,
-			&trip.Price,
-			&trip.Seats,
-			&trip.Available_seats,
-			&trip.Created_at,
-		); err != nil {
-			return nil, err
-		}
-
-		trips = append(trips, trip)
-	}
-
-	return trips, nil
-
-}
-
-func (s *TripStore) GetAll(ctx context.Context) ([]Trip, error) {
-	query := `SELECT id, name, description, location, start_date, end_date, price, seats, available_seats, created_at
-	FROM trip
-	`
-
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
-
-	rows, err := s.db.QueryContext(ctx, query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var trips []Trip
-
-	for rows.Next() {
-		var trip Trip
+		var (
+			trip                   Trip
+			departure, destination nullPoint
+		)
 		if err := rows.Scan(&trip.ID,
 			&trip.Name,
 			&trip.Decription,
 			&trip.Location,
 			&trip.Start_date,
 			&trip.End_date,
-			&trip.Price,
+			&trip.Price.Amount,
+			&trip.Price.Currency,
 			&trip.Seats,
 			&trip.Available_seats,
+			&trip.Cancellation_policy_id,
+			&trip.Operator_id,
+			&departure.Lat,
+			&departure.Lng,
+			&destination.Lat,
+			&destination.Lng,
+			&trip.Template_id,
+			&trip.Cancelled_at,
 			&trip.Created_at,
 		); err != nil {
 			return nil, err
 		}
 
+		trip.Departure = departure.point()
+		trip.Destination = destination.point()
 		trips = append(trips, trip)
 	}
 
@@ -199,21 +222,40 @@ func (s *TripStore) GetAll(ctx context.Context) ([]Trip, error) {
 
 }
 
+// UpdateByID replaces the trip. A route with stops moves every leg's seats
+// by the change in available seats, the trip then has what its fullest leg
+// has left.
 func (s *TripStore) UpdateByID(ctx context.Context, trip *Trip) error {
-	query := `UPDATE trip SET name = $1, description = $2, location = $3, start_date = $4, end_date = $5, price = $6, seats = $7, available_seats = $8
-		WHERE id = $9
-		RETURING id`
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var available int
+
+		err := tx.QueryRowContext(ctx, `SELECT available_seats FROM trip WHERE id = $1 FOR UPDATE`, trip.ID).Scan(&available)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
 
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
-	defer cancel()
+		query := `UPDATE trip SET name = $1, description = $2, location = $3, start_date = $4, end_date = $5, price = $6, currency = $7, seats = $8, available_seats = $9, cancellation_policy_id = $10,
+		departure_lat = $11, departure_lng = $12, destination_lat = $13, destination_lng = $14
+		WHERE id = $15`
 
-	err := s.db.QueryRowContext(
-		ctx, query, trip.Name, trip.Decription, trip.Location, trip.Start_date, trip.End_date, trip.Price, trip.Seats, trip.Available_seats, trip.ID,
-	).Scan(&trip.ID)
+		_, err = tx.ExecContext(
+			ctx, query, trip.Name, trip.Decription, trip.Location, trip.Start_date, trip.End_date, trip.Price.Amount, trip.Price.Currency, trip.Seats, trip.Available_seats, trip.Cancellation_policy_id,
+			trip.Departure.lat(), trip.Departure.lng(), trip.Destination.lat(), trip.Destination.lng(), trip.ID,
+		)
+		if err != nil {
+			return err
+		}
 
-	if err != nil {
-		return err
-	}
+		if err := adjustLegs(ctx, tx, trip.ID, nil, trip.Available_seats-available); err != nil {
+			return err
+		}
 
-	return nil
+		return tx.QueryRowContext(ctx, `SELECT available_seats FROM trip WHERE id = $1`, trip.ID).Scan(&trip.Available_seats)
+	})
 }
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposePasswordReset     = "password_reset"
+)
+
+// UserToken is a single-use token mailed to a user, only its hash is stored.
+type UserToken struct {
+	ID         int64     `json:"id"`
+	User_id    int64     `json:"user_id"`
+	Purpose    string    `json:"purpose"`
+	Token_hash string    `json:"-"`
+	Expires_at time.Time `json:"expires_at"`
+	Used_at    *string   `json:"used_at"`
+	Created_at string    `json:"created_at"`
+}
+
+type UserTokenStore struct {
+	db *sql.DB
+}
+
+// Create stores the token and invalidates any earlier unused token the user
+// was sent for the same purpose.
+func (s *UserTokenStore) Create(ctx context.Context, token *UserToken) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		_, err := tx.ExecContext(
+			ctx,
+			`UPDATE user_token SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+			token.User_id,
+			token.Purpose,
+		)
+		if err != nil {
+			return err
+		}
+
+		query := `INSERT INTO user_token (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+		return tx.QueryRowContext(
+			ctx, query, token.User_id, token.Purpose, token.Token_hash, token.Expires_at,
+		).Scan(&token.ID, &token.Created_at)
+	})
+}
+
+// consumeUserToken marks a live token as used and returns the id of the user
+// it belongs to, a token can only ever be consumed once.
+func consumeUserToken(ctx context.Context, tx *sql.Tx, tokenHash, purpose string) (int64, error) {
+	query := `UPDATE user_token SET used_at = NOW()
+	WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+	RETURNING user_id`
+
+	var userID int64
+
+	err := tx.QueryRowContext(ctx, query, tokenHash, purpose).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
@@ -9,13 +9,16 @@ import (
 var ErrDuplicateEmail = errors.New("a user with that email already exists")
 
 type User struct {
-	ID         int64  `json:"id"`
-	Email      string `json:"email"`
-	Password   string `json:"password"`
-	First_name string `json:"first_name"`
-	Last_name  string `json:"last_name"`
-	Phone      string `json:"phone"`
-	Created_at string `json:"created_at"`
+	ID          int64  `json:"id"`
+	Email       string `json:"email"`
+	Password    string `json:"-"`
+	First_name  string `json:"first_name"`
+	Last_name   string `json:"last_name"`
+	Phone       string `json:"phone"`
+	Role_id     int64  `json:"role_id"`
+	Role        Role   `json:"role"`
+	Is_verified bool   `json:"is_verified"`
+	Created_at  string `json:"created_at"`
 }
 
 type UserStore struct {
@@ -24,13 +27,19 @@ type UserStore struct {
 
 func (s *UserStore) Create(ctx context.Context, user *User) error {
 	query := `
-	INSERT INTO "user" (email, password, first_name, last_name, phone)
-	VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at
+	INSERT INTO "user" (email, password, first_name, last_name, phone, role_id)
+	VALUES ($1, $2, $3, $4, $5, (SELECT id FROM role WHERE name = $6))
+	RETURNING id, role_id, is_verified, created_at
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
+	role := user.Role.Name
+	if role == "" {
+		role = RoleCustomer
+	}
+
 	err := s.db.QueryRowContext(
 		ctx,
 		query,
@@ -39,8 +48,11 @@ func (s *UserStore) Create(ctx context.Context, user *User) error {
 		user.First_name,
 		user.Last_name,
 		user.Phone,
+		role,
 	).Scan(
 		&user.ID,
+		&user.Role_id,
+		&user.Is_verified,
 		&user.Created_at,
 	)
 	if err != nil {
@@ -54,7 +66,13 @@ func (s *UserStore) Create(ctx context.Context, user *User) error {
 }
 
 func (s *UserStore) GetByID(ctx context.Context, userID int64) (*User, error) {
-	query := `SELECT id, email, password, first_name, last_name, phone, created_at FROM "user" WHERE id = $1`
+	query := `
+	SELECT u.id, u.email, u.password, u.first_name, u.last_name, u.phone, u.role_id, u.is_verified, u.created_at,
+	r.id, r.name, r.level, COALESCE(r.description, '')
+	FROM "user" u
+	JOIN role r ON r.id = u.role_id
+	WHERE u.id = $1
+	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -70,7 +88,13 @@ func (s *UserStore) GetByID(ctx context.Context, userID int64) (*User, error) {
 		&user.First_name,
 		&user.Last_name,
 		&user.Phone,
+		&user.Role_id,
+		&user.Is_verified,
 		&user.Created_at,
+		&user.Role.ID,
+		&user.Role.Name,
+		&user.Role.Level,
+		&user.Role.Description,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -84,7 +108,13 @@ func (s *UserStore) GetByID(ctx context.Context, userID int64) (*User, error) {
 }
 
 func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
-	query := `SELECT id, email, first_name, last_name, password, phone, created_at FROM "user" WHERE email = $1`
+	query := `
+	SELECT u.id, u.email, u.first_name, u.last_name, u.password, u.phone, u.role_id, u.is_verified, u.created_at,
+	r.id, r.name, r.level, COALESCE(r.description, '')
+	FROM "user" u
+	JOIN role r ON r.id = u.role_id
+	WHERE u.email = $1
+	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -100,7 +130,13 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 		&user.Last_name,
 		&user.Password,
 		&user.Phone,
+		&user.Role_id,
+		&user.Is_verified,
 		&user.Created_at,
+		&user.Role.ID,
+		&user.Role.Name,
+		&user.Role.Level,
+		&user.Role.Description,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -152,3 +188,57 @@ func (s *UserStore) DeleteByID(ctx context.Context, userID int64) error {
 
 	return nil
 }
+
+// Verify consumes an email verification token and marks its user verified.
+func (s *UserStore) Verify(ctx context.Context, tokenHash string) (int64, error) {
+	var userID int64
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		id, err := consumeUserToken(ctx, tx, tokenHash, TokenPurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+		userID = id
+
+		_, err = tx.ExecContext(ctx, `UPDATE "user" SET is_verified = TRUE WHERE id = $1`, userID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// ResetPassword consumes a password reset token, stores the new password
+// hash and revokes every session of the user.
+func (s *UserStore) ResetPassword(ctx context.Context, tokenHash, password string) (int64, error) {
+	var userID int64
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		id, err := consumeUserToken(ctx, tx, tokenHash, TokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+		userID = id
+
+		_, err = tx.ExecContext(ctx, `UPDATE "user" SET password = $1 WHERE id = $2`, password, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE session SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
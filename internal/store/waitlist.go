@@ -0,0 +1,295 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+const (
+	WaitlistStatusWaiting   = "waiting"
+	WaitlistStatusOffered   = "offered"
+	WaitlistStatusCancelled = "cancelled"
+)
+
+var ErrSeatsAvailable = errors.New("trip still has enough seats, book it directly")
+
+type WaitlistEntry struct {
+	ID         int64       `json:"id"`
+	Trip_id    int64       `json:"trip_id"`
+	User_id    int64       `json:"user_id"`
+	Quantity   int         `json:"quantity"`
+	Passengers []Passenger `json:"passengers"`
+	Status     string      `json:"status"`
+	Booking_id *int64      `json:"booking_id"`
+	Offered_at *string     `json:"offered_at"`
+	Created_at string      `json:"created_at"`
+}
+
+type WaitlistStore struct {
+	db  *sql.DB
+	cfg Config
+}
+
+// Join queues the user for a trip that can't seat their party right now.
+func (s *WaitlistStore) Join(ctx context.Context, entry *WaitlistEntry) error {
+	entry.Quantity = len(entry.Passengers)
+
+	passengers, err := json.Marshal(entry.Passengers)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var (
+		available int
+		cancelled bool
+	)
+
+	err = s.db.QueryRowContext(
+		ctx, `SELECT available_seats, cancelled_at IS NOT NULL FROM trip WHERE id = $1`, entry.Trip_id,
+	).Scan(&available, &cancelled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if cancelled {
+		return ErrTripCancelled
+	}
+
+	if available >= entry.Quantity {
+		return ErrSeatsAvailable
+	}
+
+	query := `INSERT INTO waitlist_entry (trip_id, user_id, quantity, passengers)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, status, created_at`
+
+	err = s.db.QueryRowContext(ctx, query, entry.Trip_id, entry.User_id, entry.Quantity, passengers).Scan(
+		&entry.ID,
+		&entry.Status,
+		&entry.Created_at,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *WaitlistStore) GetByID(ctx context.Context, id int64) (*WaitlistEntry, error) {
+	query := `SELECT id, trip_id, user_id, quantity, passengers, status, booking_id, offered_at, created_at
+	FROM waitlist_entry
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries, err := scanWaitlistEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &entries[0], nil
+}
+
+// GetByTripID returns the trip's queue, oldest entry first.
+func (s *WaitlistStore) GetByTripID(ctx context.Context, tripID int64) ([]WaitlistEntry, error) {
+	query := `SELECT id, trip_id, user_id, quantity, passengers, status, booking_id, offered_at, created_at
+	FROM waitlist_entry
+	WHERE trip_id = $1
+	ORDER BY created_at, id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, tripID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWaitlistEntries(rows)
+}
+
+func (s *WaitlistStore) GetByUserID(ctx context.Context, userID int64) ([]WaitlistEntry, error) {
+	query := `SELECT id, trip_id, user_id, quantity, passengers, status, booking_id, offered_at, created_at
+	FROM waitlist_entry
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWaitlistEntries(rows)
+}
+
+// Leave takes a waiting entry off the queue.
+func (s *WaitlistStore) Leave(ctx context.Context, id int64) error {
+	query := `UPDATE waitlist_entry SET status = 'cancelled' WHERE id = $1 AND status = 'waiting'`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Promote hands free seats on the trip to the waitlist in order. Every entry
+// that fits gets a pending booking holding its seats, the queue stops at the
+// first party that doesn't fit so nobody is skipped. It returns the entries
+// that were offered a booking.
+func (s *WaitlistStore) Promote(ctx context.Context, tripID int64) ([]WaitlistEntry, error) {
+	var offered []WaitlistEntry
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var (
+			available int
+			cancelled bool
+		)
+
+		err := tx.QueryRowContext(
+			ctx, `SELECT available_seats, cancelled_at IS NOT NULL FROM trip WHERE id = $1 FOR UPDATE`, tripID,
+		).Scan(&available, &cancelled)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		// nobody is offered seats on a trip that doesn't depart
+		if cancelled {
+			return nil
+		}
+
+		query := `SELECT id, trip_id, user_id, quantity, passengers, status, booking_id, offered_at, created_at
+		FROM waitlist_entry
+		WHERE trip_id = $1 AND status = 'waiting'
+		ORDER BY created_at, id
+		FOR UPDATE`
+
+		rows, err := tx.QueryContext(ctx, query, tripID)
+		if err != nil {
+			return err
+		}
+
+		queue, err := scanWaitlistEntries(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range queue {
+			if entry.Quantity > available {
+				break
+			}
+
+			booking := &Booking{
+				User_id:    entry.User_id,
+				Trip_id:    entry.Trip_id,
+				Status:     BookingStatusPending,
+				Passengers: entry.Passengers,
+			}
+			if err := createBooking(ctx, tx, s.cfg, booking); err != nil {
+				return err
+			}
+
+			err := tx.QueryRowContext(
+				ctx,
+				`UPDATE waitlist_entry SET status = 'offered', booking_id = $1, offered_at = NOW() WHERE id = $2 RETURNING status, booking_id, offered_at`,
+				booking.ID,
+				entry.ID,
+			).Scan(&entry.Status, &entry.Booking_id, &entry.Offered_at)
+			if err != nil {
+				return err
+			}
+
+			available -= entry.Quantity
+			offered = append(offered, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return offered, nil
+}
+
+func scanWaitlistEntries(rows *sql.Rows) ([]WaitlistEntry, error) {
+	var entries []WaitlistEntry
+
+	for rows.Next() {
+		var (
+			entry      WaitlistEntry
+			passengers []byte
+		)
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.Trip_id,
+			&entry.User_id,
+			&entry.Quantity,
+			&passengers,
+			&entry.Status,
+			&entry.Booking_id,
+			&entry.Offered_at,
+			&entry.Created_at,
+		); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(passengers, &entry.Passengers); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}